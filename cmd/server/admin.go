@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// adminPrefix is the path under which the admin API is served.
+const adminPrefix = "/admin/"
+
+// ClosedByAdmin is the reason sent when an operator closes a room.
+const ClosedByAdmin = "admin"
+
+// NoticePayload is a message from the server's operators to every client.
+type NoticePayload struct {
+	Message string `json:"message"`
+}
+
+// AdminPlayer describes a client in the admin API.
+type AdminPlayer struct {
+	Client      string    `json:"client"`
+	Addr        string    `json:"addr"`
+	PlayerID    string    `json:"player_id,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Room        string    `json:"room,omitempty"`
+	Bot         bool      `json:"bot,omitempty"`
+	Spectator   bool      `json:"spectator,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// AdminRoom describes a room in the admin API. Events are only included when
+// a single room is requested.
+type AdminRoom struct {
+	Code         string        `json:"code"`
+	Phase        string        `json:"phase"`
+	Restored     bool          `json:"restored,omitempty"`
+	BotsOnly     bool          `json:"bots_only,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	LastActivity time.Time     `json:"last_activity"`
+	Host         *AdminPlayer  `json:"host,omitempty"`
+	Guest        *AdminPlayer  `json:"guest,omitempty"`
+	Spectators   []AdminPlayer `json:"spectators"`
+	GamesPlayed  int           `json:"games_played"`
+	HostWins     int           `json:"host_wins"`
+	GuestWins    int           `json:"guest_wins"`
+	Events       []RoomEvent   `json:"events,omitempty"`
+}
+
+// MaintenancePayload turns maintenance mode on or off. While it is on, new
+// rooms can't be created; games in progress carry on.
+type MaintenancePayload struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// LoadToken reads a bearer token, such as the admin API's, from the first
+// line of path. kind names the token in errors.
+func LoadToken(path, kind string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s token: %w", kind, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to load %s token: %w", kind, err)
+	}
+	token := strings.TrimSpace(scanner.Text())
+	if token == "" {
+		return "", fmt.Errorf("failed to load %s token: file is empty", kind)
+	}
+	return token, nil
+}
+
+// handleAdmin authenticates and routes a request to the admin API:
+//
+//	GET  /admin/rooms                 list rooms
+//	GET  /admin/rooms/<code>          a room and its event history
+//	POST /admin/rooms/<code>/close    close a room
+//	GET  /admin/clients               list connected clients
+//	POST /admin/clients/<id>/kick     disconnect a client
+//	POST /admin/broadcast             send a notice to every client
+//	GET  /admin/maintenance           show maintenance mode
+//	POST /admin/maintenance           turn maintenance mode on or off
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(server.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminPrefix), "/"), "/")
+	logger := slog.With("admin", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+	switch {
+	case len(parts) == 1 && parts[0] == "rooms":
+		if allowMethod(w, r, http.MethodGet) {
+			adminListRooms(w)
+		}
+	case len(parts) == 2 && parts[0] == "rooms":
+		if allowMethod(w, r, http.MethodGet) {
+			adminGetRoom(w, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "rooms" && parts[2] == "close":
+		if allowMethod(w, r, http.MethodPost) {
+			adminCloseRoom(w, r, logger, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "clients":
+		if allowMethod(w, r, http.MethodGet) {
+			adminListClients(w)
+		}
+	case len(parts) == 3 && parts[0] == "clients" && parts[2] == "kick":
+		if allowMethod(w, r, http.MethodPost) {
+			adminKickClient(w, r, logger, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "broadcast":
+		if allowMethod(w, r, http.MethodPost) {
+			adminBroadcast(w, r, logger)
+		}
+	case len(parts) == 1 && parts[0] == "maintenance":
+		switch r.Method {
+		case http.MethodGet:
+			adminGetMaintenance(w)
+		case http.MethodPost:
+			adminSetMaintenance(w, r, logger)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allowMethod replies with an error and returns false unless r uses method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func adminListRooms(w http.ResponseWriter) {
+	server.mu.RLock()
+	rooms := make([]*Room, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
+	}
+	server.mu.RUnlock()
+
+	list := make([]AdminRoom, 0, len(rooms))
+	for _, room := range rooms {
+		list = append(list, describeRoom(room))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func adminGetRoom(w http.ResponseWriter, code string) {
+	room := findRoom(code)
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	info := describeRoom(room)
+	info.Events = room.events.list()
+	writeJSON(w, http.StatusOK, info)
+}
+
+func adminCloseRoom(w http.ResponseWriter, r *http.Request, logger *slog.Logger, code string) {
+	var payload NoticePayload
+	if !readJSON(w, r, &payload) {
+		return
+	}
+	if payload.Message == "" {
+		payload.Message = "The room was closed by the server's operators"
+	}
+
+	room := findRoom(code)
+	if room == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	room.mu.Lock()
+	closeRoom(room, ClosedByAdmin, payload.Message)
+	room.mu.Unlock()
+
+	logger.Info("Admin closed room", "room", room.Code)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminListClients(w http.ResponseWriter) {
+	server.mu.RLock()
+	list := make([]AdminPlayer, 0, len(server.clients))
+	for _, client := range server.clients {
+		list = append(list, describeClient(client))
+	}
+	server.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectedAt.Before(list[j].ConnectedAt) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func adminKickClient(w http.ResponseWriter, r *http.Request, logger *slog.Logger, id string) {
+	var payload NoticePayload
+	if !readJSON(w, r, &payload) {
+		return
+	}
+	if payload.Message == "" {
+		payload.Message = "Disconnected by the server's operators"
+	}
+
+	server.mu.RLock()
+	client := server.clients[id]
+	server.mu.RUnlock()
+	if client == nil {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	if room := client.Room(); room != nil {
+		logRoomEvent(room, client, EventKicked, "reason", payload.Message)
+	}
+	client.kick(payload.Message) // The read loop notices and cleans up
+	logger.Info("Admin kicked client", clientAttrs(client)...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminBroadcast(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	var payload NoticePayload
+	if !readJSON(w, r, &payload) {
+		return
+	}
+	if strings.TrimSpace(payload.Message) == "" {
+		http.Error(w, "missing message", http.StatusBadRequest)
+		return
+	}
+
+	n := broadcastNotice(payload.Message)
+	logger.Info("Admin broadcast notice", "message", payload.Message, "clients", n)
+	writeJSON(w, http.StatusOK, map[string]int{"clients": n})
+}
+
+func adminGetMaintenance(w http.ResponseWriter) {
+	server.mu.RLock()
+	payload := MaintenancePayload{Enabled: server.maintenance, Message: server.maintenanceMessage}
+	server.mu.RUnlock()
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func adminSetMaintenance(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	var payload MaintenancePayload
+	if !readJSON(w, r, &payload) {
+		return
+	}
+	if payload.Enabled && payload.Message == "" {
+		payload.Message = "The server is down for maintenance, try again later"
+	}
+	if !payload.Enabled {
+		payload.Message = ""
+	}
+
+	server.mu.Lock()
+	server.maintenance = payload.Enabled
+	server.maintenanceMessage = payload.Message
+	server.mu.Unlock()
+
+	logger.Info("Admin set maintenance mode", "enabled", payload.Enabled, "message", payload.Message)
+	writeJSON(w, http.StatusOK, payload)
+}
+
+// maintenanceMode reports whether new rooms are refused, and why.
+func maintenanceMode() (bool, string) {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.maintenance, server.maintenanceMessage
+}
+
+// broadcastNotice sends message to every connected client, returning how
+// many it was sent to.
+func broadcastNotice(message string) int {
+	server.mu.RLock()
+	clients := make([]*Client, 0, len(server.clients))
+	for _, client := range server.clients {
+		clients = append(clients, client)
+	}
+	server.mu.RUnlock()
+
+	for _, client := range clients {
+		sendJSON(client, MsgNotice, NoticePayload{Message: message})
+	}
+	return len(clients)
+}
+
+// findRoom returns the room with code, or nil.
+func findRoom(code string) *Room {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.rooms[strings.ToUpper(code)]
+}
+
+// describeRoom summarizes room for the admin API.
+func describeRoom(room *Room) AdminRoom {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	info := AdminRoom{
+		Code:         room.Code,
+		Phase:        room.phase,
+		Restored:     room.reserved != nil,
+		BotsOnly:     room.botsOnly,
+		CreatedAt:    room.CreatedAt,
+		LastActivity: room.lastActivity,
+		Spectators:   make([]AdminPlayer, 0, len(room.Spectators)),
+		GamesPlayed:  room.gamesPlayed,
+		HostWins:     room.hostWins,
+		GuestWins:    room.guestWins,
+	}
+	if room.Host != nil {
+		host := describeClient(room.Host)
+		info.Host = &host
+	}
+	if room.Guest != nil {
+		guest := describeClient(room.Guest)
+		info.Guest = &guest
+	}
+	for _, s := range room.Spectators {
+		info.Spectators = append(info.Spectators, describeClient(s))
+	}
+	return info
+}
+
+// describeClient summarizes client for the admin API.
+func describeClient(client *Client) AdminPlayer {
+	player := client.Player()
+	info := AdminPlayer{
+		Client:      client.id,
+		Addr:        client.addr,
+		PlayerID:    player.ID,
+		Name:        player.Name,
+		Bot:         client.IsBot(),
+		Spectator:   client.IsSpectator(),
+		ConnectedAt: client.connectedAt,
+	}
+	if room := client.Room(); room != nil {
+		info.Room = room.Code
+	}
+	return info
+}
+
+// readJSON decodes the request body, if any, into v, replying with an error
+// and returning false if it is malformed.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, server.config.MaxMessageSize)).Decode(v); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeJSON replies with v as JSON.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"battle-ship/game"
+)
+
+const (
+	// botName is the display name of server-hosted AI opponents. Bots have no
+	// player ID, so their games are never rated.
+	botName = "Server Bot"
+	// botThinkTime is how long a bot waits before firing, so its shots don't
+	// land the instant the human's turn ends.
+	botThinkTime = 700 * time.Millisecond
+)
+
+// botConn is the connection of a server bot. Writes are queued rather than
+// handled inline because they happen with the room lock held, and the bot
+// answers by sending messages into the same room.
+type botConn struct {
+	mu     sync.Mutex
+	queue  []Message
+	notify chan struct{}
+}
+
+func newBotConn() *botConn {
+	return &botConn{notify: make(chan struct{}, 1)}
+}
+
+// WriteJSON encodes v like a WebSocket would and queues it for the bot.
+func (c *botConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.queue = append(c.queue, msg)
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default: // The bot already has a wakeup pending
+	}
+	return nil
+}
+
+// next blocks until a message is queued and returns it.
+func (c *botConn) next() Message {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return msg
+		}
+		c.mu.Unlock()
+		<-c.notify
+	}
+}
+
+// bot plays one seat of a room with a game.AI, speaking the same protocol
+// as a remote client.
+type bot struct {
+	client *Client
+	conn   *botConn
+
+	ai             *game.AI
+	board          *game.Board
+	hostMovesFirst bool
+	placed         bool
+	opponentReady  bool
+	inBattle       bool
+}
+
+// handleCreateBotRoom creates a room for client with a server bot as guest.
+// The bot is seated as the room is created, so nobody joining by its code
+// can take its seat.
+func handleCreateBotRoom(client *Client) {
+	b := newBot()
+	if createRoom(client, false, b.client) != nil {
+		go b.run()
+	}
+}
+
+// newBot returns a server bot that hasn't joined a room yet.
+func newBot() *bot {
+	conn := newBotConn()
+	return &bot{
+		client:         &Client{conn: conn, id: newClientID(), addr: "internal", player: PlayerInfo{Name: botName}},
+		conn:           conn,
+		hostMovesFirst: true,
+	}
+}
+
+// run processes the bot's messages until its opponent leaves or ends the
+// series.
+func (b *bot) run() {
+	for {
+		msg := b.conn.next()
+		switch msg.Type {
+		case MsgGameStart:
+			b.newGame()
+
+		case MsgRematchStart:
+			var start RematchStartPayload
+			if err := json.Unmarshal(msg.Payload, &start); err == nil {
+				b.hostMovesFirst = start.HostMovesFirst
+			}
+			b.newGame()
+
+		case MsgShipsPlaced:
+			b.opponentReady = true
+			b.startBattle()
+
+		case MsgAttack:
+			var attack AttackPayload
+			if err := json.Unmarshal(msg.Payload, &attack); err != nil {
+				continue
+			}
+			b.defend(attack.Row, attack.Col)
+
+		case MsgAttackResult:
+			var result AttackResultPayload
+			if err := json.Unmarshal(msg.Payload, &result); err != nil {
+				continue
+			}
+			if result.Hit {
+				b.ai.RecordHit(result.Row, result.Col)
+			} else {
+				b.ai.RecordMiss(result.Row, result.Col)
+			}
+
+		case MsgTurnTimeout:
+			var timeout TurnTimeoutPayload
+			if err := json.Unmarshal(msg.Payload, &timeout); err != nil {
+				continue
+			}
+			if timeout.Action == TimeoutSkip && timeout.Role == RoleHost {
+				b.fire()
+			}
+
+		case MsgGameOver:
+			b.inBattle = false
+
+		case MsgRematchRequest:
+			b.send(MsgRematchRequest, struct{}{})
+
+		case MsgOpponentLeft, MsgRoomExpired, MsgJoinError:
+			return
+
+		case MsgRematchDecline:
+			// The series is over. The player isn't told again when they
+			// leave, so stop listening now.
+			return
+		}
+	}
+}
+
+// newGame places a fresh fleet at random and tells the opponent.
+func (b *bot) newGame() {
+	b.ai = game.NewAI()
+	b.board = game.NewBoard()
+	b.ai.PlaceShipsRandomly(b.board)
+	b.placed = true
+	b.opponentReady = false
+	b.inBattle = false
+
+	fleet := make([]ShipPlacement, len(b.board.Ships))
+	for i, ship := range b.board.Ships {
+		fleet[i] = ShipPlacement{Name: ship.Name, Positions: ship.Positions}
+	}
+	b.send(MsgShipsPlaced, ShipsPlacedPayload{Fleet: fleet})
+	b.startBattle()
+}
+
+// startBattle begins the battle once both fleets are placed.
+func (b *bot) startBattle() {
+	if !b.placed || !b.opponentReady || b.inBattle {
+		return
+	}
+	b.inBattle = true
+	b.placed = false
+	if !b.hostMovesFirst {
+		b.fire()
+	}
+}
+
+// defend answers an attack on the bot's board and takes the next turn.
+func (b *bot) defend(row, col int) {
+	if !b.inBattle {
+		return
+	}
+
+	hit, _, sunkShipName := b.board.Attack(row, col)
+	b.send(MsgAttackResult, AttackResultPayload{
+		Row:          row,
+		Col:          col,
+		Hit:          hit,
+		SunkShipName: sunkShipName,
+	})
+
+	if hit && b.board.AllShipsSunk() {
+		b.send(MsgGameOver, GameOverPayload{YouWon: true})
+		b.inBattle = false
+		return
+	}
+	b.fire()
+}
+
+// fire picks a target and attacks it after a short pause.
+func (b *bot) fire() {
+	if !b.inBattle {
+		return
+	}
+	time.Sleep(botThinkTime)
+	row, col := b.ai.ChooseAttack()
+	b.send(MsgAttack, AttackPayload{Row: row, Col: col})
+}
+
+// send delivers a message from the bot as if it came over the network.
+func (b *bot) send(msgType string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logError(clientLog(b.client), errorEncode, "Failed to encode bot message", err)
+		return
+	}
+	handleMessage(b.client, Message{Type: msgType, Payload: data})
+}
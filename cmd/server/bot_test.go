@@ -0,0 +1,72 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// runBot runs b and reports whether it stops within a few seconds.
+func runBot(b *bot) bool {
+	done := make(chan struct{})
+	go func() {
+		b.run()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestBotStopsAfterDeclinedRematch(t *testing.T) {
+	host, _ := newTestClient("host")
+	room := handleCreateRoom(host, false)
+	if room == nil {
+		t.Fatal("failed to create room")
+	}
+	b := newBot()
+	handleJoinRoom(b.client, room.Code)
+
+	room.mu.Lock()
+	finishGame(room, OutcomeCompleted, host, b.client)
+	room.mu.Unlock()
+	handleRematchDecline(host)
+	handleDisconnect(host)
+
+	if !runBot(b) {
+		t.Error("bot is still waiting after its opponent declined the rematch and left")
+	}
+}
+
+func TestBotSeatedWithItsRoom(t *testing.T) {
+	host, hostConn := newTestClient("host")
+	handleCreateBotRoom(host)
+	room := host.Room()
+	if room == nil {
+		t.Fatalf("failed to create bot room: %v", hostConn.types())
+	}
+
+	stranger, strangerConn := newTestClient("stranger")
+	handleJoinRoom(stranger, room.Code)
+	if stranger.Room() != nil || !slices.Contains(strangerConn.types(), MsgJoinError) {
+		t.Errorf("a stranger joined a bot room: %v", strangerConn.types())
+	}
+	room.mu.Lock()
+	guest := room.Guest
+	room.mu.Unlock()
+	if guest == nil || guest.Player().Name != botName {
+		t.Errorf("guest of a bot room is %v, want the bot", guest)
+	}
+	handleDisconnect(host)
+}
+
+func TestBotStopsWhenItCannotJoin(t *testing.T) {
+	b := newBot()
+	handleJoinRoom(b.client, "GONE")
+	if !runBot(b) {
+		t.Error("bot is still waiting after failing to join")
+	}
+}
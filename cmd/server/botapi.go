@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"battle-ship/game"
+)
+
+// botIDPrefix marks the player IDs of token-authenticated bots, keeping
+// them apart from human identities on the leaderboard and ladder.
+const botIDPrefix = "bot:"
+
+// Bot grid cells, as seen in BotStatePayload
+const (
+	cellUnknown = '.'
+	cellShip    = 'S'
+	cellMiss    = 'o'
+	cellHit     = 'x'
+	cellSunk    = '#'
+)
+
+// BotHelloPayload authenticates a bot connection.
+type BotHelloPayload struct {
+	Token string `json:"token"`
+}
+
+// BotWelcomePayload confirms a bot's identity.
+type BotWelcomePayload struct {
+	Name string `json:"name"`
+}
+
+// ShipInfo describes a ship that is still afloat.
+type ShipInfo struct {
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+}
+
+// BotStatePayload is the state of a bot room at the start of each turn.
+// Shots is the bot's view of the opponent's board and Incoming is its own
+// board, one string per row using the cell characters above.
+type BotStatePayload struct {
+	Game           int        `json:"game"`
+	Role           string     `json:"role"`
+	Turn           string     `json:"turn"`
+	YourTurn       bool       `json:"your_turn"`
+	RemainingMs    int64      `json:"remaining_ms,omitempty"`
+	Shots          []string   `json:"shots"`
+	Incoming       []string   `json:"incoming"`
+	RemainingShips []ShipInfo `json:"remaining_ships"`
+}
+
+// BotResultPayload is the outcome of a shot in a bot room.
+type BotResultPayload struct {
+	Shooter      string `json:"shooter"`
+	Row          int    `json:"row"`
+	Col          int    `json:"col"`
+	Hit          bool   `json:"hit"`
+	SunkShipName string `json:"sunk_ship_name,omitempty"`
+}
+
+// LoadBotTokens reads bot credentials from path, one "<name> <token>" pair
+// per line. Blank lines and lines starting with # are ignored.
+func LoadBotTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bot tokens: %w", err)
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("failed to load bot tokens: %s:%d: expected \"<name> <token>\"", path, line)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load bot tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// isBotID reports whether id belongs to a token-authenticated bot.
+func isBotID(id string) bool {
+	return strings.HasPrefix(id, botIDPrefix)
+}
+
+// handleBotHello authenticates a bot by its token.
+func handleBotHello(client *Client, payload BotHelloPayload) {
+	name, ok := server.botTokens[payload.Token]
+	if !ok || payload.Token == "" {
+		sendBotError(client, "Invalid bot token")
+		return
+	}
+	if client.Room() != nil {
+		sendBotError(client, "Already in a room")
+		return
+	}
+
+	client.setBot(PlayerInfo{ID: botIDPrefix + name, Name: name})
+	if err := server.ratings.Register(client.Player()); err != nil {
+		logError(clientLog(client), errorStore, "Failed to register bot", err)
+	}
+
+	sendJSON(client, MsgBotWelcome, BotWelcomePayload{Name: name})
+	clientLog(client).Info("Bot authenticated")
+}
+
+// handleFindMatch seats a bot in the oldest bot room waiting for a guest,
+// or opens a new one.
+func handleFindMatch(client *Client) {
+	if !client.IsBot() {
+		sendBotError(client, "Matchmaking is only available to bots")
+		return
+	}
+	if client.Room() != nil {
+		sendBotError(client, "Already in a room")
+		return
+	}
+
+	server.mu.RLock()
+	rooms := make([]*Room, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
+	}
+	server.mu.RUnlock()
+
+	var waiting *Room
+	for _, room := range rooms {
+		room.mu.Lock()
+		open := room.botsOnly && !room.closed && room.reserved == nil && room.Guest == nil && room.Host != nil
+		room.mu.Unlock()
+		if open && (waiting == nil || room.CreatedAt.Before(waiting.CreatedAt)) {
+			waiting = room
+		}
+	}
+
+	if waiting == nil {
+		handleCreateRoom(client, false)
+		return
+	}
+	handleJoinRoom(client, waiting.Code)
+}
+
+// placeBotFleet validates a bot's fleet and keeps its board on the server,
+// which resolves every shot in a bot room.
+// Must be called with room.mu held.
+func placeBotFleet(room *Room, sender *Client, msg Message) bool {
+	var payload ShipsPlacedPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		sendBotError(sender, "Invalid payload")
+		return false
+	}
+	board, err := buildBoard(payload.Fleet)
+	if err != nil {
+		sendBotError(sender, err.Error())
+		return false
+	}
+
+	if sender == room.Host {
+		room.hostBoard = board
+	} else {
+		room.guestBoard = board
+	}
+	return true
+}
+
+// buildBoard places fleet on a new board, checking that it is exactly the
+// standard set of ships, each laid out in a straight line from its first cell.
+func buildBoard(fleet []ShipPlacement) (*game.Board, error) {
+	defs := game.ShipDefinitions()
+	if len(fleet) != len(defs) {
+		return nil, fmt.Errorf("fleet must have %d ships", len(defs))
+	}
+
+	board := game.NewBoard()
+	for _, placement := range fleet {
+		var ship *game.Ship
+		for i, def := range defs {
+			if def != nil && def.Name == placement.Name {
+				ship = def
+				defs[i] = nil // Each ship is placed once
+				break
+			}
+		}
+		if ship == nil {
+			return nil, fmt.Errorf("unexpected ship %q", placement.Name)
+		}
+		if len(placement.Positions) != ship.Length {
+			return nil, fmt.Errorf("%s must cover %d cells", ship.Name, ship.Length)
+		}
+
+		start := placement.Positions[0]
+		horizontal := ship.Length > 1 && placement.Positions[1][0] == start[0]
+		for i, pos := range placement.Positions {
+			want := [2]int{start[0] + i, start[1]}
+			if horizontal {
+				want = [2]int{start[0], start[1] + i}
+			}
+			if pos != want {
+				return nil, fmt.Errorf("%s is not a straight line", ship.Name)
+			}
+		}
+		if !board.PlaceShip(ship, start[0], start[1], horizontal) {
+			return nil, fmt.Errorf("%s is off the board or overlaps another ship", ship.Name)
+		}
+	}
+	return board, nil
+}
+
+// handleBotMove fires a bot's shot, resolving it against the opponent's board.
+func handleBotMove(client *Client, attack AttackPayload) {
+	room := client.Room()
+	if room == nil || !client.IsBot() || client.IsSpectator() {
+		sendBotError(client, "You are not playing in a bot room")
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.closed {
+		sendBotError(client, "You are not playing in a bot room")
+		return
+	}
+	if room.phase != PhaseBattle || room.awaitingResult || roleOf(room, client) != room.turn {
+		sendBotError(client, "It is not your turn")
+		return
+	}
+	if attack.Row < 0 || attack.Row >= game.BoardSize || attack.Col < 0 || attack.Col >= game.BoardSize {
+		sendBotError(client, "Shot is off the board")
+		return
+	}
+	if shotsFiredBy(room, room.turn)[[2]int{attack.Row, attack.Col}] {
+		sendBotError(client, "Cell was already fired at")
+		return
+	}
+
+	data, _ := json.Marshal(attack)
+	if !acceptAttack(room, client, Message{Type: MsgAttack, Payload: data}) {
+		return
+	}
+	resolveBotShot(room, attack.Row, attack.Col)
+}
+
+// resolveBotShot applies an accepted shot in a bot room to the defender's
+// board, tells both bots and spectators, and moves on to the next turn.
+// Must be called with room.mu held.
+func resolveBotShot(room *Room, row, col int) {
+	shooter, defender := room.Host, room.Guest
+	board := room.guestBoard
+	if room.turn == RoleGuest {
+		shooter, defender = room.Guest, room.Host
+		board = room.hostBoard
+	}
+
+	hit, _, sunkShipName := board.Attack(row, col)
+	result := AttackResultPayload{Row: row, Col: col, Hit: hit, SunkShipName: sunkShipName}
+	data, _ := json.Marshal(result)
+	recordShot(room, defender, Message{Type: MsgAttackResult, Payload: data})
+
+	payload := BotResultPayload{Shooter: room.turn, Row: row, Col: col, Hit: hit, SunkShipName: sunkShipName}
+	sendJSON(shooter, MsgBotResult, payload)
+	sendJSON(defender, MsgBotResult, payload)
+
+	if board.AllShipsSunk() {
+		forfeit(room, defender)
+		return
+	}
+	completeAttack(room, defender)
+}
+
+// sendBotState tells both bots in room whose turn it is and what they know.
+// Must be called with room.mu held.
+func sendBotState(room *Room, remaining time.Duration) {
+	if room.hostBoard == nil || room.guestBoard == nil {
+		return
+	}
+	sendJSON(room.Host, MsgBotState, botState(room, RoleHost, room.guestBoard, room.hostBoard, remaining))
+	sendJSON(room.Guest, MsgBotState, botState(room, RoleGuest, room.hostBoard, room.guestBoard, remaining))
+}
+
+// botState builds the view of the player with role, who is firing at
+// target and defending own.
+func botState(room *Room, role string, target, own *game.Board, remaining time.Duration) BotStatePayload {
+	state := BotStatePayload{
+		Game:        room.gamesPlayed + 1,
+		Role:        role,
+		Turn:        room.turn,
+		YourTurn:    room.turn == role,
+		RemainingMs: remaining.Milliseconds(),
+		Shots:       renderGrid(target, false),
+		Incoming:    renderGrid(own, true),
+	}
+	for _, ship := range target.Ships {
+		if !ship.IsSunk() {
+			state.RemainingShips = append(state.RemainingShips, ShipInfo{Name: ship.Name, Length: ship.Length})
+		}
+	}
+	return state
+}
+
+// renderGrid draws board as rows of cell characters. Ships that have not
+// been hit are only drawn when showShips is set.
+func renderGrid(board *game.Board, showShips bool) []string {
+	sunk := make(map[[2]int]bool)
+	for _, ship := range board.Ships {
+		if ship.IsSunk() {
+			for _, pos := range ship.Positions {
+				sunk[pos] = true
+			}
+		}
+	}
+
+	rows := make([]string, game.BoardSize)
+	for r := 0; r < game.BoardSize; r++ {
+		row := make([]byte, game.BoardSize)
+		for c := 0; c < game.BoardSize; c++ {
+			switch board.Cells[r][c] {
+			case game.Miss:
+				row[c] = cellMiss
+			case game.Hit:
+				row[c] = cellHit
+				if sunk[[2]int{r, c}] {
+					row[c] = cellSunk
+				}
+			case game.ShipCell:
+				row[c] = cellUnknown
+				if showShips {
+					row[c] = cellShip
+				}
+			default:
+				row[c] = cellUnknown
+			}
+		}
+		rows[r] = string(row)
+	}
+	return rows
+}
+
+// handleGetLadder sends client the top of the bot ladder.
+func handleGetLadder(client *Client) {
+	entries, err := server.ratings.Ladder(leaderboardSize)
+	if err != nil {
+		logError(clientLog(client), errorStore, "Failed to load ladder", err)
+		sendBotError(client, "Ladder unavailable")
+		return
+	}
+	sendJSON(client, MsgLadder, LeaderboardPayload{Entries: entries})
+}
+
+// sendBotError tells a bot its request was rejected.
+func sendBotError(client *Client, message string) {
+	sendJSON(client, MsgBotError, ErrorPayload{Message: message})
+}
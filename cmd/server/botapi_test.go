@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestFindMatchRefusesPlayers(t *testing.T) {
+	client, conn := newTestClient("player")
+	handleFindMatch(client)
+	if types := conn.types(); len(types) != 1 || types[0] != MsgBotError {
+		t.Errorf("find_match from a player was answered with %v, want bot_error", types)
+	}
+	if client.Room() != nil {
+		t.Error("a player was matched with a bot")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// maxChatLength caps the length of a single chat message, in runes.
+	maxChatLength = 200
+	// chatBurst is how many chat messages a client may send back to back.
+	chatBurst = 5
+	// chatRate is how many chat messages per second a client earns back.
+	chatRate = 0.5
+)
+
+// ChatPayload is a chat message. Clients only fill in Text; the server
+// stamps the sender before relaying it to the room.
+type ChatPayload struct {
+	From   string    `json:"from"`
+	Role   string    `json:"role"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+func handleChat(client *Client, payload ChatPayload) {
+	room := client.Room()
+	if room == nil {
+		sendChatRejected(client, "You are not in a room")
+		return
+	}
+
+	text := strings.TrimSpace(withoutControl(payload.Text))
+	if text == "" {
+		return
+	}
+	if len([]rune(text)) > maxChatLength {
+		sendChatRejected(client, "Message too long")
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.closed {
+		sendChatRejected(client, "You are not in a room")
+		return
+	}
+	if client.IsSpectator() {
+		sendChatRejected(client, "Spectators can't chat")
+		return
+	}
+	if client.chatLimit == nil {
+		client.chatLimit = newTokenBucket(chatBurst, chatRate)
+	}
+	if !client.chatLimit.allow() {
+		sendChatRejected(client, "Slow down! You're sending messages too fast")
+		return
+	}
+
+	name := client.Player().Name
+	role := roleOf(room, client)
+	if name == "" {
+		name = role
+	}
+
+	chat := ChatPayload{From: name, Role: role, Text: text, SentAt: time.Now()}
+	for _, c := range roomMembers(room) {
+		sendJSON(c, MsgChat, chat)
+	}
+}
+
+func sendChatRejected(client *Client, message string) {
+	sendJSON(client, MsgChatRejected, ErrorPayload{Message: message})
+}
+
+// withoutControl removes control characters from text written by a player,
+// so it can't move other players' cursors or change their terminals.
+func withoutControl(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
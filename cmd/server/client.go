@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeWait is how long the server waits for a message to be written to a
+// client before giving up on the connection.
+const writeWait = 10 * time.Second
+
+var (
+	// errConnClosed is returned when writing to a connection that is closed.
+	errConnClosed = errors.New("connection closed")
+	// errSlowClient is returned when a client's send queue is full.
+	errSlowClient = errors.New("client is not reading its messages")
+)
+
+// Room returns the room client is in, if any.
+func (c *Client) Room() *Room {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.room
+}
+
+// setRoom records the room client is in, or nil once it left.
+func (c *Client) setRoom(room *Room) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.room = room
+}
+
+// Player returns the identity client registered with hello.
+func (c *Client) Player() PlayerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.player
+}
+
+func (c *Client) setPlayer(player PlayerInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.player = player
+}
+
+// IsBot reports whether client is a third-party program authenticated with
+// a bot token.
+func (c *Client) IsBot() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isBot
+}
+
+// setBot records that client authenticated as the bot player.
+func (c *Client) setBot(player PlayerInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isBot = true
+	c.player = player
+}
+
+// IsSpectator reports whether client watches its room rather than plays.
+func (c *Client) IsSpectator() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spectator
+}
+
+func (c *Client) setSpectator(spectator bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spectator = spectator
+}
+
+// Owner returns the instance client was handed off to, if any.
+func (c *Client) Owner() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.owner
+}
+
+func (c *Client) setOwner(instance string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.owner = instance
+}
+
+// outbound is a message waiting to be written to a client, or, if
+// closeCode is set, a request to close it once the messages before it are
+// written.
+type outbound struct {
+	data        []byte
+	closeCode   int
+	closeReason string
+}
+
+// remoteConn is the connection of a remote client. Messages are encoded by
+// the caller, queued, and written by a goroutine of the connection's own, so
+// the transport never has two writers and callers, often holding a room
+// lock, never wait for a slow client. A client that lets its queue fill up
+// is disconnected.
+type remoteConn struct {
+	t      clientTransport
+	queue  chan outbound
+	done   chan struct{}
+	stop   sync.Once
+	closed atomic.Bool // no more messages are accepted
+	logger *slog.Logger
+}
+
+// newRemoteConn starts the writer of t, which queues up to queueSize
+// messages.
+func newRemoteConn(t clientTransport, queueSize int, logger *slog.Logger) *remoteConn {
+	c := &remoteConn{
+		t:      t,
+		queue:  make(chan outbound, queueSize),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	go c.writeLoop()
+	return c
+}
+
+// WriteJSON encodes v and queues it for the client.
+func (c *remoteConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if c.closed.Load() {
+		return errConnClosed
+	}
+
+	select {
+	case c.queue <- outbound{data: data}:
+		return nil
+	default:
+	}
+
+	if c.closed.CompareAndSwap(false, true) {
+		metrics.slowClients.Add(1)
+		c.logger.Warn("Disconnecting client: too slow to read its messages", "queued", cap(c.queue))
+		go c.t.CloseWith(websocket.ClosePolicyViolation, "Too slow to keep up with the game")
+	}
+	return errSlowClient
+}
+
+// Close sends the client a close frame with code and reason after the
+// messages already queued, and closes the connection.
+func (c *remoteConn) Close(code int, reason string) {
+	if !c.closed.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case c.queue <- outbound{closeCode: code, closeReason: reason}:
+	default:
+		go c.t.CloseWith(code, reason) // The queue is full; skip what is in it
+	}
+}
+
+// Closed reports whether the server has closed, or is closing, the
+// connection.
+func (c *remoteConn) Closed() bool {
+	return c.closed.Load()
+}
+
+// shutdown stops the writer once the connection's read loop is done.
+func (c *remoteConn) shutdown() {
+	c.closed.Store(true)
+	c.stop.Do(func() { close(c.done) })
+}
+
+func (c *remoteConn) writeLoop() {
+	for {
+		select {
+		case out := <-c.queue:
+			if out.closeCode != 0 {
+				c.t.CloseWith(out.closeCode, out.closeReason)
+				return
+			}
+			if err := c.t.WriteMessage(out.data); err != nil {
+				// The read loop sees the connection fail too, and cleans up
+				c.closed.Store(true)
+				c.logger.Debug("Failed to write message", "err", err)
+				c.t.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"battle-ship/game"
+)
+
+// Room phases, tracked from the messages the server relays
+const (
+	PhaseWaiting   = "waiting"   // Host is waiting for a guest
+	PhasePlacement = "placement" // Both players are placing ships
+	PhaseBattle    = "battle"    // Players take turns firing
+	PhaseFinished  = "finished"  // Game over, a rematch may follow
+)
+
+// What happens when a player runs out of time on their turn
+const (
+	TimeoutSkip    = "skip"    // The turn passes to the opponent
+	TimeoutRandom  = "random"  // A random shot is fired for the player
+	TimeoutForfeit = "forfeit" // The player loses the game
+)
+
+// AttackPayload is a shot fired at the opponent.
+type AttackPayload struct {
+	Row int `json:"row"`
+	Col int `json:"col"`
+}
+
+// TimerPayload starts a countdown on the clients. Role is the player on the
+// clock, or empty for the placement deadline which applies to both.
+type TimerPayload struct {
+	Role        string `json:"role,omitempty"`
+	RemainingMs int64  `json:"remaining_ms"`
+}
+
+// TurnTimeoutPayload tells both players that Role ran out of time and what
+// the server did about it. Row and Col are set for random shots.
+type TurnTimeoutPayload struct {
+	Role     string `json:"role"`
+	Action   string `json:"action"`
+	Timeouts int    `json:"timeouts"`
+	Row      int    `json:"row"`
+	Col      int    `json:"col"`
+}
+
+// startPlacement moves a room with two players into ship placement.
+// Must be called with room.mu held.
+func startPlacement(room *Room) {
+	metrics.gamesStarted.Add(1)
+	logRoomEvent(room, nil, EventPlacement, "game", room.gamesPlayed+1)
+	room.phase = PhasePlacement
+	room.hostReady = false
+	room.guestReady = false
+	room.awaitingResult = false
+	room.hostShots = make(map[[2]int]bool)
+	room.guestShots = make(map[[2]int]bool)
+	room.hostTimeouts = 0
+	room.guestTimeouts = 0
+	room.hostBoard = nil
+	room.guestBoard = nil
+
+	timeout := server.config.PlacementTimeout
+	if timeout <= 0 {
+		stopClock(room)
+		return
+	}
+
+	payload := TimerPayload{RemainingMs: timeout.Milliseconds()}
+	sendJSON(room.Host, MsgPlacementTimer, payload)
+	sendJSON(room.Guest, MsgPlacementTimer, payload)
+	startClock(room, timeout, placementExpired)
+}
+
+// markPlaced records that a player finished placing ships, starting the
+// battle once both have.
+// Must be called with room.mu held.
+func markPlaced(room *Room, sender *Client) {
+	if room.phase != PhasePlacement {
+		return
+	}
+	if sender == room.Host {
+		room.hostReady = true
+	} else {
+		room.guestReady = true
+	}
+	if !room.hostReady || !room.guestReady {
+		return
+	}
+
+	room.phase = PhaseBattle
+	room.turn = RoleHost
+	if room.gamesPlayed%2 == 1 {
+		room.turn = RoleGuest
+	}
+	logRoomEvent(room, nil, EventBattle, "first", room.turn)
+	startTurn(room)
+}
+
+// acceptAttack checks that an attack was fired in turn, and if so records
+// it and stops the shooter's clock while the defender answers.
+// Must be called with room.mu held.
+func acceptAttack(room *Room, sender *Client, msg Message) bool {
+	if room.phase != PhaseBattle || room.awaitingResult || roleOf(room, sender) != room.turn {
+		return false
+	}
+
+	var attack AttackPayload
+	if err := json.Unmarshal(msg.Payload, &attack); err != nil {
+		return false
+	}
+	shotsFiredBy(room, room.turn)[[2]int{attack.Row, attack.Col}] = true
+
+	room.awaitingResult = true
+	stopClock(room)
+	return true
+}
+
+// completeAttack hands the turn to the defender once they answer an attack.
+// Must be called with room.mu held.
+func completeAttack(room *Room, sender *Client) {
+	if room.phase != PhaseBattle || !room.awaitingResult || roleOf(room, sender) == room.turn {
+		return
+	}
+	room.awaitingResult = false
+	room.turn = roleOf(room, sender)
+	startTurn(room)
+}
+
+// startTurn starts the clock for the player whose turn it is.
+// Must be called with room.mu held.
+func startTurn(room *Room) {
+	timeout := server.config.TurnTimeout
+	if room.botsOnly {
+		sendBotState(room, timeout)
+	}
+	if timeout <= 0 {
+		return
+	}
+
+	payload := TimerPayload{Role: room.turn, RemainingMs: timeout.Milliseconds()}
+	sendJSON(room.Host, MsgTurnTimer, payload)
+	sendJSON(room.Guest, MsgTurnTimer, payload)
+	startClock(room, timeout, turnExpired)
+}
+
+// startClock arms the room's single timer. Older timers are invalidated by
+// bumping the clock generation, so a timer that fires late does nothing.
+// Must be called with room.mu held.
+func startClock(room *Room, d time.Duration, expired func(*Room)) {
+	stopClock(room)
+	gen := room.clockGen
+	room.clock = time.AfterFunc(d, func() {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		if room.clockGen == gen {
+			expired(room)
+		}
+	})
+}
+
+// stopClock cancels the room's timer, if any.
+// Must be called with room.mu held.
+func stopClock(room *Room) {
+	room.clockGen++
+	if room.clock != nil {
+		room.clock.Stop()
+		room.clock = nil
+	}
+}
+
+// turnExpired applies the configured timeout action to the player on the clock.
+// Must be called with room.mu held.
+func turnExpired(room *Room) {
+	if room.phase != PhaseBattle || room.Host == nil || room.Guest == nil {
+		return
+	}
+
+	shooter, defender := room.Host, room.Guest
+	timeouts := &room.hostTimeouts
+	if room.turn == RoleGuest {
+		shooter, defender = room.Guest, room.Host
+		timeouts = &room.guestTimeouts
+	}
+	*timeouts++
+
+	action := server.config.TimeoutAction
+	if limit := server.config.MaxTimeouts; limit > 0 && *timeouts >= limit {
+		action = TimeoutForfeit
+	}
+
+	logRoomEvent(room, shooter, EventTimeout, "role", room.turn, "action", action, "timeouts", *timeouts)
+
+	payload := TurnTimeoutPayload{Role: room.turn, Action: action, Timeouts: *timeouts}
+	switch action {
+	case TimeoutForfeit:
+		sendJSON(shooter, MsgTurnTimeout, payload)
+		sendJSON(defender, MsgTurnTimeout, payload)
+		forfeit(room, shooter)
+
+	case TimeoutRandom:
+		row, col, ok := randomShot(shotsFiredBy(room, room.turn))
+		if !ok {
+			return
+		}
+		payload.Row, payload.Col = row, col
+		sendJSON(shooter, MsgTurnTimeout, payload)
+		sendJSON(defender, MsgTurnTimeout, payload)
+
+		// Fire on the shooter's behalf; the defender answers as usual
+		shotsFiredBy(room, room.turn)[[2]int{row, col}] = true
+		room.awaitingResult = true
+		if room.botsOnly {
+			resolveBotShot(room, row, col)
+		} else {
+			sendJSON(defender, MsgAttack, AttackPayload{Row: row, Col: col})
+		}
+
+	default: // TimeoutSkip
+		sendJSON(shooter, MsgTurnTimeout, payload)
+		sendJSON(defender, MsgTurnTimeout, payload)
+		room.turn = roleOf(room, defender)
+		startTurn(room)
+	}
+}
+
+// placementExpired forfeits the game for whoever has not placed their ships.
+// Must be called with room.mu held.
+func placementExpired(room *Room) {
+	if room.phase != PhasePlacement || room.Host == nil || room.Guest == nil {
+		return
+	}
+
+	logRoomEvent(room, nil, EventTimeout, "phase", PhasePlacement, "host_ready", room.hostReady, "guest_ready", room.guestReady)
+
+	switch {
+	case room.hostReady:
+		forfeit(room, room.Guest)
+	case room.guestReady:
+		forfeit(room, room.Host)
+	default:
+		// Neither player is ready, so nobody wins
+		sendJSON(room.Host, MsgGameOver, GameOverPayload{YouWon: false})
+		sendJSON(room.Guest, MsgGameOver, GameOverPayload{YouWon: false})
+		finishGame(room, OutcomeNoContest, nil, nil)
+	}
+}
+
+// forfeit ends the game in favor of loser's opponent.
+// Must be called with room.mu held.
+func forfeit(room *Room, loser *Client) {
+	winner := room.Host
+	if loser == room.Host {
+		winner = room.Guest
+	}
+	sendJSON(loser, MsgGameOver, GameOverPayload{YouWon: false})
+	sendJSON(winner, MsgGameOver, GameOverPayload{YouWon: true})
+	finishGame(room, OutcomeCompleted, winner, loser)
+}
+
+// shotsFiredBy returns the cells the player with role has fired at.
+func shotsFiredBy(room *Room, role string) map[[2]int]bool {
+	if role == RoleGuest {
+		return room.guestShots
+	}
+	return room.hostShots
+}
+
+// randomShot picks a random cell that has not been fired at yet.
+func randomShot(fired map[[2]int]bool) (int, int, bool) {
+	var open [][2]int
+	for r := 0; r < game.BoardSize; r++ {
+		for c := 0; c < game.BoardSize; c++ {
+			if !fired[[2]int{r, c}] {
+				open = append(open, [2]int{r, c})
+			}
+		}
+	}
+	if len(open) == 0 {
+		return 0, 0, false
+	}
+	cell := open[rand.Intn(len(open))]
+	return cell[0], cell[1], true
+}
+
+// roleOf returns whether client is the host or guest of room.
+func roleOf(room *Room, client *Client) string {
+	if client == room.Host {
+		return RoleHost
+	}
+	return RoleGuest
+}
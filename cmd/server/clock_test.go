@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlacementExpiredWithNeitherReady(t *testing.T) {
+	room, host, _, hostConn, guestConn := startTestGame(t)
+	spectator, spectatorConn := newTestClient("spectator")
+	handleSpectateRoom(spectator, room.Code)
+	noContests := metrics.gamesFinished.snapshot()[OutcomeNoContest]
+
+	room.mu.Lock()
+	placementExpired(room)
+	room.mu.Unlock()
+
+	if !room.finished || room.phase != PhaseFinished || room.winner != "" {
+		t.Errorf("room finished %v in phase %q won by %q, want finished with no winner", room.finished, room.phase, room.winner)
+	}
+	if room.hostWins != 0 || room.guestWins != 0 {
+		t.Errorf("series is %d-%d, want 0-0", room.hostWins, room.guestWins)
+	}
+	if got := metrics.gamesFinished.snapshot()[OutcomeNoContest]; got != noContests+1 {
+		t.Errorf("no-contest games went from %d to %d, want one more", noContests, got)
+	}
+	for _, conn := range []*fakeConn{hostConn, guestConn} {
+		if types := conn.types(); !slices.Contains(types, MsgGameOver) {
+			t.Errorf("player was not told the game is over: %v", types)
+		}
+	}
+	if types := spectatorConn.types(); !slices.Contains(types, MsgSpectateGameOver) || !slices.Contains(types, MsgFleetReveal) {
+		t.Errorf("spectator was not told the game is over: %v", types)
+	}
+	if games, _ := server.store.Games(0); slices.ContainsFunc(games, func(g GameRecord) bool { return g.RoomCode == room.Code }) {
+		t.Error("a game without a winner was rated")
+	}
+
+	// The room is ready for a rematch like after any other game
+	handleRematchRequest(host)
+	if !room.hostWantsRematch {
+		t.Error("rematch request after a no-contest was ignored")
+	}
+}
+
+func TestPlacementExpiredWithOneReady(t *testing.T) {
+	room, host, _, _, _ := startTestGame(t)
+
+	room.mu.Lock()
+	markPlaced(room, host)
+	placementExpired(room)
+	room.mu.Unlock()
+
+	if room.winner != RoleHost || room.hostWins != 1 {
+		t.Errorf("room won by %q with host wins %d, want the host to win", room.winner, room.hostWins)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Kinds of envelopes sent between instances
+const (
+	// EnvelopeMessage carries a message from a handed-off client to the
+	// instance hosting its room.
+	EnvelopeMessage = "message"
+	// EnvelopeLeave tells the hosting instance a handed-off client disconnected.
+	EnvelopeLeave = "leave"
+	// EnvelopeDeliver carries a message for a handed-off client back to the
+	// instance holding its connection.
+	EnvelopeDeliver = "deliver"
+	// EnvelopeKick asks the instance holding a connection to close it.
+	EnvelopeKick = "kick"
+)
+
+var (
+	// errRoomCodeTaken is returned when claiming a code another room has.
+	errRoomCodeTaken = errors.New("room code taken")
+	// errUnknownInstance is returned when publishing to an instance that
+	// isn't subscribed.
+	errUnknownInstance = errors.New("unknown instance")
+)
+
+// Cluster lets several server instances share the rooms players see. Each
+// room lives on the instance where it was created. The registry records
+// which one, and a player whose connection lands on another instance is
+// handed off to it over the message bus.
+type Cluster interface {
+	RoomRegistry
+	MessageBus
+	Close() error
+}
+
+// RoomRegistry records which instance hosts each room.
+type RoomRegistry interface {
+	// ClaimRoom records that instance hosts the room with code, or returns
+	// errRoomCodeTaken if a room on any instance already has it.
+	ClaimRoom(code, instance string) error
+	// LookupRoom returns the instance hosting the room with code, if any.
+	LookupRoom(code string) (string, bool, error)
+	// ReleaseRoom forgets the room with code, if instance hosts it. It is
+	// called with the room's lock held, so it must not wait for the network.
+	ReleaseRoom(code, instance string) error
+}
+
+// MessageBus carries envelopes between instances.
+type MessageBus interface {
+	// Publish sends env to instance, or returns errUnknownInstance if no
+	// such instance is subscribed.
+	Publish(instance string, env Envelope) error
+	// Subscribe calls deliver with each envelope published to instance,
+	// one at a time and in order.
+	Subscribe(instance string, deliver func(Envelope)) error
+}
+
+// Envelope is a message between instances about a handed-off client.
+type Envelope struct {
+	Kind    string          `json:"kind"`
+	From    string          `json:"from"`   // Sending instance
+	Client  string          `json:"client"` // Client ID on the instance holding its connection
+	Addr    string          `json:"addr,omitempty"`
+	Player  PlayerInfo      `json:"player"`
+	IsBot   bool            `json:"is_bot,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+	Reason  string          `json:"reason,omitempty"` // Why a client is kicked
+}
+
+// localCluster is a Cluster within one process. It is used when the server
+// runs as a single instance, and backs the broker that connects several.
+type localCluster struct {
+	mu          sync.Mutex
+	rooms       map[string]string   // room code -> instance
+	subscribers map[string]*mailbox // instance -> its envelopes
+}
+
+// NewLocalCluster creates a Cluster for instances in this process.
+func NewLocalCluster() Cluster {
+	return newLocalCluster()
+}
+
+func newLocalCluster() *localCluster {
+	return &localCluster{
+		rooms:       make(map[string]string),
+		subscribers: make(map[string]*mailbox),
+	}
+}
+
+func (c *localCluster) ClaimRoom(code, instance string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if owner, taken := c.rooms[code]; taken && owner != instance {
+		return errRoomCodeTaken
+	}
+	c.rooms[code] = instance
+	return nil
+}
+
+func (c *localCluster) LookupRoom(code string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	instance, found := c.rooms[code]
+	return instance, found, nil
+}
+
+func (c *localCluster) ReleaseRoom(code, instance string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rooms[code] == instance {
+		delete(c.rooms, code)
+	}
+	return nil
+}
+
+func (c *localCluster) Publish(instance string, env Envelope) error {
+	c.mu.Lock()
+	box, ok := c.subscribers[instance]
+	c.mu.Unlock()
+	if !ok {
+		return errUnknownInstance
+	}
+	box.put(env)
+	return nil
+}
+
+func (c *localCluster) Subscribe(instance string, deliver func(Envelope)) error {
+	_, err := c.subscribe(instance, deliver, false)
+	return err
+}
+
+// subscribe is Subscribe for the broker. A remote instance that reconnects
+// may subscribe before the broker notices its old connection is gone, so a
+// remote subscription replaces an earlier remote one. It returns the
+// subscription's mailbox, which unsubscribe takes.
+func (c *localCluster) subscribe(instance string, deliver func(Envelope), remote bool) (*mailbox, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.subscribers[instance]; ok {
+		if !remote || !old.remote {
+			return nil, fmt.Errorf("instance %q is already subscribed", instance)
+		}
+		old.close()
+	}
+	box := newMailbox(deliver)
+	box.remote = remote
+	c.subscribers[instance] = box
+	return box, nil
+}
+
+// unsubscribe stops delivering to box and releases the rooms of instance,
+// once it has gone away, unless it has subscribed again since.
+func (c *localCluster) unsubscribe(instance string, box *mailbox) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribers[instance] != box {
+		return
+	}
+	box.close()
+	delete(c.subscribers, instance)
+	for code, owner := range c.rooms {
+		if owner == instance {
+			delete(c.rooms, code)
+		}
+	}
+}
+
+func (c *localCluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for instance, box := range c.subscribers {
+		box.close()
+		delete(c.subscribers, instance)
+	}
+	return nil
+}
+
+// mailbox queues envelopes for a subscriber and delivers them from its own
+// goroutine, so publishing never waits for the subscriber to handle them.
+type mailbox struct {
+	mu      sync.Mutex
+	queue   []Envelope
+	closed  bool
+	notify  chan struct{}
+	deliver func(Envelope)
+	remote  bool // subscribed through the broker
+}
+
+func newMailbox(deliver func(Envelope)) *mailbox {
+	m := &mailbox{notify: make(chan struct{}, 1), deliver: deliver}
+	go m.run()
+	return m
+}
+
+func (m *mailbox) put(env Envelope) {
+	m.mu.Lock()
+	m.queue = append(m.queue, env)
+	m.mu.Unlock()
+	m.wake()
+}
+
+func (m *mailbox) close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.wake()
+}
+
+func (m *mailbox) wake() {
+	select {
+	case m.notify <- struct{}{}:
+	default: // A wakeup is already pending
+	}
+}
+
+func (m *mailbox) run() {
+	for range m.notify {
+		for {
+			m.mu.Lock()
+			if m.closed {
+				m.mu.Unlock()
+				return
+			}
+			if len(m.queue) == 0 {
+				m.mu.Unlock()
+				break
+			}
+			env := m.queue[0]
+			m.queue = m.queue[1:]
+			m.mu.Unlock()
+
+			m.deliver(env)
+		}
+	}
+}
+
+// newInstanceID returns a random ID for this instance.
+func newInstanceID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate instance ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// removeRoom marks room torn down and forgets it, here and in the cluster
+// registry, unless a new room has already taken its code.
+// Must be called with room.mu held.
+func removeRoom(room *Room) {
+	room.closed = true
+
+	server.mu.Lock()
+	removed := server.rooms[room.Code] == room
+	if removed {
+		delete(server.rooms, room.Code)
+	}
+	server.mu.Unlock()
+
+	if !removed {
+		return
+	}
+	if err := server.cluster.ReleaseRoom(room.Code, server.instance); err != nil {
+		logError(roomLog(room), errorCluster, "Failed to release room", err)
+	}
+}
+
+// handOff passes client to the instance hosting the room with code, if that
+// is another instance, and forwards msg there. From then on every message
+// from client is handled by that instance. It reports whether client was
+// handed off.
+func handOff(client *Client, code string, msg Message) bool {
+	if client.Room() != nil || client.via != "" {
+		return false // Leave a room before moving; never pass a client on twice
+	}
+	code = strings.ToUpper(code)
+	server.mu.RLock()
+	_, local := server.rooms[code]
+	server.mu.RUnlock()
+	if local {
+		return false
+	}
+
+	instance, found, err := server.cluster.LookupRoom(code)
+	if err != nil {
+		logError(clientLog(client), errorCluster, "Failed to look up room", err, "code", code)
+		return false
+	}
+	if !found || instance == server.instance {
+		return false
+	}
+
+	client.setOwner(instance)
+	if err := forwardToOwner(client, msg); err != nil {
+		logError(clientLog(client), errorCluster, "Failed to hand off client", err, "instance", instance)
+		client.setOwner("")
+		sendError(client, "Room unavailable, try again")
+		return true
+	}
+	clientLog(client).Info("Client handed off", "instance", instance, "code", code)
+	return true
+}
+
+// forwardToOwner sends msg from a handed-off client to the instance hosting
+// its room.
+func forwardToOwner(client *Client, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return server.cluster.Publish(client.Owner(), Envelope{
+		Kind:    EnvelopeMessage,
+		From:    server.instance,
+		Client:  client.id,
+		Addr:    client.addr,
+		Player:  client.Player(),
+		IsBot:   client.IsBot(),
+		Message: data,
+	})
+}
+
+// handleOwnedMessage forwards a message from a handed-off client. If its
+// instance has gone, the client is told its game is over and is served here
+// again.
+func handleOwnedMessage(client *Client, msg Message) {
+	if err := forwardToOwner(client, msg); err != nil {
+		logError(clientLog(client), errorCluster, "Failed to forward message", err, "instance", client.Owner())
+		client.setOwner("")
+		client.conn.WriteJSON(Message{Type: MsgOpponentLeft, Payload: []byte("{}")})
+	}
+}
+
+// leaveOwner tells the instance hosting a handed-off client's room that the
+// client disconnected.
+func leaveOwner(client *Client) {
+	err := server.cluster.Publish(client.Owner(), Envelope{Kind: EnvelopeLeave, From: server.instance, Client: client.id})
+	if err != nil {
+		logError(clientLog(client), errorCluster, "Failed to forward disconnect", err, "instance", client.Owner())
+	}
+}
+
+// busTransport carries the messages of a client handed off from another
+// instance: they are published back to that instance, which holds the
+// client's connection. It is written to through a remoteConn, so callers
+// holding a room lock never wait for the bus.
+type busTransport struct {
+	instance string
+	client   string
+}
+
+func (t *busTransport) ReadMessage(*Message) error {
+	return errors.New("messages from a handed-off client arrive as envelopes")
+}
+
+func (t *busTransport) WriteMessage(data []byte) error {
+	return server.cluster.Publish(t.instance, Envelope{Kind: EnvelopeDeliver, From: server.instance, Client: t.client, Message: data})
+}
+
+func (t *busTransport) CloseWith(code int, reason string) {
+	err := server.cluster.Publish(t.instance, Envelope{Kind: EnvelopeKick, From: server.instance, Client: t.client, Reason: reason})
+	if err != nil {
+		logError(slog.With("instance", t.instance, "client", t.client), errorCluster, "Failed to forward kick", err)
+	}
+}
+
+func (t *busTransport) Close() error {
+	return nil
+}
+
+// handleEnvelope handles an envelope published to this instance.
+func handleEnvelope(env Envelope) {
+	switch env.Kind {
+	case EnvelopeMessage:
+		var msg Message
+		if err := json.Unmarshal(env.Message, &msg); err != nil {
+			logError(slog.With("instance", env.From, "client", env.Client), errorCluster, "Failed to decode forwarded message", err)
+			return
+		}
+		client := handedOffClient(env)
+		handleMessage(client, msg)
+		if misbehaving(client) {
+			clientLog(client).Warn("Disconnecting client: too many rejected messages", "rejected", client.rejected)
+			client.kick("Too many invalid or excessive messages")
+		}
+	case EnvelopeLeave:
+		id := env.From + ":" + env.Client
+		server.mu.Lock()
+		client := server.clients[id]
+		delete(server.clients, id)
+		server.mu.Unlock()
+		if client != nil {
+			clientLog(client).Info("Client disconnected")
+			handleDisconnect(client)
+			client.conn.(*remoteConn).shutdown()
+		}
+	case EnvelopeDeliver, EnvelopeKick:
+		server.mu.RLock()
+		client := server.clients[env.Client]
+		server.mu.RUnlock()
+		if client == nil || client.Owner() != env.From {
+			return // Gone, or no longer handed off to the sender
+		}
+		if env.Kind == EnvelopeKick {
+			if client.kick != nil {
+				client.kick(env.Reason)
+			}
+			return
+		}
+		client.conn.WriteJSON(env.Message)
+	}
+}
+
+// handedOffClient returns the stand-in for the client an envelope came from,
+// creating it on its first message.
+func handedOffClient(env Envelope) *Client {
+	id := env.From + ":" + env.Client
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if client, ok := server.clients[id]; ok {
+		return client
+	}
+
+	t := &busTransport{instance: env.From, client: env.Client}
+	conn := newRemoteConn(t, server.config.SendQueueSize, slog.With("client", id, "addr", env.Addr))
+	client := &Client{
+		conn:        conn,
+		id:          id,
+		addr:        env.Addr,
+		connectedAt: time.Now(),
+		kick: func(reason string) {
+			conn.Close(websocket.ClosePolicyViolation, reason)
+		},
+		via:    env.From,
+		player: env.Player,
+		isBot:  env.IsBot,
+	}
+	server.clients[id] = client
+	clientLog(client).Info("Handed-off client arrived", "instance", env.From)
+	return client
+}
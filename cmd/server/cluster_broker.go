@@ -0,0 +1,625 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// brokerPath is where the broker accepts connections from instances.
+	brokerPath = "/cluster"
+	// brokerTimeout is how long an instance waits for the broker to answer.
+	brokerTimeout = 5 * time.Second
+	// brokerQueueSize is how many requests may wait to be written to the
+	// broker.
+	brokerQueueSize = 1024
+	// brokerRetryMin and brokerRetryMax bound the wait between attempts to
+	// reconnect to the broker, which doubles after each failed one.
+	brokerRetryMin = 500 * time.Millisecond
+	brokerRetryMax = 30 * time.Second
+	// brokerMaxMessageSize caps what the broker and instances read from
+	// each other. Envelopes carry messages the server sends to clients,
+	// such as a spectator's view of a whole game, so it is well above a
+	// client's limit.
+	brokerMaxMessageSize = 1 << 20
+)
+
+// errBrokerBusy is returned when too many requests are waiting to be
+// written to the broker.
+var errBrokerBusy = errors.New("too many requests waiting for the broker")
+
+// Broker operations
+const (
+	brokerClaim     = "claim"
+	brokerLookup    = "lookup"
+	brokerRelease   = "release"
+	brokerPublish   = "publish"
+	brokerSubscribe = "subscribe"
+
+	brokerIdentify     = "identify"
+	brokerRegister     = "register"
+	brokerRecordResult = "record_result"
+	brokerLeaderboard  = "leaderboard"
+	brokerLadder       = "ladder"
+)
+
+// brokerRequest is sent by an instance to the broker.
+type brokerRequest struct {
+	ID       uint64    `json:"id"`
+	Op       string    `json:"op"`
+	Code     string    `json:"code,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Envelope *Envelope `json:"envelope,omitempty"`
+
+	// Player is the player to identify or register, or the winner of a game
+	// to record, and Loser its loser
+	Player *PlayerInfo `json:"player,omitempty"`
+	Loser  *PlayerInfo `json:"loser,omitempty"`
+	Secret string      `json:"secret,omitempty"`
+	Limit  int         `json:"limit,omitempty"`
+}
+
+// brokerResponse is sent by the broker to an instance: the answer to the
+// request with the same ID, or, with ID zero, an envelope published to it.
+type brokerResponse struct {
+	ID       uint64    `json:"id"`
+	Error    string    `json:"error,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Found    bool      `json:"found,omitempty"`
+	Envelope *Envelope `json:"envelope,omitempty"`
+
+	Secret  string             `json:"secret,omitempty"`
+	Record  *GameRecord        `json:"record,omitempty"`
+	Entries []LeaderboardEntry `json:"entries,omitempty"`
+}
+
+// brokerErrors are the errors callers check for, by their text on the wire.
+var brokerErrors = []error{errRoomCodeTaken, errUnknownInstance, errWrongSecret}
+
+// Broker connects the instances of a cluster. It keeps the room registry
+// and the players for all of them and routes envelopes between them over
+// WebSockets. It runs inside one of the instances, which uses its registry
+// and ratings directly.
+type Broker struct {
+	cluster *localCluster
+	ratings *Ratings
+	token   string
+}
+
+// NewBroker creates a broker for instances presenting token, backed by
+// cluster and ratings, which the instance running the broker uses itself.
+func NewBroker(cluster Cluster, ratings *Ratings, token string) (*Broker, error) {
+	local, ok := cluster.(*localCluster)
+	if !ok {
+		return nil, errors.New("the broker needs an in-process cluster")
+	}
+	return &Broker{cluster: local, ratings: ratings, token: token}, nil
+}
+
+// ServeHTTP accepts an instance's connection and answers its requests until
+// it disconnects, when the rooms it hosted are released.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if b.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(b.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logError(slog.With("addr", r.RemoteAddr), errorCluster, "Failed to upgrade broker connection", err)
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(brokerMaxMessageSize)
+
+	logger := slog.With("broker_peer", r.RemoteAddr)
+	var writeMu sync.Mutex
+	send := func(resp brokerResponse) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := ws.WriteJSON(resp); err != nil {
+			logger.Warn("Failed to write to instance", "err", err)
+		}
+	}
+
+	subscribed := make(map[string]*mailbox)
+	defer func() {
+		for instance, box := range subscribed {
+			b.cluster.unsubscribe(instance, box)
+			logger.Info("Instance left the cluster", "instance", instance)
+		}
+	}()
+
+	for {
+		var req brokerRequest
+		if err := ws.ReadJSON(&req); err != nil {
+			if !errors.As(err, new(*websocket.CloseError)) {
+				logError(logger, errorCluster, "Failed to read from instance", err)
+			}
+			return
+		}
+
+		resp := brokerResponse{ID: req.ID}
+		switch req.Op {
+		case brokerClaim:
+			err = b.cluster.ClaimRoom(req.Code, req.Instance)
+		case brokerLookup:
+			resp.Instance, resp.Found, err = b.cluster.LookupRoom(req.Code)
+		case brokerRelease:
+			err = b.cluster.ReleaseRoom(req.Code, req.Instance)
+		case brokerPublish:
+			if req.Envelope == nil {
+				err = errors.New("missing envelope")
+				break
+			}
+			err = b.cluster.Publish(req.Instance, *req.Envelope)
+		case brokerSubscribe:
+			var box *mailbox
+			box, err = b.cluster.subscribe(req.Instance, func(env Envelope) {
+				send(brokerResponse{Envelope: &env})
+			}, true)
+			if err == nil {
+				subscribed[req.Instance] = box
+				logger.Info("Instance joined the cluster", "instance", req.Instance)
+			}
+		case brokerIdentify, brokerRegister, brokerRecordResult, brokerLeaderboard, brokerLadder:
+			err = b.rate(req, &resp)
+		default:
+			err = fmt.Errorf("unknown operation %q", req.Op)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		send(resp)
+	}
+}
+
+// rate answers a request about players and their ratings.
+func (b *Broker) rate(req brokerRequest, resp *brokerResponse) error {
+	var err error
+	switch req.Op {
+	case brokerLeaderboard:
+		resp.Entries, err = b.ratings.Leaderboard(req.Limit)
+		return err
+	case brokerLadder:
+		resp.Entries, err = b.ratings.Ladder(req.Limit)
+		return err
+	}
+
+	if req.Player == nil {
+		return errors.New("missing player")
+	}
+	switch req.Op {
+	case brokerIdentify:
+		resp.Secret, err = b.ratings.Identify(*req.Player, req.Secret)
+	case brokerRegister:
+		err = b.ratings.Register(*req.Player)
+	case brokerRecordResult:
+		if req.Loser == nil {
+			return errors.New("missing loser")
+		}
+		var record GameRecord
+		record, err = b.ratings.RecordResult(req.Code, *req.Player, *req.Loser)
+		resp.Record = &record
+	}
+	return err
+}
+
+// brokerCluster is a Cluster whose registry and bus are provided by a
+// broker running in another instance. Requests are queued and written by a
+// goroutine of the cluster's own, so releasing a room, which callers do
+// holding a room lock, never waits for the broker. When the connection is
+// lost, it reconnects with backoff, subscribes again and claims its rooms
+// again, which the broker released.
+type brokerCluster struct {
+	url    string
+	header http.Header
+	queue  chan brokerRequest
+	done   chan struct{}
+
+	mu         sync.Mutex
+	ws         *websocket.Conn // nil while reconnecting
+	nextID     uint64
+	staleBelow uint64 // requests queued before the connection was lost
+	pending    map[uint64]chan brokerResponse
+	inbox      *mailbox
+	subscribed string            // instance subscribed to envelopes
+	claimed    map[string]string // room code -> instance, for claiming again
+	err        error             // set while the connection is down
+	closed     bool
+}
+
+// DialBroker connects to the broker at url, presenting token.
+func DialBroker(url, token string) (Cluster, error) {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	c := &brokerCluster{
+		url:     url,
+		header:  header,
+		queue:   make(chan brokerRequest, brokerQueueSize),
+		done:    make(chan struct{}),
+		pending: make(map[uint64]chan brokerResponse),
+		claimed: make(map[string]string),
+	}
+	ws, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.ws = ws
+
+	go c.run(ws)
+	go c.writeLoop()
+	return c, nil
+}
+
+func (c *brokerCluster) dial() (*websocket.Conn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(c.url, c.header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker: %w", err)
+	}
+	ws.SetReadLimit(brokerMaxMessageSize)
+	return ws, nil
+}
+
+// run reads from the broker, reconnecting whenever the connection is lost,
+// until the cluster is closed.
+func (c *brokerCluster) run(ws *websocket.Conn) {
+	for {
+		err := c.readLoop(ws)
+		if c.isClosed() {
+			return
+		}
+		logError(slog.Default(), errorCluster, "Lost connection to broker", err)
+		c.fail(fmt.Errorf("lost connection to broker: %w", err))
+
+		if ws = c.reconnect(); ws == nil {
+			return
+		}
+		go c.restore()
+	}
+}
+
+// readLoop hands answers to the requests waiting for them and envelopes to
+// the subscriber, until ws fails.
+func (c *brokerCluster) readLoop(ws *websocket.Conn) error {
+	for {
+		var resp brokerResponse
+		if err := ws.ReadJSON(&resp); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		if resp.ID == 0 {
+			if resp.Envelope != nil && c.inbox != nil {
+				c.inbox.put(*resp.Envelope)
+			}
+		} else if ch, ok := c.pending[resp.ID]; ok {
+			delete(c.pending, resp.ID)
+			ch <- resp
+		} else if resp.Error != "" {
+			// Nobody waits for the answer to a release
+			logError(slog.Default(), errorCluster, "Broker rejected request", errors.New(resp.Error))
+		}
+		c.mu.Unlock()
+	}
+}
+
+// writeLoop writes queued requests to the broker, in order. Requests
+// queued before the connection was lost have already failed, and are
+// dropped.
+func (c *brokerCluster) writeLoop() {
+	for {
+		select {
+		case req := <-c.queue:
+			c.mu.Lock()
+			ws, stale := c.ws, req.ID < c.staleBelow
+			c.mu.Unlock()
+			if ws == nil || stale {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(brokerTimeout))
+			if err := ws.WriteJSON(req); err != nil {
+				ws.Close() // The read loop sees it fail, and reconnects
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// fail fails every waiting request, and every later one until the
+// connection is back, with err.
+func (c *brokerCluster) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	c.ws = nil
+	c.staleBelow = c.nextID + 1
+	for id, ch := range c.pending {
+		delete(c.pending, id)
+		close(ch)
+	}
+}
+
+// reconnect dials the broker until it answers, waiting longer after each
+// failed attempt. It returns nil if the cluster is closed meanwhile.
+func (c *brokerCluster) reconnect() *websocket.Conn {
+	delay := brokerRetryMin
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return nil
+		}
+
+		ws, err := c.dial()
+		if err != nil {
+			slog.Warn("Failed to reconnect to broker", "err", err, "retry_in", delay)
+			delay = min(2*delay, brokerRetryMax)
+			continue
+		}
+
+		c.mu.Lock()
+		closed := c.closed
+		if !closed {
+			c.ws = ws
+			c.err = nil
+		}
+		c.mu.Unlock()
+		if closed {
+			ws.Close()
+			return nil
+		}
+		slog.Info("Reconnected to broker")
+		return ws
+	}
+}
+
+// restore subscribes again and claims this instance's rooms again after a
+// reconnect. If subscribing fails, the connection is dropped to try again.
+func (c *brokerCluster) restore() {
+	c.mu.Lock()
+	instance := c.subscribed
+	claimed := make(map[string]string, len(c.claimed))
+	for code, owner := range c.claimed {
+		claimed[code] = owner
+	}
+	ws := c.ws
+	c.mu.Unlock()
+
+	if instance != "" {
+		if _, err := c.call(brokerRequest{Op: brokerSubscribe, Instance: instance}); err != nil {
+			logError(slog.Default(), errorCluster, "Failed to subscribe to broker again", err)
+			if ws != nil {
+				ws.Close()
+			}
+			return
+		}
+	}
+
+	for code, owner := range claimed {
+		_, err := c.call(brokerRequest{Op: brokerClaim, Code: code, Instance: owner})
+		if errors.Is(err, errRoomCodeTaken) {
+			slog.Warn("Room code was taken by another instance while the broker was unreachable", "code", code)
+			continue
+		} else if err != nil {
+			logError(slog.Default(), errorCluster, "Failed to claim room again", err, "code", code)
+			continue
+		}
+
+		c.mu.Lock()
+		_, kept := c.claimed[code]
+		c.mu.Unlock()
+		if !kept {
+			c.ReleaseRoom(code, owner) // Released while it was being claimed
+		}
+	}
+	slog.Info("Restored cluster state after reconnecting", "instance", instance, "rooms", len(claimed))
+}
+
+func (c *brokerCluster) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// send queues req for the broker. Its answer is sent to ch, unless ch is
+// nil.
+func (c *brokerCluster) send(req brokerRequest, ch chan brokerResponse) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.nextID++
+	req.ID = c.nextID
+
+	select {
+	case c.queue <- req:
+	default:
+		return 0, errBrokerBusy
+	}
+	if ch != nil {
+		c.pending[req.ID] = ch
+	}
+	return req.ID, nil
+}
+
+// call sends req to the broker and waits for the answer.
+func (c *brokerCluster) call(req brokerRequest) (brokerResponse, error) {
+	ch := make(chan brokerResponse, 1)
+	id, err := c.send(req, ch)
+	if err != nil {
+		return brokerResponse{}, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return brokerResponse{}, c.err
+		}
+		if resp.Error != "" {
+			return resp, brokerError(resp.Error)
+		}
+		return resp, nil
+	case <-time.After(brokerTimeout):
+		c.forget(id)
+		return brokerResponse{}, fmt.Errorf("broker did not answer %s in time", req.Op)
+	}
+}
+
+func (c *brokerCluster) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// brokerError turns an error from the broker back into the error value
+// callers check for.
+func brokerError(text string) error {
+	for _, err := range brokerErrors {
+		if err.Error() == text {
+			return err
+		}
+	}
+	return fmt.Errorf("broker: %s", text)
+}
+
+func (c *brokerCluster) ClaimRoom(code, instance string) error {
+	if _, err := c.call(brokerRequest{Op: brokerClaim, Code: code, Instance: instance}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.claimed[code] = instance
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *brokerCluster) LookupRoom(code string) (string, bool, error) {
+	resp, err := c.call(brokerRequest{Op: brokerLookup, Code: code})
+	return resp.Instance, resp.Found, err
+}
+
+// ReleaseRoom queues the release without waiting for the broker to answer.
+func (c *brokerCluster) ReleaseRoom(code, instance string) error {
+	c.mu.Lock()
+	if c.claimed[code] == instance {
+		delete(c.claimed, code)
+	}
+	c.mu.Unlock()
+
+	_, err := c.send(brokerRequest{Op: brokerRelease, Code: code, Instance: instance}, nil)
+	if err != nil && !c.connected() {
+		return nil // The broker released it when the connection was lost
+	}
+	return err
+}
+
+func (c *brokerCluster) Publish(instance string, env Envelope) error {
+	_, err := c.call(brokerRequest{Op: brokerPublish, Instance: instance, Envelope: &env})
+	return err
+}
+
+func (c *brokerCluster) Subscribe(instance string, deliver func(Envelope)) error {
+	c.mu.Lock()
+	if c.inbox != nil {
+		c.mu.Unlock()
+		return errors.New("already subscribed")
+	}
+	c.inbox = newMailbox(deliver)
+	c.subscribed = instance
+	c.mu.Unlock()
+
+	_, err := c.call(brokerRequest{Op: brokerSubscribe, Instance: instance})
+	return err
+}
+
+func (c *brokerCluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.inbox != nil {
+		c.inbox.close()
+	}
+	close(c.done)
+	if c.ws != nil {
+		return c.ws.Close()
+	}
+	return nil
+}
+
+func (c *brokerCluster) connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws != nil
+}
+
+// brokerRatings are the PlayerRatings kept by the broker's instance.
+type brokerRatings struct {
+	cluster *brokerCluster
+}
+
+// NewBrokerRatings returns the ratings kept by the broker that cluster, a
+// Cluster from DialBroker, is connected to.
+func NewBrokerRatings(cluster Cluster) (PlayerRatings, error) {
+	c, ok := cluster.(*brokerCluster)
+	if !ok {
+		return nil, errors.New("ratings are only kept by a broker")
+	}
+	return &brokerRatings{cluster: c}, nil
+}
+
+func (r *brokerRatings) Identify(info PlayerInfo, secret string) (string, error) {
+	resp, err := r.cluster.call(brokerRequest{Op: brokerIdentify, Player: &info, Secret: secret})
+	return resp.Secret, err
+}
+
+func (r *brokerRatings) Register(info PlayerInfo) error {
+	_, err := r.cluster.call(brokerRequest{Op: brokerRegister, Player: &info})
+	return err
+}
+
+func (r *brokerRatings) RecordResult(roomCode string, winner, loser PlayerInfo) (GameRecord, error) {
+	resp, err := r.cluster.call(brokerRequest{Op: brokerRecordResult, Code: roomCode, Player: &winner, Loser: &loser})
+	if err != nil {
+		return GameRecord{}, err
+	}
+	if resp.Record == nil {
+		return GameRecord{}, errors.New("broker did not return the game's record")
+	}
+	return *resp.Record, nil
+}
+
+func (r *brokerRatings) Leaderboard(limit int) ([]LeaderboardEntry, error) {
+	return r.ranking(brokerLeaderboard, limit)
+}
+
+func (r *brokerRatings) Ladder(limit int) ([]LeaderboardEntry, error) {
+	return r.ranking(brokerLadder, limit)
+}
+
+func (r *brokerRatings) ranking(op string, limit int) ([]LeaderboardEntry, error) {
+	resp, err := r.cluster.call(brokerRequest{Op: op, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Entries == nil {
+		resp.Entries = []LeaderboardEntry{} // Left out of the response when empty
+	}
+	return resp.Entries, nil
+}
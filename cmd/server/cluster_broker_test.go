@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClusterToken = "cluster-token"
+
+// inbox collects the envelopes published to an instance.
+type inbox struct {
+	mu   sync.Mutex
+	envs []Envelope
+}
+
+func (in *inbox) deliver(env Envelope) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.envs = append(in.envs, env)
+}
+
+// wait returns the first envelope of kind, waiting for it to arrive.
+func (in *inbox) wait(t *testing.T, kind string) Envelope {
+	t.Helper()
+	var found *Envelope
+	eventually(t, "a "+kind+" envelope", func() bool {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		for i := range in.envs {
+			if in.envs[i].Kind == kind {
+				found = &in.envs[i]
+				return true
+			}
+		}
+		return false
+	})
+	return *found
+}
+
+// eventually fails t unless cond holds within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testBroker serves a broker that can be restarted, losing its state and
+// its connections to instances but keeping its players, as a broker with a
+// database does.
+type testBroker struct {
+	mu      sync.Mutex
+	broker  *Broker
+	ratings *Ratings
+	conns   []net.Conn
+}
+
+func (b *testBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	broker := b.broker
+	b.mu.Unlock()
+	broker.ServeHTTP(w, r)
+}
+
+// restart replaces the broker with a new one and drops every connection
+// to the old one.
+func (b *testBroker) restart(t *testing.T) {
+	broker, err := NewBroker(NewLocalCluster(), b.ratings, testClusterToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.broker = broker
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+// startBroker serves a broker and returns the URL instances join it at.
+func startBroker(t *testing.T) (*testBroker, string) {
+	t.Helper()
+	b := &testBroker{ratings: NewRatings(NewMemoryStore())}
+	b.restart(t)
+	srv := httptest.NewUnstartedServer(b)
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			b.mu.Lock()
+			b.conns = append(b.conns, conn)
+			b.mu.Unlock()
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return b, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// joinBroker connects instance to the broker at url, collecting the
+// envelopes published to it.
+func joinBroker(t *testing.T, url, instance string) (Cluster, *inbox) {
+	t.Helper()
+	cluster, err := DialBroker(url, testClusterToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cluster.Close() })
+	in := &inbox{}
+	if err := cluster.Subscribe(instance, in.deliver); err != nil {
+		t.Fatal(err)
+	}
+	return cluster, in
+}
+
+func TestBrokerSharesRegistryAndBus(t *testing.T) {
+	_, url := startBroker(t)
+	a, inboxA := joinBroker(t, url, "a")
+	b, _ := joinBroker(t, url, "b")
+
+	if err := a.ClaimRoom("ABCD", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ClaimRoom("ABCD", "b"); !errors.Is(err, errRoomCodeTaken) {
+		t.Errorf("claiming a taken code returned %v, want errRoomCodeTaken", err)
+	}
+	if owner, found, err := b.LookupRoom("ABCD"); err != nil || !found || owner != "a" {
+		t.Errorf("LookupRoom(ABCD) = %q, %v, %v; want a", owner, found, err)
+	}
+
+	if err := b.Publish("a", Envelope{Kind: EnvelopeLeave, From: "b", Client: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if env := inboxA.wait(t, EnvelopeLeave); env.From != "b" || env.Client != "c1" {
+		t.Errorf("a received %+v", env)
+	}
+	if err := b.Publish("nobody", Envelope{Kind: EnvelopeLeave}); !errors.Is(err, errUnknownInstance) {
+		t.Errorf("publishing to an unknown instance returned %v, want errUnknownInstance", err)
+	}
+
+	if err := a.ReleaseRoom("ABCD", "a"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the release", func() bool {
+		_, found, _ := b.LookupRoom("ABCD")
+		return !found
+	})
+}
+
+func TestBrokerRejectsWrongToken(t *testing.T) {
+	_, url := startBroker(t)
+	if _, err := DialBroker(url, "wrong"); err == nil {
+		t.Error("joined the broker with the wrong token")
+	}
+}
+
+func TestBrokerReconnect(t *testing.T) {
+	broker, url := startBroker(t)
+	a, inboxA := joinBroker(t, url, "a")
+	b, _ := joinBroker(t, url, "b")
+	if err := a.ClaimRoom("WXYZ", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.restart(t)
+	if _, _, err := b.LookupRoom("WXYZ"); err == nil {
+		t.Error("looking up a room while disconnected from the broker succeeded")
+	}
+	eventually(t, "both instances to reconnect and a's room to be claimed again", func() bool {
+		owner, found, err := b.LookupRoom("WXYZ")
+		return err == nil && found && owner == "a"
+	})
+
+	eventually(t, "a to subscribe again", func() bool {
+		return b.Publish("a", Envelope{Kind: EnvelopeKick, From: "b", Client: "c1"}) == nil
+	})
+	inboxA.wait(t, EnvelopeKick)
+	if err := b.ClaimRoom("WXYZ", "b"); !errors.Is(err, errRoomCodeTaken) {
+		t.Errorf("claiming a's restored code returned %v, want errRoomCodeTaken", err)
+	}
+}
+
+// TestHandOffThroughBroker runs this server as instance a and a second one
+// as instance b, which only holds the connection of a player whose room is
+// on a.
+func TestHandOffThroughBroker(t *testing.T) {
+	_, url := startBroker(t)
+	a, err := DialBroker(url, testClusterToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	saved, savedInstance := server.cluster, server.instance
+	server.cluster, server.instance = a, "a"
+	defer func() { server.cluster, server.instance = saved, savedInstance }()
+	if err := a.Subscribe("a", handleEnvelope); err != nil {
+		t.Fatal(err)
+	}
+	b, inboxB := joinBroker(t, url, "b")
+
+	host, hostConn := newTestClient("host")
+	room := handleCreateRoom(host, false)
+	if room == nil {
+		t.Fatal("failed to create room")
+	}
+	if owner, found, err := b.LookupRoom(room.Code); err != nil || !found || owner != "a" {
+		t.Fatalf("b looked up room %s on %q, %v, %v; want a", room.Code, owner, found, err)
+	}
+
+	// b forwards its player's join to a, and a answers through b
+	data, _ := json.Marshal(JoinRoomPayload{Code: room.Code})
+	msg, _ := json.Marshal(Message{Type: MsgJoinRoom, Payload: data})
+	join := Envelope{Kind: EnvelopeMessage, From: "b", Client: "c1", Player: PlayerInfo{ID: "guest", Name: "guest"}, Message: msg}
+	if err := b.Publish("a", join); err != nil {
+		t.Fatal(err)
+	}
+	var started Message
+	if err := json.Unmarshal(inboxB.wait(t, EnvelopeDeliver).Message, &started); err != nil || started.Type != MsgGameStart {
+		t.Errorf("b's player was sent %+v, %v; want game_start", started, err)
+	}
+	eventually(t, "the host to hear the guest joined", func() bool {
+		types := hostConn.types()
+		return len(types) > 0 && types[len(types)-1] == MsgPlayerJoined
+	})
+
+	// The room's code is released once it closes
+	if err := b.Publish("a", Envelope{Kind: EnvelopeLeave, From: "b", Client: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the room to be released", func() bool {
+		_, found, _ := b.LookupRoom(room.Code)
+		return !found
+	})
+}
+
+// TestHelloOnEitherInstance says hello as the same player to two instances
+// in turn, as a player behind a load balancer does.
+func TestHelloOnEitherInstance(t *testing.T) {
+	_, url := startBroker(t)
+	a, _ := joinBroker(t, url, "a")
+	b, _ := joinBroker(t, url, "b")
+	ratingsA, err := NewBrokerRatings(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratingsB, err := NewBrokerRatings(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := server.ratings
+	defer func() { server.ratings = saved }()
+
+	// hello says hello to the instance with ratings and returns its answer
+	hello := func(ratings PlayerRatings, secret string) Message {
+		t.Helper()
+		server.ratings = ratings
+		client, conn := newTestClient("")
+		handleHello(client, HelloPayload{PlayerID: "player-1", Name: "ann", Secret: secret})
+		if len(conn.msgs) != 1 {
+			t.Fatalf("hello was answered with %v", conn.types())
+		}
+		return conn.msgs[0]
+	}
+
+	answer := hello(ratingsA, "")
+	var welcome WelcomePayload
+	if err := json.Unmarshal(answer.Payload, &welcome); err != nil || answer.Type != MsgWelcome || welcome.Secret == "" {
+		t.Fatalf("first hello was answered with %s %s, want a welcome with a secret", answer.Type, answer.Payload)
+	}
+	for i, ratings := range []PlayerRatings{ratingsB, ratingsA, ratingsB} {
+		if answer := hello(ratings, welcome.Secret); answer.Type != MsgWelcome || string(answer.Payload) != "{}" {
+			t.Errorf("hello %d with the issued secret was answered with %s %s, want a welcome", i+2, answer.Type, answer.Payload)
+		}
+	}
+	if answer := hello(ratingsB, "guessed"); answer.Type == MsgWelcome {
+		t.Error("hello with the wrong secret was welcomed")
+	}
+
+	// A game rated on one instance shows on the other's leaderboard
+	if _, err := ratingsA.RecordResult("ABCD", PlayerInfo{ID: "player-1", Name: "ann"}, PlayerInfo{ID: "player-2", Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ratingsB.Leaderboard(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "ann" || entries[0].Wins != 1 {
+		t.Errorf("leaderboard on b is %+v, want ann ahead of bob", entries)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingBus is a Cluster whose publishes wait until released, like
+// publishes to a slow broker.
+type blockingBus struct {
+	Cluster
+	proceed chan struct{}
+
+	mu        sync.Mutex
+	published []Envelope
+}
+
+func (c *blockingBus) Publish(instance string, env Envelope) error {
+	<-c.proceed
+	c.mu.Lock()
+	c.published = append(c.published, env)
+	c.mu.Unlock()
+	return nil
+}
+
+// delivered returns the types of the messages delivered to handed-off
+// clients so far, in order.
+func (c *blockingBus) delivered() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var types []string
+	for _, env := range c.published {
+		var msg Message
+		if env.Kind == EnvelopeDeliver && json.Unmarshal(env.Message, &msg) == nil {
+			types = append(types, msg.Type)
+		}
+	}
+	return types
+}
+
+// forwardedMessage returns the envelope another instance publishes for a
+// message from a client handed off to this one.
+func forwardedMessage(t *testing.T, client string, msgType string, payload any) Envelope {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := json.Marshal(Message{Type: msgType, Payload: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Envelope{Kind: EnvelopeMessage, From: "other", Client: client, Player: PlayerInfo{ID: client, Name: client}, Message: msg}
+}
+
+// within fails t unless f returns within a second.
+func within(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s waited for the message bus", what)
+	}
+}
+
+func TestHandedOffClientDoesNotBlockRoom(t *testing.T) {
+	bus := &blockingBus{Cluster: server.cluster, proceed: make(chan struct{})}
+	server.cluster = bus
+	defer func() { server.cluster = bus.Cluster }()
+
+	host, hostConn := newTestClient("host")
+	room := handleCreateRoom(host, false)
+	if room == nil {
+		t.Fatal("failed to create room")
+	}
+
+	within(t, "joining", func() {
+		handleEnvelope(forwardedMessage(t, "remote-guest", MsgJoinRoom, JoinRoomPayload{Code: room.Code}))
+	})
+	if room.Guest == nil {
+		t.Fatalf("handed-off guest did not join: %v", hostConn.types())
+	}
+	within(t, "relaying", func() {
+		relayMessage(host, Message{Type: MsgShipsPlaced, Payload: []byte(`{}`)})
+	})
+
+	close(bus.proceed)
+	want := []string{MsgGameStart, MsgShipsPlaced}
+	deadline := time.Now().Add(time.Second)
+	for len(bus.delivered()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := bus.delivered()
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("guest was sent %v, want %v", got, want)
+	}
+
+	handleEnvelope(Envelope{Kind: EnvelopeLeave, From: "other", Client: "remote-guest"})
+	if host.Room() != nil {
+		t.Error("host is still in the room after the handed-off guest left")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestHelloStripsControlCharacters(t *testing.T) {
+	client, conn := newTestClient("")
+	handleHello(client, HelloPayload{PlayerID: "hello-control", Name: "\x1b]0;owned\x07ann\u0085 "})
+	if types := conn.types(); len(types) != 1 || types[0] != MsgWelcome {
+		t.Fatalf("hello was answered with %v", types)
+	}
+	if name := client.Player().Name; name != "]0;ownedann" {
+		t.Errorf("name = %q, want it without control characters", name)
+	}
+}
+
+func TestHelloRefusedWithHelloError(t *testing.T) {
+	for _, payload := range []HelloPayload{{}, {PlayerID: "bot:impostor"}} {
+		client, conn := newTestClient("")
+		handleHello(client, payload)
+		if types := conn.types(); len(types) != 1 || types[0] != MsgHelloError {
+			t.Errorf("hello %+v was answered with %v, want hello_error", payload, types)
+		}
+	}
+
+	client, conn := newTestClient("")
+	handleHello(client, HelloPayload{PlayerID: "hello-refused", Name: "ann"})
+	handleHello(client, HelloPayload{PlayerID: "hello-refused", Name: "ann", Secret: "guessed"})
+	if types := conn.types(); len(types) != 2 || types[1] != MsgHelloError {
+		t.Errorf("hello with the wrong secret was answered with %v, want hello_error", types)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reasons a room is closed by the server, sent in RoomExpiredPayload
+const (
+	ExpiredNoGuest = "no_guest" // Nobody joined before the room expired
+	ExpiredIdle    = "idle"     // Nobody in the room sent anything for too long
+)
+
+const (
+	// minReapInterval and maxReapInterval bound how often rooms are checked
+	// for expiry.
+	minReapInterval = time.Second
+	maxReapInterval = time.Minute
+	// closeWriteWait is how long the server waits to send a close frame.
+	closeWriteWait = time.Second
+)
+
+// errTooManyRooms is returned when the server already has the maximum
+// number of rooms open.
+var errTooManyRooms = errors.New("too many rooms open")
+
+// RoomExpiredPayload tells the members of a room why the server closed it.
+type RoomExpiredPayload struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// reapRooms closes expired rooms until the server stops.
+func reapRooms() {
+	ticker := time.NewTicker(reapInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		reapRoomsOnce(time.Now())
+	}
+}
+
+// reapInterval checks rooms a few times per expiry period, so rooms close
+// soon after they expire.
+func reapInterval() time.Duration {
+	interval := maxReapInterval
+	for _, d := range []time.Duration{server.config.RoomExpiry, server.config.RoomIdleTimeout} {
+		if d > 0 && d/4 < interval {
+			interval = d / 4
+		}
+	}
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	return interval
+}
+
+// reapRoomsOnce closes rooms nobody joined in time and rooms that have been
+// idle for too long.
+func reapRoomsOnce(now time.Time) {
+	server.mu.RLock()
+	rooms := make([]*Room, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
+	}
+	server.mu.RUnlock()
+
+	for _, room := range rooms {
+		room.mu.Lock()
+		switch {
+		case room.reserved != nil || room.Host == nil:
+			// Restored rooms have their own deadline, and torn down rooms are gone
+		case room.Guest == nil && server.config.RoomExpiry > 0 && now.Sub(room.CreatedAt) >= server.config.RoomExpiry:
+			closeRoom(room, ExpiredNoGuest, "No one joined the room in time")
+		case server.config.RoomIdleTimeout > 0 && now.Sub(room.lastActivity) >= server.config.RoomIdleTimeout:
+			closeRoom(room, ExpiredIdle, "The room was idle for too long")
+		}
+		room.mu.Unlock()
+	}
+}
+
+// touchRoom records activity in client's room, postponing its idle expiry.
+func touchRoom(client *Client) {
+	room := client.Room()
+	if room == nil {
+		return
+	}
+	room.mu.Lock()
+	room.lastActivity = time.Now()
+	room.mu.Unlock()
+}
+
+// closeRoom tells everyone in room why it is closing and removes it.
+// Must be called with room.mu held.
+func closeRoom(room *Room, reason, message string) {
+	if room.closed {
+		return // Already torn down by someone else
+	}
+	stopClock(room)
+	if room.phase == PhasePlacement || room.phase == PhaseBattle {
+		metrics.gamesFinished.inc(OutcomeAbandoned)
+	}
+
+	payload := RoomExpiredPayload{Reason: reason, Message: message}
+	for _, c := range roomMembers(room) {
+		sendJSON(c, MsgRoomExpired, payload)
+		c.setRoom(nil)
+	}
+	room.Host = nil
+	room.Guest = nil
+	room.Spectators = nil
+
+	removeRoom(room)
+
+	if err := server.store.DeleteRoom(room.Code); err != nil {
+		logError(roomLog(room), errorStore, "Failed to delete room", err)
+	}
+	logRoomEvent(room, nil, EventClosed, "reason", reason)
+}
+
+// hostOf returns the IP address of addr, without its port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// acquireConn counts a new connection from ip, reporting false if ip
+// already has as many connections as it is allowed.
+func acquireConn(ip string) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.config.MaxConnsPerIP > 0 && server.connsByIP[ip] >= server.config.MaxConnsPerIP {
+		return false
+	}
+	server.connsByIP[ip]++
+	return true
+}
+
+// releaseConn forgets a connection from ip.
+func releaseConn(ip string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.connsByIP[ip]--
+	if server.connsByIP[ip] <= 0 {
+		delete(server.connsByIP, ip)
+	}
+}
+
+// closeWebSocket sends a close frame with code and reason, so the client
+// can tell why it was disconnected, and closes ws.
+func closeWebSocket(ws *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteWait)); err != nil {
+		logError(slog.Default(), errorWebSocket, "Failed to send close frame", err)
+	}
+	ws.Close()
+}
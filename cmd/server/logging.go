@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bnet "battle-ship/net"
+)
+
+// Room lifecycle events, logged with the "event" attribute
+const (
+	EventCreated    = "created"
+	EventJoined     = "joined"
+	EventRejoined   = "rejoined"
+	EventSpectating = "spectating"
+	EventPlacement  = "placement_started"
+	EventBattle     = "battle_started"
+	EventTimeout    = "timeout"
+	EventFinished   = "finished"
+	EventRematch    = "rematch_started"
+	EventPlayerLeft = "player_left"
+	EventKicked     = "kicked"
+	EventClosed     = "closed"
+	EventRestored   = "restored"
+)
+
+// maxRoomEvents is how many events a room's history keeps.
+const maxRoomEvents = 200
+
+// RoomEvent is an entry in a room's history, as served by the admin API.
+type RoomEvent struct {
+	Time    time.Time      `json:"time"`
+	Event   string         `json:"event"`
+	Client  string         `json:"client,omitempty"`
+	Player  string         `json:"player,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// roomEvents is a room's history, the most recent maxRoomEvents events.
+// It has its own lock so events can be added with or without the room lock.
+type roomEvents struct {
+	mu     sync.Mutex
+	events []RoomEvent
+}
+
+func (e *roomEvents) add(event RoomEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.events) == maxRoomEvents {
+		e.events = append(e.events[:0], e.events[1:]...)
+	}
+	e.events = append(e.events, event)
+}
+
+// list returns a copy of the events, oldest first.
+func (e *roomEvents) list() []RoomEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]RoomEvent(nil), e.events...)
+}
+
+// lastClientID numbers connections so their log lines can be correlated.
+var lastClientID atomic.Int64
+
+// newClientID returns a unique ID for a new client.
+func newClientID() string {
+	return "c" + strconv.FormatInt(lastClientID.Add(1), 10)
+}
+
+// setupLogging installs the default structured logger, writing lines of
+// key=value pairs, or JSON objects, at level and above to stderr.
+func setupLogging(level string, json bool) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if json {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// clientLog returns a logger tagged with client's connection, identity and
+// room.
+func clientLog(client *Client) *slog.Logger {
+	logger := slog.With(clientAttrs(client)...)
+	if room := client.Room(); room != nil {
+		logger = logger.With("room", room.Code)
+	}
+	return logger
+}
+
+// clientAttrs are the attributes identifying client in log lines.
+func clientAttrs(client *Client) []any {
+	attrs := []any{"client", client.id, "addr", client.addr}
+	switch player := client.Player(); {
+	case player.ID != "":
+		attrs = append(attrs, "player", bnet.PublicPlayerID(player.ID)) // The ID itself is a credential
+	case player.Name != "":
+		attrs = append(attrs, "player", player.Name)
+	}
+	return attrs
+}
+
+// roomLog returns a logger tagged with room's code.
+func roomLog(room *Room) *slog.Logger {
+	return slog.With("room", room.Code)
+}
+
+// logRoomEvent logs a step in room's lifecycle, from creation to teardown,
+// and adds it to the room's event history. client, if not nil, is the member
+// the event is about. args are key-value pairs, as for slog.
+func logRoomEvent(room *Room, client *Client, event string, args ...any) {
+	logger := roomLog(room)
+	entry := RoomEvent{Time: time.Now(), Event: event}
+	if client != nil {
+		logger = logger.With(clientAttrs(client)...)
+		entry.Client = client.id
+		player := client.Player()
+		entry.Player = player.ID
+		if entry.Player == "" {
+			entry.Player = player.Name
+		}
+	}
+	logger.Info("Room "+strings.ReplaceAll(event, "_", " "), append([]any{"event", event}, args...)...)
+
+	if len(args) > 0 {
+		entry.Details = make(map[string]any, len(args)/2)
+		for i := 0; i+1 < len(args); i += 2 {
+			entry.Details[fmt.Sprint(args[i])] = args[i+1]
+		}
+	}
+	room.events.add(entry)
+}
@@ -2,12 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"battle-ship/game"
+	bnet "battle-ship/net"
 
 	"github.com/gorilla/websocket"
 )
@@ -15,6 +25,7 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{bnet.MsgPackSubprotocol}, // Offered by clients that want MessagePack
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for simplicity
 	},
@@ -29,6 +40,67 @@ const (
 	MsgJoinError    = "join_error"
 	MsgGameStart    = "game_start"
 	MsgOpponentLeft = "opponent_left"
+	MsgRoomExpired  = "room_expired"
+	MsgNotice       = "notice" // From the server's operators
+
+	// Server-hosted AI opponent
+	MsgCreateBotRoom = "create_bot_room"
+
+	// Bot API for third-party programs
+	MsgBotHello   = "bot_hello"
+	MsgBotWelcome = "bot_welcome"
+	MsgBotError   = "bot_error"
+	MsgFindMatch  = "find_match"
+	MsgBotState   = "bot_state"
+	MsgBotMove    = "bot_move"
+	MsgBotResult  = "bot_result"
+	MsgGetLadder  = "get_ladder"
+	MsgLadder     = "ladder"
+
+	// Identity and ratings
+	MsgHello          = "hello"
+	MsgWelcome        = "welcome"
+	MsgHelloError     = "hello_error" // The hello was refused
+	MsgGetLeaderboard = "get_leaderboard"
+	MsgLeaderboard    = "leaderboard"
+
+	// Spectators
+	MsgSpectateRoom     = "spectate_room"
+	MsgSpectateState    = "spectate_state"
+	MsgSpectatorShot    = "spectator_shot"
+	MsgFleetReveal      = "fleet_reveal"
+	MsgSpectatorCount   = "spectator_count"
+	MsgSpectateGameOver = "spectate_game_over"
+
+	// Chat
+	MsgChat         = "chat"
+	MsgChatRejected = "chat_rejected"
+
+	// Rematch
+	MsgRematchRequest = "rematch_request"
+	MsgRematchDecline = "rematch_decline"
+	MsgRematchStart   = "rematch_start"
+
+	// Shot clock
+	MsgTurnTimer      = "turn_timer"
+	MsgPlacementTimer = "placement_timer"
+	MsgTurnTimeout    = "turn_timeout"
+
+	// Relayed game messages the server inspects
+	MsgShipsPlaced  = "ships_placed"
+	MsgAttack       = "attack"
+	MsgAttackResult = "attack_result"
+	MsgGameOver     = "game_over"
+)
+
+const (
+	// maxNameLength caps the display name a player may register.
+	maxNameLength = 24
+	// leaderboardSize is the number of entries returned by default.
+	leaderboardSize = 20
+	// restoredRoomTTL is how long a room restored from storage waits for its
+	// players to come back before it is discarded.
+	restoredRoomTTL = 10 * time.Minute
 )
 
 // Message is the wrapper for all network messages types.
@@ -52,96 +124,608 @@ type ErrorPayload struct {
 	Message string `json:"message"`
 }
 
-// Client represents a connected player's WebSocket connection and state.
+// HelloPayload identifies the player behind a connection. Secret is the one
+// issued for PlayerID in welcome, empty the first time the ID is used.
+type HelloPayload struct {
+	PlayerID string `json:"player_id"`
+	Name     string `json:"name"`
+	Secret   string `json:"secret,omitempty"`
+}
+
+// WelcomePayload accepts a hello, carrying the secret issued for a player ID
+// seen for the first time.
+type WelcomePayload struct {
+	Secret string `json:"secret,omitempty"`
+}
+
+// LeaderboardPayload is the response payload for a leaderboard request.
+type LeaderboardPayload struct {
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// GameOverPayload is sent by the losing client to its opponent.
+type GameOverPayload struct {
+	YouWon bool `json:"you_won"`
+}
+
+// PlayerInfo is the persistent identity a client registered with hello.
+type PlayerInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// clientConn is where messages for a client are written: a WebSocket for
+// remote players, or an in-process queue for server bots.
+type clientConn interface {
+	WriteJSON(v any) error
+}
+
+// Client represents a connected player's connection and state.
 type Client struct {
-	conn   *websocket.Conn
-	room   *Room
-	isHost bool
+	conn        clientConn
+	id          string // identifies the connection in logs and the admin API
+	addr        string // remote address
+	connectedAt time.Time
+	kick        func(reason string) // closes the connection; nil for server bots
+	via         string              // instance holding the connection of a client handed off to this one
+	isHost      bool
+	chatLimit   *tokenBucket // guarded by the room lock
+	msgLimit    *tokenBucket // used only by the connection's read loop
+	rejected    int          // messages dropped for being invalid or too fast since rejectStart
+	rejectStart time.Time    // start of the window rejected messages are counted over
+
+	// Other goroutines than the connection's read and write these, so they
+	// are guarded by mu and used through the accessors in client.go.
+	mu        sync.Mutex
+	room      *Room
+	isBot     bool // a third-party program authenticated with a bot token
+	spectator bool
+	player    PlayerInfo
+	owner     string // instance handling a connection handed off to another instance
 }
 
-// Room represents a game session between two players.
+// Room represents a game session between two players and their spectators.
 type Room struct {
-	Code  string
-	Host  *Client
-	Guest *Client
-	mu    sync.Mutex
+	Code       string
+	Host       *Client
+	Guest      *Client
+	Spectators []*Client
+	CreatedAt  time.Time
+	finished   bool         // result already recorded
+	winner     string       // role of the winner once finished
+	reserved   []PlayerInfo // players allowed back into a room restored from storage
+	botsOnly   bool         // only token-authenticated bots may play
+	closed     bool         // torn down; members arriving late are turned away
+	mu         sync.Mutex
+
+	lastActivity time.Time // last message from a member, for the idle reaper
+	events       roomEvents
+
+	// Spectator view of the game
+	hostFleet      []ShipPlacement
+	guestFleet     []ShipPlacement
+	fleetsRevealed bool
+	shots          []SpectatorShotPayload
+
+	// Series of games played in this room
+	gamesPlayed       int
+	hostWins          int
+	guestWins         int
+	hostWantsRematch  bool
+	guestWantsRematch bool
+	rematchDeclined   bool // the series is over, so a player leaving is expected
+
+	// Turn tracking for the shot clock
+	phase          string
+	turn           string // role of the player to fire next
+	awaitingResult bool   // an attack is waiting for the defender's answer
+	hostReady      bool
+	guestReady     bool
+	hostShots      map[[2]int]bool
+	guestShots     map[[2]int]bool
+	hostTimeouts   int
+	guestTimeouts  int
+	clock          *time.Timer
+	clockGen       int
+
+	// Boards of bot rooms, where the server resolves every shot
+	hostBoard  *game.Board
+	guestBoard *game.Board
+}
+
+// Config holds tunable server behavior.
+type Config struct {
+	// SpectatorRevealDelay reveals both fleets to spectators this long after
+	// the battle starts. Zero keeps them hidden until game over.
+	SpectatorRevealDelay time.Duration
+
+	// TurnTimeout is how long a player has to fire. Zero disables the clock.
+	TurnTimeout time.Duration
+	// TimeoutAction is what happens when a turn times out: skip, random or forfeit.
+	TimeoutAction string
+	// MaxTimeouts forfeits the game for a player who times out this many
+	// times. Zero never forfeits.
+	MaxTimeouts int
+	// PlacementTimeout is how long players have to place their ships before
+	// forfeiting. Zero disables the deadline.
+	PlacementTimeout time.Duration
+
+	// RoomCodeAlphabet is the set of characters room codes are drawn from.
+	RoomCodeAlphabet string
+	// RoomCodeLength is the length of public room codes.
+	RoomCodeLength int
+	// PrivateRoomCodeLength is the length of private room codes.
+	PrivateRoomCodeLength int
+	// RoomExpiry closes rooms still waiting for a guest after this long.
+	// Zero keeps them open.
+	RoomExpiry time.Duration
+	// RoomIdleTimeout closes rooms whose members send nothing for this long.
+	// Zero keeps them open.
+	RoomIdleTimeout time.Duration
+
+	// MaxRooms caps the number of open rooms. Zero is unlimited.
+	MaxRooms int
+	// MaxConnsPerIP caps the WebSocket connections from one address. Zero
+	// is unlimited.
+	MaxConnsPerIP int
+	// MaxMessageSize is the largest message, in bytes, a client may send.
+	MaxMessageSize int64
+
+	// MessageRate is how many messages per second a client may send on
+	// average, and MessageBurst how many it may send back to back. A zero
+	// rate is unlimited.
+	MessageRate  float64
+	MessageBurst int
+	// MaxRejected disconnects clients after this many rejected messages
+	// within rejectWindow. Zero never disconnects them.
+	MaxRejected int
+	// SendQueueSize is how many messages may wait to be written to a client
+	// before it is disconnected for being too slow.
+	SendQueueSize int
 }
 
 // Server manages active rooms and concurrency.
 type Server struct {
-	rooms map[string]*Room
-	mu    sync.RWMutex
+	rooms   map[string]*Room
+	mu      sync.RWMutex
+	store   Store
+	ratings PlayerRatings
+	config  Config
+
+	cluster  Cluster // locates rooms on other instances
+	instance string  // this instance's ID in the cluster
+
+	botTokens         map[string]string // bot token -> bot name
+	roomCodeBlocklist []string
+	connsByIP         map[string]int
+	ready             atomic.Bool // set once startup is complete, for /readyz
+
+	clients            map[string]*Client // connected clients by ID
+	adminToken         string
+	maintenance        bool // refuse to create rooms
+	maintenanceMessage string
 }
 
 var server = &Server{
-	rooms: make(map[string]*Room),
+	rooms:     make(map[string]*Room),
+	connsByIP: make(map[string]int),
+	clients:   make(map[string]*Client),
 }
 
 func main() {
+	dbPath := flag.String("db", "battleship.db", "path to the database file (empty keeps state in memory)")
+	botTokensPath := flag.String("bot-tokens", "", "file of \"<name> <token>\" lines allowed to connect as bots")
+	flag.DurationVar(&server.config.SpectatorRevealDelay, "spectator-reveal-delay", 0, "reveal fleets to spectators this long into the battle (0 = at game over)")
+	flag.DurationVar(&server.config.TurnTimeout, "turn-timeout", 60*time.Second, "time a player has to fire (0 = unlimited)")
+	flag.StringVar(&server.config.TimeoutAction, "turn-timeout-action", TimeoutRandom, "what happens when a turn times out: skip, random or forfeit")
+	flag.IntVar(&server.config.MaxTimeouts, "max-timeouts", 3, "forfeit the game after this many timeouts (0 = never)")
+	flag.DurationVar(&server.config.PlacementTimeout, "placement-timeout", 3*time.Minute, "time players have to place their ships (0 = unlimited)")
+	flag.StringVar(&server.config.RoomCodeAlphabet, "room-code-alphabet", defaultRoomCodeAlphabet, "characters room codes are made of")
+	flag.IntVar(&server.config.RoomCodeLength, "room-code-length", defaultRoomCodeLength, "length of public room codes")
+	flag.IntVar(&server.config.PrivateRoomCodeLength, "private-room-code-length", defaultPrivateRoomCodeLength, "length of private room codes")
+	flag.DurationVar(&server.config.RoomExpiry, "room-expiry", 30*time.Minute, "close rooms nobody has joined after this long (0 = never)")
+	flag.DurationVar(&server.config.RoomIdleTimeout, "room-idle-timeout", 30*time.Minute, "close rooms whose players send nothing for this long (0 = never)")
+	flag.IntVar(&server.config.MaxRooms, "max-rooms", 1000, "maximum number of open rooms (0 = unlimited)")
+	flag.IntVar(&server.config.MaxConnsPerIP, "max-conns-per-ip", 20, "maximum connections from one IP address (0 = unlimited)")
+	flag.Int64Var(&server.config.MaxMessageSize, "max-message-size", 8192, "largest message in bytes a client may send")
+	flag.Float64Var(&server.config.MessageRate, "message-rate", 20, "messages per second a client may send on average (0 = unlimited)")
+	flag.IntVar(&server.config.MessageBurst, "message-burst", 40, "messages a client may send back to back")
+	flag.IntVar(&server.config.MaxRejected, "max-rejected", 20, "disconnect clients after this many rejected messages within a minute (0 = never)")
+	flag.IntVar(&server.config.SendQueueSize, "send-queue-size", 256, "messages that may wait for a slow client before it is disconnected")
+	blocklistPath := flag.String("room-code-blocklist", "", "file of extra words, one per line, that room codes must not contain")
+	logLevel := flag.String("log-level", "info", "minimum level of log lines: debug, info, warn or error")
+	adminTokenPath := flag.String("admin-token-file", "", "file holding the bearer token for the admin API at /admin/ (empty disables it)")
+	logJSON := flag.Bool("log-json", false, "write log lines as JSON objects")
+	port := flag.Int("port", 8080, "port to serve on")
+	serveWeb := flag.Bool("web", true, "serve the browser client at /")
+	tcpPort := flag.Int("tcp-port", 0, "port to also serve clients on over raw TCP, one JSON message per line (0 = off)")
+	flag.StringVar(&server.instance, "instance-id", "", "ID of this instance in the cluster (default random)")
+	clusterURL := flag.String("cluster", "", "WebSocket URL of the cluster broker to join, e.g. ws://broker:8080/cluster (empty runs alone)")
+	clusterBroker := flag.Bool("cluster-broker", false, "serve the cluster broker at /cluster for other instances to join")
+	clusterTokenPath := flag.String("cluster-token-file", "", "file holding the token instances present to the cluster broker")
+	clusterNoToken := flag.Bool("cluster-no-token", false, "let the cluster broker run without -cluster-token-file, so anyone can join the cluster")
+	flag.Parse()
+
+	if err := setupLogging(*logLevel, *logJSON); err != nil {
+		log.Fatal(err)
+	}
+
+	switch server.config.TimeoutAction {
+	case TimeoutSkip, TimeoutRandom, TimeoutForfeit:
+	default:
+		log.Fatalf("invalid -turn-timeout-action %q", server.config.TimeoutAction)
+	}
+
+	alphabet, err := normalizeAlphabet(server.config.RoomCodeAlphabet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.config.RoomCodeAlphabet = alphabet
+	if server.config.RoomCodeLength < 1 || server.config.PrivateRoomCodeLength < 1 {
+		log.Fatal("room code lengths must be at least 1")
+	}
+	if server.config.MessageRate > 0 && server.config.MessageBurst < 1 {
+		log.Fatal("-message-burst must be at least 1")
+	}
+	if server.config.SendQueueSize < 1 {
+		log.Fatal("-send-queue-size must be at least 1")
+	}
+	server.roomCodeBlocklist, err = LoadRoomCodeBlocklist(*blocklistPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *botTokensPath != "" {
+		tokens, err := LoadBotTokens(*botTokensPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.botTokens = tokens
+	}
+
+	if *adminTokenPath != "" {
+		server.adminToken, err = LoadToken(*adminTokenPath, "admin")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var store Store
+	if *dbPath == "" {
+		store = NewMemoryStore()
+	} else {
+		store, err = OpenBoltStore(*dbPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer store.Close()
+	server.store = store
+	ratings := NewRatings(store)
+	server.ratings = ratings
+
+	if server.instance == "" {
+		server.instance, err = newInstanceID()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var clusterToken string
+	if *clusterTokenPath != "" {
+		clusterToken, err = LoadToken(*clusterTokenPath, "cluster")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *clusterURL != "" && *clusterBroker {
+		log.Fatal("-cluster and -cluster-broker can't be used together: the broker's instance is already in its cluster")
+	}
+	if *clusterBroker && clusterToken == "" && !*clusterNoToken {
+		log.Fatal("-cluster-broker needs -cluster-token-file, or -cluster-no-token to let anyone join the cluster")
+	}
+	if *clusterURL != "" {
+		server.cluster, err = DialBroker(*clusterURL, clusterToken)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Players are kept by the broker's instance, so they are the same
+		// on every instance
+		server.ratings, err = NewBrokerRatings(server.cluster)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		server.cluster = NewLocalCluster()
+	}
+	defer server.cluster.Close()
+	if err := server.cluster.Subscribe(server.instance, handleEnvelope); err != nil {
+		log.Fatal(err)
+	}
+	if *clusterBroker {
+		broker, err := NewBroker(server.cluster, ratings, clusterToken)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle(brokerPath, broker)
+		if clusterToken == "" {
+			slog.Warn("The cluster broker has no token, so anyone can join the cluster")
+		}
+	}
+
+	if err := restoreRooms(); err != nil {
+		logError(slog.Default(), errorStore, "Failed to restore rooms", err)
+	}
+
+	go reapRooms()
+
 	http.HandleFunc("/ws", handleConnections)
+	http.HandleFunc("/leaderboard", handleLeaderboardHTTP)
+	http.HandleFunc("/ladder", handleLadderHTTP)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/metrics", handleMetrics)
+	if server.adminToken != "" {
+		http.HandleFunc(adminPrefix, handleAdmin)
+	}
+	if *serveWeb {
+		http.Handle("/", webHandler())
+	}
+	if *tcpPort != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *tcpPort))
+		if err != nil {
+			log.Fatalf("Failed to listen for TCP clients: %v", err)
+		}
+		go serveTCP(ln)
+	}
+	server.ready.Store(true)
 
-	port := 8080
-	fmt.Printf("Server started on :%d\n", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	slog.Info("Server started", "port", *port, "tcp_port", *tcpPort, "instance", server.instance)
+	err = http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
 }
 
+// handleLeaderboardHTTP serves the leaderboard as JSON.
+func handleLeaderboardHTTP(w http.ResponseWriter, r *http.Request) {
+	serveRankingHTTP(w, r, server.ratings.Leaderboard)
+}
+
+// handleLadderHTTP serves the bot ladder as JSON.
+func handleLadderHTTP(w http.ResponseWriter, r *http.Request) {
+	serveRankingHTTP(w, r, server.ratings.Ladder)
+}
+
+// serveRankingHTTP serves the entries returned by ranking as JSON, honoring
+// an optional limit query parameter.
+func serveRankingHTTP(w http.ResponseWriter, r *http.Request, ranking func(limit int) ([]LeaderboardEntry, error)) {
+	limit := leaderboardSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := ranking(limit)
+	if err != nil {
+		logError(slog.Default(), errorStore, "Failed to load leaderboard", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LeaderboardPayload{Entries: entries})
+}
+
+// handleConnections serves a client connecting over a WebSocket.
 func handleConnections(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
+		logError(slog.With("addr", r.RemoteAddr), errorWebSocket, "Failed to upgrade connection", err)
+		return
+	}
+	ws.SetReadLimit(server.config.MaxMessageSize) // Larger messages close the connection
+	serveClient(newWSTransport(ws), r.RemoteAddr)
+}
+
+// serveClient registers the client at the other end of t, from addr, and
+// handles its messages until it disconnects.
+func serveClient(t clientTransport, addr string) {
+	defer t.Close()
+
+	ip := hostOf(addr)
+	if !acquireConn(ip) {
+		slog.Warn("Rejected connection: too many connections from address", "addr", addr)
+		t.CloseWith(websocket.ClosePolicyViolation, "Too many connections from your address")
+		return
 	}
-	defer ws.Close()
+	defer releaseConn(ip)
 
-	client := &Client{conn: ws}
+	id := newClientID()
+	conn := newRemoteConn(t, server.config.SendQueueSize, slog.With("client", id, "addr", addr))
+	defer conn.shutdown()
+	client := &Client{
+		conn:        conn,
+		id:          id,
+		addr:        addr,
+		connectedAt: time.Now(),
+		kick: func(reason string) {
+			conn.Close(websocket.ClosePolicyViolation, reason)
+		},
+	}
+	server.mu.Lock()
+	server.clients[client.id] = client
+	server.mu.Unlock()
+	defer func() {
+		server.mu.Lock()
+		delete(server.clients, client.id)
+		server.mu.Unlock()
+	}()
+	clientLog(client).Info("Client connected")
 
 	for {
 		var msg Message
-		err := ws.ReadJSON(&msg)
+		err := t.ReadMessage(&msg)
 		if err != nil {
-			log.Printf("error: %v", err)
+			if conn.Closed() || closedByClient(err) {
+				clientLog(client).Info("Client disconnected", "err", err)
+			} else {
+				logError(clientLog(client), errorWebSocket, "Failed to read message", err)
+			}
 			handleDisconnect(client)
 			break
 		}
+		metrics.messages.inc(messageLabel(msg.Type))
+		clientLog(client).Debug("Message received", "msg_type", msg.Type, "size", len(msg.Payload))
 
-		handleMessage(client, msg)
+		if allowMessage(client) {
+			handleMessage(client, msg)
+		} else {
+			rejectMessage(client, msg.Type, RejectRateLimited, nil)
+		}
+		if misbehaving(client) {
+			clientLog(client).Warn("Disconnecting client: too many rejected messages", "rejected", client.rejected)
+			t.CloseWith(websocket.ClosePolicyViolation, "Too many invalid or excessive messages")
+			handleDisconnect(client)
+			break
+		}
 	}
 }
 
 func handleMessage(client *Client, msg Message) {
+	if client.Owner() != "" {
+		handleOwnedMessage(client, msg)
+		return
+	}
+	defer touchRoom(client) // After handling, so joining a room counts too
+
 	switch msg.Type {
 	case MsgCreateRoom:
-		handleCreateRoom(client)
+		var payload CreateRoomPayload
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				sendError(client, "Invalid payload")
+				return
+			}
+		}
+		handleCreateRoom(client, payload.Private)
+	case MsgCreateBotRoom:
+		if client.IsBot() {
+			sendBotError(client, "Bots play in bot rooms; use find_match")
+			return
+		}
+		handleCreateBotRoom(client)
+	case MsgBotHello:
+		var payload BotHelloPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			sendBotError(client, "Invalid payload")
+			return
+		}
+		handleBotHello(client, payload)
+	case MsgFindMatch:
+		handleFindMatch(client)
+	case MsgBotMove:
+		var payload AttackPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			sendBotError(client, "Invalid payload")
+			return
+		}
+		handleBotMove(client, payload)
+	case MsgGetLadder:
+		handleGetLadder(client)
 	case MsgJoinRoom:
 		var payload JoinRoomPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 			sendError(client, "Invalid payload")
 			return
 		}
+		if handOff(client, payload.Code, msg) {
+			return
+		}
 		handleJoinRoom(client, payload.Code)
+	case MsgSpectateRoom:
+		var payload JoinRoomPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			sendError(client, "Invalid payload")
+			return
+		}
+		if handOff(client, payload.Code, msg) {
+			return
+		}
+		handleSpectateRoom(client, payload.Code)
+	case MsgChat:
+		var payload ChatPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			sendChatRejected(client, "Invalid payload")
+			return
+		}
+		handleChat(client, payload)
+	case MsgRematchRequest:
+		handleRematchRequest(client)
+	case MsgRematchDecline:
+		handleRematchDecline(client)
+	case MsgHello:
+		var payload HelloPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			sendHelloError(client, "Invalid payload")
+			return
+		}
+		handleHello(client, payload)
+	case MsgGetLeaderboard:
+		handleGetLeaderboard(client)
 	default:
 		// Relay game messages if in a room
-		if client.room != nil {
+		if reason, err := validateRelay(msg); err != nil {
+			rejectMessage(client, msg.Type, reason, err)
+			return
+		}
+		if client.Room() != nil {
 			relayMessage(client, msg)
 		}
 	}
 }
 
-func handleCreateRoom(client *Client) {
-	code := generateRoomCode()
+// handleCreateRoom opens a room hosted by client, or returns nil if the
+// server is in maintenance mode or no room code is free.
+func handleCreateRoom(client *Client, private bool) *Room {
+	return createRoom(client, private, nil)
+}
+
+// createRoom opens a room hosted by client and, if guest isn't nil, seats
+// guest in it before anyone else can join.
+func createRoom(client *Client, private bool, guest *Client) *Room {
+	if on, message := maintenanceMode(); on {
+		sendError(client, message)
+		return nil
+	}
+
+	now := time.Now()
 	room := &Room{
-		Code: code,
-		Host: client,
+		Host:         client,
+		CreatedAt:    now,
+		lastActivity: now,
+		phase:        PhaseWaiting,
+		botsOnly:     client.IsBot(),
 	}
 
-	server.mu.Lock()
-	server.rooms[code] = room
-	server.mu.Unlock()
+	// The room can be looked up as soon as it has a code, so it is locked
+	// until it is set up
+	room.mu.Lock()
+	defer room.mu.Unlock()
 
-	client.room = room
+	if err := allocateRoom(room, private); err != nil {
+		logError(clientLog(client), errorRoomCode, "Failed to allocate room code", err)
+		sendError(client, "The server is full, try again later")
+		return nil
+	}
+	code := room.Code
+
+	client.setRoom(room)
 	client.isHost = true
+	saveRoom(room)
 
 	// Send room code back to host
 	payload, _ := json.Marshal(CreateRoomResponse{Code: code})
@@ -151,7 +735,65 @@ func handleCreateRoom(client *Client) {
 	}
 	client.conn.WriteJSON(response)
 
-	log.Printf("Room created: %s", code)
+	logRoomEvent(room, client, EventCreated, "private", private, "bots_only", room.botsOnly)
+	if guest != nil {
+		seatGuest(room, guest)
+	}
+	return room
+}
+
+// handleHello records the persistent identity of a client.
+func handleHello(client *Client, payload HelloPayload) {
+	if client.IsBot() {
+		return // Bots are identified by their token
+	}
+	id := strings.TrimSpace(payload.PlayerID)
+	if id == "" {
+		sendHelloError(client, "Missing player ID")
+		return
+	}
+	if isBotID(id) {
+		sendHelloError(client, "Invalid player ID")
+		return
+	}
+
+	name := strings.TrimSpace(withoutControl(payload.Name))
+	if name == "" {
+		name = "Anonymous"
+	}
+	if len([]rune(name)) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+
+	// The ID is only known to its player, but a secret is checked too in case
+	// it leaked
+	player := PlayerInfo{ID: id, Name: name}
+	issued, err := server.ratings.Identify(player, payload.Secret)
+	if errors.Is(err, errWrongSecret) {
+		clientLog(client).Warn("Rejected hello: wrong secret for player", "player", bnet.PublicPlayerID(id))
+		sendHelloError(client, "This player ID belongs to someone else")
+		return
+	}
+	if err != nil {
+		logError(clientLog(client), errorStore, "Failed to register player", err)
+		sendHelloError(client, "Player identity unavailable")
+		return
+	}
+
+	client.setPlayer(player)
+	sendJSON(client, MsgWelcome, WelcomePayload{Secret: issued})
+}
+
+func handleGetLeaderboard(client *Client) {
+	entries, err := server.ratings.Leaderboard(leaderboardSize)
+	if err != nil {
+		logError(clientLog(client), errorStore, "Failed to load leaderboard", err)
+		sendError(client, "Leaderboard unavailable")
+		return
+	}
+
+	payload, _ := json.Marshal(LeaderboardPayload{Entries: entries})
+	client.conn.WriteJSON(Message{Type: MsgLeaderboard, Payload: payload})
 }
 
 func handleJoinRoom(client *Client, code string) {
@@ -168,14 +810,38 @@ func handleJoinRoom(client *Client, code string) {
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
+	if room.closed {
+		sendError(client, "Room not found") // Torn down since it was looked up
+		return
+	}
+	if room.botsOnly != client.IsBot() {
+		if client.IsBot() {
+			sendError(client, "Bots can only join bot rooms")
+		} else {
+			sendError(client, "Room is reserved for bots")
+		}
+		return
+	}
+
+	if room.reserved != nil {
+		rejoinRoom(client, room)
+		return
+	}
+
 	if room.Guest != nil {
 		sendError(client, "Room is full")
 		return
 	}
+	seatGuest(room, client)
+}
 
+// seatGuest makes client the guest of room and starts the game.
+// Must be called with room.mu held.
+func seatGuest(room *Room, client *Client) {
 	room.Guest = client
-	client.room = room
+	client.setRoom(room)
 	client.isHost = false
+	saveRoom(room)
 
 	// Notify Guest they joined
 	client.conn.WriteJSON(Message{Type: MsgGameStart, Payload: []byte("{}")})
@@ -184,12 +850,61 @@ func handleJoinRoom(client *Client, code string) {
 	if room.Host != nil {
 		room.Host.conn.WriteJSON(Message{Type: MsgPlayerJoined, Payload: []byte("{}")})
 	}
+	if len(room.Spectators) > 0 {
+		sendJSON(client, MsgSpectatorCount, SpectatorCountPayload{Count: len(room.Spectators)})
+	}
+	broadcastSpectatorState(room)
+	logRoomEvent(room, client, EventJoined)
+	startPlacement(room)
+}
+
+// rejoinRoom seats a returning player in a room restored from storage. The
+// first player back becomes the host and waits; the second starts the game.
+// Once every reserved player is back, the room is an ordinary one, so a host
+// whose room had no guest waits for anyone to join. Must be called with
+// room.mu held.
+func rejoinRoom(client *Client, room *Room) {
+	reserved := slices.IndexFunc(room.reserved, func(p PlayerInfo) bool {
+		return p.ID == client.Player().ID
+	})
+	if client.Player().ID == "" || reserved < 0 {
+		sendError(client, "Room is reserved for its original players")
+		return
+	}
+	room.reserved = slices.Delete(room.reserved, reserved, reserved+1)
+	if len(room.reserved) == 0 {
+		room.reserved = nil
+	}
+
+	client.setRoom(room)
+	if room.Host == nil {
+		room.Host = client
+		client.isHost = true
+		if room.reserved == nil {
+			// No guest is coming back, so wait for one from now on, like a new room
+			room.CreatedAt = time.Now()
+			saveRoom(room)
+		}
+
+		payload, _ := json.Marshal(CreateRoomResponse{Code: room.Code})
+		client.conn.WriteJSON(Message{Type: MsgRoomCreated, Payload: payload})
+		logRoomEvent(room, client, EventRejoined, "role", RoleHost)
+		return
+	}
 
-	log.Printf("Player joined room: %s", code)
+	room.Guest = client
+	client.isHost = false
+	saveRoom(room)
+
+	client.conn.WriteJSON(Message{Type: MsgGameStart, Payload: []byte("{}")})
+	room.Host.conn.WriteJSON(Message{Type: MsgPlayerJoined, Payload: []byte("{}")})
+	logRoomEvent(room, client, EventRejoined, "role", RoleGuest)
+	startPlacement(room)
 }
 
 func relayMessage(sender *Client, msg Message) {
-	room := sender.room
+	start := time.Now()
+	room := sender.Room()
 	if room == nil {
 		return
 	}
@@ -197,6 +912,10 @@ func relayMessage(sender *Client, msg Message) {
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
+	if room.closed || sender.IsSpectator() {
+		return // Spectators only watch
+	}
+
 	var target *Client
 	if sender == room.Host {
 		target = room.Guest
@@ -204,20 +923,123 @@ func relayMessage(sender *Client, msg Message) {
 		target = room.Host
 	}
 
+	if room.botsOnly && msg.Type != MsgShipsPlaced {
+		return // The server resolves shots in bot rooms; bots use bot_move
+	}
+
+	switch msg.Type {
+	case MsgShipsPlaced:
+		if room.phase != PhasePlacement {
+			return // Late placement after a forfeit
+		}
+		if room.botsOnly && !placeBotFleet(room, sender, msg) {
+			return
+		}
+		msg = recordFleet(room, sender, msg)
+	case MsgAttack:
+		if !acceptAttack(room, sender, msg) {
+			return // Out of turn
+		}
+	case MsgAttackResult:
+		recordShot(room, sender, msg)
+	}
+
 	if target != nil {
 		target.conn.WriteJSON(msg)
+		observeRelay(start)
+	}
+
+	switch msg.Type {
+	case MsgShipsPlaced:
+		markPlaced(room, sender)
+	case MsgAttackResult:
+		completeAttack(room, sender)
+	case MsgGameOver:
+		var payload GameOverPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err == nil && payload.YouWon {
+			finishGame(room, OutcomeCompleted, target, sender)
+		}
+	}
+}
+
+// finishGame ends the game in room, tells spectators and rates the result.
+// The losing client announces game over to its opponent, so the sender of a
+// you_won message is the loser. A game with outcome OutcomeNoContest has
+// no winner or loser and isn't rated.
+// Must be called with room.mu held.
+func finishGame(room *Room, outcome string, winner, loser *Client) {
+	if room.finished || (winner == nil && outcome != OutcomeNoContest) {
+		return
+	}
+	room.finished = true
+	room.phase = PhaseFinished
+	metrics.gamesFinished.inc(outcome)
+	stopClock(room)
+
+	switch {
+	case outcome == OutcomeNoContest:
+		room.winner = ""
+	case winner == room.Host:
+		room.winner = RoleHost
+		room.hostWins++
+	default:
+		room.winner = RoleGuest
+		room.guestWins++
+	}
+	logRoomEvent(room, nil, EventFinished, "outcome", outcome, "winner", room.winner, "host_wins", room.hostWins, "guest_wins", room.guestWins)
+	for _, s := range room.Spectators {
+		sendJSON(s, MsgSpectateGameOver, SpectateGameOverPayload{Winner: room.winner})
+	}
+	revealFleets(room)
+
+	if outcome != OutcomeNoContest {
+		recordResult(room, winner, loser)
+	}
+}
+
+// recordResult rates a finished game between two identified players.
+// Must be called with room.mu held.
+func recordResult(room *Room, winner, loser *Client) {
+	winnerInfo, loserInfo := winner.Player(), loser.Player()
+	if winnerInfo.ID == "" || loserInfo.ID == "" || winnerInfo.ID == loserInfo.ID {
+		return
+	}
+
+	record, err := server.ratings.RecordResult(room.Code, winnerInfo, loserInfo)
+	if err != nil {
+		logError(roomLog(room), errorStore, "Failed to record result", err)
+		return
 	}
+	roomLog(room).Info("Game rated", "winner", bnet.PublicPlayerID(winnerInfo.ID), "loser", bnet.PublicPlayerID(loserInfo.ID), "delta", record.Delta)
 }
 
 func handleDisconnect(client *Client) {
-	if client.room == nil {
+	if client.Owner() != "" {
+		leaveOwner(client)
+		return
+	}
+	room := client.Room()
+	if room == nil {
 		return
 	}
 
-	room := client.room
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
+	if room.closed {
+		return // Torn down while the client was leaving
+	}
+	if client.IsSpectator() {
+		logRoomEvent(room, client, EventPlayerLeft, "role", "spectator")
+		removeSpectator(room, client)
+		return
+	}
+	logRoomEvent(room, client, EventPlayerLeft, "role", roleOf(room, client), "phase", room.phase)
+	stopClock(room)
+	if room.phase == PhasePlacement || room.phase == PhaseBattle {
+		metrics.gamesFinished.inc(OutcomeAbandoned)
+	}
+
 	// Notify other player
 	var target *Client
 	if client == room.Host {
@@ -227,14 +1049,135 @@ func handleDisconnect(client *Client) {
 	}
 
 	if target != nil {
-		target.conn.WriteJSON(Message{Type: MsgOpponentLeft, Payload: []byte("{}")})
-		target.room = nil // Unlink them so they can join another game? Or just end session.
+		// After a declined rematch the opponent has already been told the
+		// series is over; opponent_left would replace that with a disconnect.
+		if !room.rematchDeclined {
+			target.conn.WriteJSON(Message{Type: MsgOpponentLeft, Payload: []byte("{}")})
+		}
+		target.setRoom(nil) // Unlink them so they can join another game? Or just end session.
+	}
+	for _, s := range room.Spectators {
+		s.conn.WriteJSON(Message{Type: MsgOpponentLeft, Payload: []byte("{}")})
+		s.setRoom(nil)
 	}
 
-	// Remove room
-	server.mu.Lock()
-	delete(server.rooms, room.Code)
-	server.mu.Unlock()
+	removeRoom(room)
+
+	if err := server.store.DeleteRoom(room.Code); err != nil {
+		logError(roomLog(room), errorStore, "Failed to delete room", err)
+	}
+	logRoomEvent(room, nil, EventClosed, "reason", EventPlayerLeft)
+}
+
+// saveRoom persists a snapshot of room. Must be called with room.mu held,
+// or before the room is visible to other goroutines.
+func saveRoom(room *Room) {
+	snapshot := RoomSnapshot{
+		Code:      room.Code,
+		CreatedAt: room.CreatedAt,
+		UpdatedAt: time.Now(),
+	}
+	if room.Host != nil {
+		snapshot.Host = room.Host.Player()
+	}
+	if room.Guest != nil {
+		snapshot.Guest = room.Guest.Player()
+	}
+
+	if err := server.store.SaveRoom(snapshot); err != nil {
+		logError(roomLog(room), errorStore, "Failed to save room", err)
+	}
+}
+
+// restoreRooms reloads rooms that were in flight when the server last stopped.
+// Their connections are gone, so each room is held open for its original
+// players to rejoin with the same code, and discarded if they don't.
+func restoreRooms() error {
+	snapshots, err := server.store.Rooms()
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, snap := range snapshots {
+		if snap.Host.ID == "" && snap.Guest.ID == "" {
+			// Anonymous players can't prove who they are, so nobody could rejoin
+			server.store.DeleteRoom(snap.Code)
+			continue
+		}
+		if snap.Guest.ID == "" && snap.Guest.Name == botName {
+			// The bot went down with the server
+			server.store.DeleteRoom(snap.Code)
+			continue
+		}
+
+		if err := server.cluster.ClaimRoom(snap.Code, server.instance); err != nil {
+			// Another instance has reused the code since
+			logError(slog.With("room", snap.Code), errorCluster, "Failed to claim restored room", err)
+			server.store.DeleteRoom(snap.Code)
+			continue
+		}
+
+		// Players who had no identity can't rejoin, so their seats are open
+		var reserved []PlayerInfo
+		for _, p := range []PlayerInfo{snap.Host, snap.Guest} {
+			if p.ID != "" {
+				reserved = append(reserved, p)
+			}
+		}
+		room := &Room{
+			Code:         snap.Code,
+			CreatedAt:    snap.CreatedAt,
+			lastActivity: time.Now(),
+			phase:        PhaseWaiting,
+			reserved:     reserved,
+			botsOnly:     isBotID(snap.Host.ID),
+		}
+
+		server.mu.Lock()
+		server.rooms[room.Code] = room
+		server.mu.Unlock()
+
+		time.AfterFunc(restoredRoomTTL, func() { expireRestoredRoom(room) })
+		logRoomEvent(room, nil, EventRestored, "host", bnet.PublicPlayerID(snap.Host.ID), "guest", bnet.PublicPlayerID(snap.Guest.ID))
+		restored++
+	}
+
+	if restored > 0 {
+		slog.Info("Restored rooms from storage", "count", restored)
+	}
+	return nil
+}
+
+// expireRestoredRoom discards a restored room whose players did not return.
+func expireRestoredRoom(room *Room) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.reserved == nil {
+		return // Both players came back
+	}
+	if room.Host != nil {
+		room.Host.conn.WriteJSON(Message{Type: MsgOpponentLeft, Payload: []byte("{}")})
+		room.Host.setRoom(nil)
+	}
+
+	removeRoom(room)
+
+	if err := server.store.DeleteRoom(room.Code); err != nil {
+		logError(roomLog(room), errorStore, "Failed to delete room", err)
+	}
+	logRoomEvent(room, nil, EventClosed, "reason", "not_rejoined")
+}
+
+// sendJSON marshals payload and sends it to client as msgType.
+func sendJSON(client *Client, msgType string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logError(clientLog(client), errorEncode, "Failed to encode message", err, "msg_type", msgType)
+		return
+	}
+	client.conn.WriteJSON(Message{Type: msgType, Payload: data})
 }
 
 func sendError(client *Client, message string) {
@@ -245,11 +1188,8 @@ func sendError(client *Client, message string) {
 	})
 }
 
-func generateRoomCode() string {
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	b := make([]byte, 4)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
+// sendHelloError tells client its hello was refused, so it is playing
+// without an identity.
+func sendHelloError(client *Client, message string) {
+	sendJSON(client, MsgHelloError, ErrorPayload{Message: message})
 }
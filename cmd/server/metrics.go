@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of errors counted in battleship_errors_total
+const (
+	errorStore     = "store"     // Reading or writing the database
+	errorWebSocket = "websocket" // Upgrading, reading or closing a connection
+	errorRoomCode  = "room_code" // No room code could be allocated
+	errorEncode    = "encode"    // A message could not be marshaled
+	errorCluster   = "cluster"   // Talking to the room registry or message bus
+)
+
+// relayLatencyBuckets are the upper bounds, in seconds, of the relay latency
+// histogram buckets.
+var relayLatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// Outcomes of a game, as labeled in battleship_games_finished_total
+const (
+	OutcomeCompleted = "completed"  // a player won
+	OutcomeNoContest = "no_contest" // ended without a winner
+	OutcomeAbandoned = "abandoned"  // a player left, or the room was closed
+)
+
+// serverMetrics holds the counters exposed at /metrics, in the Prometheus
+// text format. Gauges such as open rooms are computed when scraped.
+type serverMetrics struct {
+	gamesStarted  atomic.Int64
+	slowClients   atomic.Int64
+	gamesFinished labeledCounter // by outcome
+	messages      labeledCounter // received, by message type
+	rejected      labeledCounter // by reason
+	errors        labeledCounter // by kind
+	relayLatency  histogram
+}
+
+var metrics = &serverMetrics{
+	relayLatency: histogram{bounds: relayLatencyBuckets, counts: make([]int64, len(relayLatencyBuckets))},
+}
+
+// labeledCounter counts events by a single label value.
+type labeledCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *labeledCounter) inc(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[label]++
+}
+
+// snapshot returns a copy of the counts.
+func (c *labeledCounter) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	for label, n := range c.counts {
+		counts[label] = n
+	}
+	return counts
+}
+
+// histogram counts observations into buckets by upper bound.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []int64 // per bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// logError logs err with msg and args to logger and counts it as an error
+// of kind.
+func logError(logger *slog.Logger, kind, msg string, err error, args ...any) {
+	metrics.errors.inc(kind)
+	logger.Error(msg, append([]any{"kind", kind, "err", err}, args...)...)
+}
+
+// messageLabel is the label msgType is counted under. Types the server
+// doesn't know share one label, so clients can't create new series.
+func messageLabel(msgType string) string {
+	if _, ok := relayValidators[msgType]; ok || requestTypes[msgType] {
+		return msgType
+	}
+	return "unknown"
+}
+
+// requestTypes are the messages the server handles itself.
+var requestTypes = map[string]bool{
+	MsgCreateRoom: true, MsgCreateBotRoom: true, MsgJoinRoom: true, MsgSpectateRoom: true,
+	MsgBotHello: true, MsgFindMatch: true, MsgBotMove: true, MsgGetLadder: true,
+	MsgChat: true, MsgRematchRequest: true, MsgRematchDecline: true,
+	MsgHello: true, MsgGetLeaderboard: true,
+}
+
+// handleHealthz reports that the process is up.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the server has finished starting and its
+// store and cluster are reachable.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !server.ready.Load() {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := server.store.Games(1); err != nil {
+		logError(slog.Default(), errorStore, "Readiness check failed", err)
+		http.Error(w, "store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if _, _, err := server.cluster.LookupRoom(""); err != nil {
+		logError(slog.Default(), errorCluster, "Readiness check failed", err)
+		http.Error(w, "cluster unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	server.mu.RLock()
+	var conns int
+	for _, n := range server.connsByIP {
+		conns += n
+	}
+	rooms := make([]*Room, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
+	}
+	server.mu.RUnlock()
+
+	byState := map[string]int64{PhaseWaiting: 0, PhasePlacement: 0, PhaseBattle: 0, PhaseFinished: 0, "restored": 0}
+	var spectators int
+	for _, room := range rooms {
+		room.mu.Lock()
+		state := room.phase
+		if room.reserved != nil {
+			state = "restored" // Waiting for its players to come back after a restart
+		}
+		byState[state]++
+		spectators += len(room.Spectators)
+		room.mu.Unlock()
+	}
+
+	writeMetric(w, "battleship_connections", "gauge", "Open WebSocket connections.", float64(conns))
+	writeMetric(w, "battleship_spectators", "gauge", "Spectators watching a room.", float64(spectators))
+	writeLabeled(w, "battleship_rooms", "gauge", "Open rooms by state.", "state", byState)
+	writeMetric(w, "battleship_games_started_total", "counter", "Games that reached ship placement, including rematches.", float64(metrics.gamesStarted.Load()))
+	writeLabeled(w, "battleship_games_finished_total", "counter", "Games that ended, by outcome.", "outcome", metrics.gamesFinished.snapshot())
+	writeLabeled(w, "battleship_messages_received_total", "counter", "Messages received from clients, by type.", "type", metrics.messages.snapshot())
+	writeLabeled(w, "battleship_messages_rejected_total", "counter", "Messages dropped for being too fast, of unknown type or malformed, by reason.", "reason", metrics.rejected.snapshot())
+	writeMetric(w, "battleship_slow_clients_total", "counter", "Clients disconnected for not reading their messages fast enough.", float64(metrics.slowClients.Load()))
+	writeLabeled(w, "battleship_errors_total", "counter", "Errors, by kind.", "kind", metrics.errors.snapshot())
+	metrics.relayLatency.write(w, "battleship_relay_latency_seconds", "Time taken to relay a game message to the opponent.")
+}
+
+// writeMetric writes a metric without labels.
+func writeMetric(w io.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
+
+// writeLabeled writes a metric with one label, in label order.
+func writeLabeled(w io.Writer, name, kind, help, label string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+// write writes h with cumulative buckets.
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// observeRelay records how long it took to relay a message received at start.
+func observeRelay(start time.Time) {
+	metrics.relayLatency.observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// rejectWindow is how long rejected messages count against a client, so
+// one that is rejected now and then over a long session isn't disconnected.
+const rejectWindow = time.Minute
+
+// tokenBucket is a simple token-bucket rate limiter. It is not safe for
+// concurrent use: a client's message limit is only used by its read loop,
+// and its chat limit with its room's lock held.
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64 // tokens added per second
+	last     time.Time
+}
+
+// newTokenBucket creates a full bucket holding capacity tokens that refills
+// at rate tokens per second.
+func newTokenBucket(capacity, rate float64) *tokenBucket {
+	return &tokenBucket{
+		tokens:   capacity,
+		capacity: capacity,
+		rate:     rate,
+		last:     time.Now(),
+	}
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow() bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Reasons a message from a client is rejected
+const (
+	RejectRateLimited    = "rate_limited"
+	RejectUnknownType    = "unknown_type"
+	RejectInvalidPayload = "invalid_payload"
+)
+
+// allowMessage applies client's message rate limit. Only the connection's
+// read loop calls it, so the bucket needs no lock.
+func allowMessage(client *Client) bool {
+	if server.config.MessageRate <= 0 {
+		return true
+	}
+	if client.msgLimit == nil {
+		client.msgLimit = newTokenBucket(float64(server.config.MessageBurst), server.config.MessageRate)
+	}
+	return client.msgLimit.allow()
+}
+
+// rejectMessage drops a message from client, counting it against the client
+// for rejectWindow and in the server-wide totals. err, if any, says what was
+// wrong with it. Bots are told why; the TUI client never sends such messages.
+// Like allowMessage, only the connection's read loop calls it.
+func rejectMessage(client *Client, msgType, reason string, err error) {
+	if now := time.Now(); now.Sub(client.rejectStart) > rejectWindow {
+		client.rejected = 0
+		client.rejectStart = now
+	}
+	client.rejected++
+	metrics.rejected.inc(reason)
+
+	if err == nil {
+		err = errors.New("Slow down! You're sending messages too fast")
+	}
+	if client.IsBot() {
+		sendBotError(client, err.Error())
+	}
+	if client.rejected == 1 || client.rejected%10 == 0 { // Don't let a flood flood the log too
+		clientLog(client).Warn("Rejected message", "msg_type", msgType, "reason", reason, "err", err, "rejected", client.rejected)
+	}
+}
+
+// misbehaving reports whether client has had too many messages rejected
+// within rejectWindow and should be disconnected.
+func misbehaving(client *Client) bool {
+	return server.config.MaxRejected > 0 && client.rejected >= server.config.MaxRejected
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRejectedMessagesDecay(t *testing.T) {
+	saved := server.config.MaxRejected
+	server.config.MaxRejected = 3
+	defer func() { server.config.MaxRejected = saved }()
+
+	client, _ := newTestClient("player")
+	for i := 0; i < 2; i++ {
+		rejectMessage(client, MsgAttack, RejectInvalidPayload, nil)
+	}
+	if misbehaving(client) {
+		t.Fatal("client disconnected before reaching the limit")
+	}
+
+	// Rejections from longer ago than the window are forgiven
+	client.rejectStart = time.Now().Add(-rejectWindow - time.Second)
+	rejectMessage(client, MsgAttack, RejectInvalidPayload, nil)
+	if misbehaving(client) || client.rejected != 1 {
+		t.Errorf("client has %d rejections after the window passed, want 1", client.rejected)
+	}
+
+	for i := 0; i < 2; i++ {
+		rejectMessage(client, MsgAttack, RejectInvalidPayload, nil)
+	}
+	if !misbehaving(client) {
+		t.Error("client reached the limit within the window but isn't disconnected")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	bnet "battle-ship/net"
+)
+
+const (
+	// initialRating is the Elo rating given to a player's first game.
+	initialRating = 1200.0
+	// eloK is the maximum rating change from a single game.
+	eloK = 32.0
+)
+
+// errWrongSecret is returned when a player ID is used without the secret
+// issued for it.
+var errWrongSecret = errors.New("wrong secret for player")
+
+// LeaderboardEntry is a single row of the leaderboard. Player IDs are what
+// players identify with, so the leaderboard only shows them hashed.
+type LeaderboardEntry struct {
+	Rank   int     `json:"rank"`
+	Player string  `json:"player"`
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"`
+	Wins   int     `json:"wins"`
+	Losses int     `json:"losses"`
+}
+
+// PlayerRatings identifies players and rates their games. Ratings keeps
+// them in its own store; the instances of a cluster ask the broker's, so a
+// player has one secret and one rating on all of them.
+type PlayerRatings interface {
+	// Identify checks the secret a player presented in hello, returning a
+	// new one if the player has none yet.
+	Identify(info PlayerInfo, secret string) (string, error)
+	// Register makes sure a player has a stored record with their latest name.
+	Register(info PlayerInfo) error
+	// RecordResult rates a finished game and stores its result.
+	RecordResult(roomCode string, winner, loser PlayerInfo) (GameRecord, error)
+	// Leaderboard returns up to limit human players ordered by rating.
+	Leaderboard(limit int) ([]LeaderboardEntry, error)
+	// Ladder returns up to limit bots ordered by rating.
+	Ladder(limit int) ([]LeaderboardEntry, error)
+}
+
+// Ratings computes Elo ratings for finished games on top of a Store.
+type Ratings struct {
+	store Store
+	mu    sync.Mutex // serializes read-modify-write of player records
+}
+
+// NewRatings creates a rating service backed by store.
+func NewRatings(store Store) *Ratings {
+	return &Ratings{store: store}
+}
+
+// RecordResult applies an Elo update for a finished game and stores the result.
+func (r *Ratings) RecordResult(roomCode string, winner, loser PlayerInfo) (GameRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, err := r.loadPlayer(winner)
+	if err != nil {
+		return GameRecord{}, err
+	}
+	l, err := r.loadPlayer(loser)
+	if err != nil {
+		return GameRecord{}, err
+	}
+
+	newWinner, newLoser := updateElo(w.Rating, l.Rating)
+	record := GameRecord{
+		RoomCode:   roomCode,
+		WinnerID:   w.ID,
+		LoserID:    l.ID,
+		Delta:      newWinner - w.Rating,
+		FinishedAt: time.Now(),
+	}
+
+	w.Rating, l.Rating = newWinner, newLoser
+	w.Wins++
+	l.Losses++
+	w.UpdatedAt, l.UpdatedAt = record.FinishedAt, record.FinishedAt
+
+	if err := r.store.RecordGame(w, l, record); err != nil {
+		return GameRecord{}, fmt.Errorf("failed to record result: %w", err)
+	}
+	return record, nil
+}
+
+// Leaderboard returns up to limit human players ordered by rating, highest first.
+func (r *Ratings) Leaderboard(limit int) ([]LeaderboardEntry, error) {
+	return r.ranking(limit, false)
+}
+
+// Ladder returns up to limit bots ordered by rating, highest first. Bots
+// only play each other, so they are ranked apart from human players.
+func (r *Ratings) Ladder(limit int) ([]LeaderboardEntry, error) {
+	return r.ranking(limit, true)
+}
+
+// ranking returns up to limit rated players, either bots or humans.
+func (r *Ratings) ranking(limit int, bots bool) ([]LeaderboardEntry, error) {
+	records, err := r.store.Players()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leaderboard: %w", err)
+	}
+
+	// Players who have only been seen, never rated, stay off the board
+	rated := records[:0]
+	for _, p := range records {
+		if p.Wins+p.Losses > 0 && isBotID(p.ID) == bots {
+			rated = append(rated, p)
+		}
+	}
+	records = rated
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Rating != records[j].Rating {
+			return records[i].Rating > records[j].Rating
+		}
+		return records[i].Name < records[j].Name
+	})
+
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	entries := make([]LeaderboardEntry, len(records))
+	for i, p := range records {
+		entries[i] = LeaderboardEntry{
+			Rank:   i + 1,
+			Player: bnet.PublicPlayerID(p.ID),
+			Name:   p.Name,
+			Rating: math.Round(p.Rating),
+			Wins:   p.Wins,
+			Losses: p.Losses,
+		}
+	}
+	return entries, nil
+}
+
+// Register makes sure a player has a stored record with their latest name.
+func (r *Ratings) Register(info PlayerInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.register(info, func(*PlayerRecord) error { return nil })
+}
+
+// Identify registers a player who presented secret in hello. A player ID
+// seen for the first time, or stored before secrets were issued, is issued
+// a new secret, which is returned; otherwise secret must be the one issued.
+func (r *Ratings) Identify(info PlayerInfo, secret string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var issued string
+	err := r.register(info, func(p *PlayerRecord) error {
+		if p.SecretHash == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				return fmt.Errorf("failed to issue secret: %w", err)
+			}
+			issued = hex.EncodeToString(b)
+			p.SecretHash = hashSecret(issued)
+			return nil
+		}
+		if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(p.SecretHash)) != 1 {
+			return errWrongSecret
+		}
+		return nil
+	})
+	return issued, err
+}
+
+// register runs check on the stored record of a player, or on a fresh one
+// for a new player, and saves it with the player's latest name if check
+// accepts it. r.mu must be held.
+func (r *Ratings) register(info PlayerInfo, check func(*PlayerRecord) error) error {
+	record, found, err := r.store.Player(info.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		record = PlayerRecord{ID: info.ID, Rating: initialRating, CreatedAt: time.Now()}
+	}
+	before := record
+	if err := check(&record); err != nil {
+		return err
+	}
+	record.Name = info.Name
+	if found && record == before {
+		return nil // Nothing changed
+	}
+	record.UpdatedAt = time.Now()
+	return r.store.SavePlayer(record)
+}
+
+// hashSecret returns the hash of a player's secret that is stored.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadPlayer reads a player record, creating a fresh one for new players.
+// The stored name is refreshed from info so renames show up on the leaderboard.
+func (r *Ratings) loadPlayer(info PlayerInfo) (PlayerRecord, error) {
+	record, found, err := r.store.Player(info.ID)
+	if err != nil {
+		return PlayerRecord{}, err
+	}
+	if !found {
+		record = PlayerRecord{ID: info.ID, Rating: initialRating, CreatedAt: time.Now()}
+	}
+	record.Name = info.Name
+	return record, nil
+}
+
+// expectedScore returns the probability that a player rated a beats one rated b.
+func expectedScore(a, b float64) float64 {
+	return 1 / (1 + math.Pow(10, (b-a)/400))
+}
+
+// updateElo returns the new ratings of the winner and loser of a game.
+func updateElo(winner, loser float64) (float64, float64) {
+	delta := eloK * (1 - expectedScore(winner, loser))
+	return winner + delta, loser - delta
+}
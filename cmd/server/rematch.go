@@ -0,0 +1,92 @@
+package main
+
+// RematchStartPayload starts the next game of a series in the same room.
+type RematchStartPayload struct {
+	Game           int  `json:"game"`
+	HostMovesFirst bool `json:"host_moves_first"`
+	HostWins       int  `json:"host_wins"`
+	GuestWins      int  `json:"guest_wins"`
+}
+
+// handleRematchRequest records a player's vote for a rematch. The request is
+// relayed so the opponent can be prompted, and once both players have asked
+// the room is reset and the next game starts.
+func handleRematchRequest(client *Client) {
+	room := client.Room()
+	if room == nil || client.IsSpectator() {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.closed || !room.finished || room.Host == nil || room.Guest == nil {
+		return
+	}
+
+	if client == room.Host {
+		room.hostWantsRematch = true
+		room.Guest.conn.WriteJSON(Message{Type: MsgRematchRequest, Payload: []byte("{}")})
+	} else {
+		room.guestWantsRematch = true
+		room.Host.conn.WriteJSON(Message{Type: MsgRematchRequest, Payload: []byte("{}")})
+	}
+
+	if room.hostWantsRematch && room.guestWantsRematch {
+		startRematch(room)
+	}
+}
+
+// handleRematchDecline tells the opponent that the series is over.
+func handleRematchDecline(client *Client) {
+	room := client.Room()
+	if room == nil || client.IsSpectator() {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.closed || !room.finished {
+		return
+	}
+	room.hostWantsRematch = false
+	room.guestWantsRematch = false
+	room.rematchDeclined = true
+
+	target := room.Host
+	if client == room.Host {
+		target = room.Guest
+	}
+	if target != nil {
+		target.conn.WriteJSON(Message{Type: MsgRematchDecline, Payload: []byte("{}")})
+	}
+}
+
+// startRematch resets the room for the next game. The first move alternates
+// between host and guest from one game to the next.
+// Must be called with room.mu held.
+func startRematch(room *Room) {
+	room.gamesPlayed++
+	room.finished = false
+	room.winner = ""
+	room.hostWantsRematch = false
+	room.guestWantsRematch = false
+	room.rematchDeclined = false
+	room.hostFleet = nil
+	room.guestFleet = nil
+	room.fleetsRevealed = false
+	room.shots = nil
+
+	payload := RematchStartPayload{
+		Game:           room.gamesPlayed + 1,
+		HostMovesFirst: room.gamesPlayed%2 == 0,
+		HostWins:       room.hostWins,
+		GuestWins:      room.guestWins,
+	}
+	sendJSON(room.Host, MsgRematchStart, payload)
+	sendJSON(room.Guest, MsgRematchStart, payload)
+	broadcastSpectatorState(room)
+	logRoomEvent(room, nil, EventRematch, "game", payload.Game)
+	startPlacement(room)
+}
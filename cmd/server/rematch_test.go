@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLeavingAfterDeclinedRematch(t *testing.T) {
+	room, host, guest, hostConn, _ := startTestGame(t)
+	room.mu.Lock()
+	finishGame(room, OutcomeCompleted, host, guest)
+	room.mu.Unlock()
+
+	handleRematchDecline(guest)
+	handleDisconnect(guest)
+
+	types := hostConn.types()
+	if !slices.Contains(types, MsgRematchDecline) {
+		t.Errorf("host was not told of the decline: %v", types)
+	}
+	if slices.Contains(types, MsgOpponentLeft) {
+		t.Errorf("host was told the opponent left after declining: %v", types)
+	}
+	if host.Room() != nil {
+		t.Error("host is still in the closed room")
+	}
+}
+
+func TestLeavingWithoutDeclining(t *testing.T) {
+	room, host, guest, hostConn, _ := startTestGame(t)
+	room.mu.Lock()
+	finishGame(room, OutcomeCompleted, host, guest)
+	room.mu.Unlock()
+
+	handleDisconnect(guest)
+
+	if types := hostConn.types(); !slices.Contains(types, MsgOpponentLeft) {
+		t.Errorf("host was not told the opponent left: %v", types)
+	}
+}
+
+func TestDeclineDuringGame(t *testing.T) {
+	_, _, guest, hostConn, _ := startTestGame(t)
+
+	handleRematchDecline(guest)
+	handleDisconnect(guest)
+
+	types := hostConn.types()
+	if slices.Contains(types, MsgRematchDecline) {
+		t.Errorf("a decline before game over was relayed: %v", types)
+	}
+	if !slices.Contains(types, MsgOpponentLeft) {
+		t.Errorf("host was not told the opponent left mid-game: %v", types)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// roomCodes returns the codes of all open rooms.
+func roomCodes() []string {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	codes := make([]string, 0, len(server.rooms))
+	for code := range server.rooms {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestJoinWhileCreating(t *testing.T) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, code := range roomCodes() {
+					guest, _ := newTestClient("guest")
+					handleJoinRoom(guest, code)
+				}
+			}
+		}()
+	}
+
+	var hosts []*fakeConn
+	for i := 0; i < 200; i++ {
+		host, conn := newTestClient("host")
+		handleCreateRoom(host, false)
+		hosts = append(hosts, conn)
+	}
+	close(done)
+	wg.Wait()
+
+	for _, conn := range hosts {
+		types := conn.types()
+		if slices.Contains(types, MsgPlayerJoined) && types[0] != MsgRoomCreated {
+			t.Fatalf("a guest joined before the host was told the room was created: %v", types)
+		}
+	}
+}
+
+// relayTestMessage relays a message of type msgType with payload from sender.
+func relayTestMessage(sender *Client, msgType string, payload any) {
+	data, _ := json.Marshal(payload)
+	relayMessage(sender, Message{Type: msgType, Payload: data})
+}
+
+// TestConcurrentRoomTraffic joins, plays in and leaves the same rooms from
+// many goroutines at once; run it with -race.
+func TestConcurrentRoomTraffic(t *testing.T) {
+	var rooms []*Room
+	var hosts []*Client
+	for i := 0; i < 20; i++ {
+		host, hostConn := newTestClient("host")
+		room := handleCreateRoom(host, false)
+		if room == nil {
+			t.Fatalf("failed to create room: %v", hostConn.types())
+		}
+		rooms = append(rooms, room)
+		hosts = append(hosts, host)
+	}
+
+	// play sends a game's worth of messages, answering shots at random
+	play := func(c *Client) {
+		relayTestMessage(c, MsgShipsPlaced, ShipsPlacedPayload{Fleet: []ShipPlacement{{Name: "Destroyer", Positions: [][2]int{{0, 0}, {0, 1}}}}})
+		for shot := 0; shot < 5; shot++ {
+			relayTestMessage(c, MsgAttack, AttackPayload{Row: shot, Col: shot})
+			relayTestMessage(c, MsgAttackResult, AttackResultPayload{Row: shot, Col: shot, Hit: shot%2 == 0})
+		}
+		relayTestMessage(c, MsgGameOver, GameOverPayload{YouWon: true})
+	}
+
+	var mu sync.Mutex
+	var clients []*Client
+	var wg sync.WaitGroup
+	for i, room := range rooms {
+		for j := 0; j < 3; j++ {
+			wg.Add(1)
+			go func(code string) {
+				defer wg.Done()
+				guest, _ := newTestClient("guest")
+				mu.Lock()
+				clients = append(clients, guest)
+				mu.Unlock()
+				handleJoinRoom(guest, code)
+				play(guest)
+				handleDisconnect(guest)
+			}(room.Code)
+		}
+		wg.Add(1)
+		go func(host *Client) {
+			defer wg.Done()
+			play(host)
+			handleDisconnect(host)
+		}(hosts[i])
+	}
+	wg.Wait()
+
+	open := roomCodes()
+	for _, room := range rooms {
+		room.mu.Lock()
+		closed := room.closed
+		room.mu.Unlock()
+		if !closed || slices.Contains(open, room.Code) {
+			t.Errorf("room %s is still open after everyone left", room.Code)
+		}
+	}
+	for _, c := range append(clients, hosts...) {
+		if room := c.Room(); room != nil {
+			room.mu.Lock()
+			closed := room.closed
+			room.mu.Unlock()
+			if !closed {
+				t.Errorf("a client that left is still in open room %s", room.Code)
+			}
+		}
+	}
+}
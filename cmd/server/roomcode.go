@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"strings"
+)
+
+const (
+	// defaultRoomCodeAlphabet leaves out I and O, which are easily mistaken
+	// for 1 and 0.
+	defaultRoomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	// defaultRoomCodeLength is the length of codes for public rooms.
+	defaultRoomCodeLength = 4
+	// defaultPrivateRoomCodeLength is the length of codes for private rooms,
+	// long enough that they can't be guessed.
+	defaultPrivateRoomCodeLength = 10
+	// maxRoomCodeAttempts is how many codes are tried before giving up on
+	// finding one that is free.
+	maxRoomCodeAttempts = 100
+)
+
+// defaultRoomCodeBlocklist holds words that must not appear in room codes.
+var defaultRoomCodeBlocklist = []string{
+	"ANAL", "ANUS", "ARSE", "ASS", "BUTT", "COCK", "COON", "CRAP", "CUM",
+	"CUNT", "DAMN", "DICK", "DYKE", "FAG", "FUCK", "FUK", "GOOK", "HELL",
+	"JIZ", "KKK", "KIKE", "NAZI", "NIG", "PAKI", "PISS", "PORN", "POO",
+	"PUSS", "RAPE", "SEX", "SHAG", "SHIT", "SLUT", "SPIC", "TIT", "TWAT",
+	"WANK", "WHORE", "XXX",
+}
+
+// errNoRoomCodes is returned when no free code could be found.
+var errNoRoomCodes = errors.New("no free room codes")
+
+// CreateRoomPayload is the request payload for creating a room.
+type CreateRoomPayload struct {
+	// Private rooms get a long random code that can't be guessed.
+	Private bool `json:"private"`
+}
+
+// allocateRoom registers room under a new code that is not in use on any
+// instance and contains no blocked words.
+func allocateRoom(room *Room, private bool) error {
+	length := server.config.RoomCodeLength
+	if private {
+		length = server.config.PrivateRoomCodeLength
+	}
+
+	for attempt := 0; attempt < maxRoomCodeAttempts; attempt++ {
+		if roomsFull() {
+			return errTooManyRooms
+		}
+		code, err := generateRoomCode(server.config.RoomCodeAlphabet, length)
+		if err != nil {
+			return err
+		}
+		if localRoom(code) || blockedRoomCode(code) {
+			continue
+		}
+		// Claiming may wait for the broker, so server.mu is not held for it
+		if err := server.cluster.ClaimRoom(code, server.instance); errors.Is(err, errRoomCodeTaken) {
+			continue // In use on another instance
+		} else if err != nil {
+			return fmt.Errorf("failed to claim room code: %w", err)
+		}
+
+		server.mu.Lock()
+		_, taken := server.rooms[code] // By another room created meanwhile
+		full := server.config.MaxRooms > 0 && len(server.rooms) >= server.config.MaxRooms
+		if !taken && !full {
+			room.Code = code
+			server.rooms[code] = room
+		}
+		server.mu.Unlock()
+
+		if taken {
+			continue
+		}
+		if full {
+			if err := server.cluster.ReleaseRoom(code, server.instance); err != nil {
+				logError(slog.Default(), errorCluster, "Failed to release room", err, "code", code)
+			}
+			return errTooManyRooms
+		}
+		return nil
+	}
+	return errNoRoomCodes
+}
+
+// roomsFull reports whether the server has as many rooms open as it may.
+func roomsFull() bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.config.MaxRooms > 0 && len(server.rooms) >= server.config.MaxRooms
+}
+
+// localRoom reports whether a room on this instance has code.
+func localRoom(code string) bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	_, ok := server.rooms[code]
+	return ok
+}
+
+// generateRoomCode returns length characters drawn from alphabet using a
+// cryptographic random source, so codes can't be predicted.
+func generateRoomCode(alphabet string, length int) (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate room code: %w", err)
+		}
+		b[i] = alphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// blockedRoomCode reports whether code contains a blocked word.
+func blockedRoomCode(code string) bool {
+	for _, word := range server.roomCodeBlocklist {
+		if strings.Contains(code, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAlphabet uppercases alphabet and drops repeated characters. Room
+// codes are matched case-insensitively, so the alphabet must be too.
+func normalizeAlphabet(alphabet string) (string, error) {
+	var sb strings.Builder
+	seen := make(map[rune]bool)
+	for _, r := range strings.ToUpper(alphabet) {
+		if r > 127 || r <= ' ' {
+			return "", fmt.Errorf("invalid room code alphabet: %q is not a printable ASCII character", r)
+		}
+		if !seen[r] {
+			seen[r] = true
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() < 2 {
+		return "", errors.New("invalid room code alphabet: need at least 2 distinct characters")
+	}
+	return sb.String(), nil
+}
+
+// LoadRoomCodeBlocklist reads blocked words from path, one per line, in
+// addition to the built-in list. Blank lines and lines starting with # are
+// ignored.
+func LoadRoomCodeBlocklist(path string) ([]string, error) {
+	words := append([]string(nil), defaultRoomCodeBlocklist...)
+	if path == "" {
+		return words, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room code blocklist: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if word != "" && !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load room code blocklist: %w", err)
+	}
+	return words, nil
+}
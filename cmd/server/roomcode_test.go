@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingCluster is a Cluster whose claims wait until released, like
+// claims to a slow broker.
+type blockingCluster struct {
+	Cluster
+	claiming chan string
+	proceed  chan struct{}
+}
+
+func (c *blockingCluster) ClaimRoom(code, instance string) error {
+	c.claiming <- code
+	<-c.proceed
+	return c.Cluster.ClaimRoom(code, instance)
+}
+
+func TestAllocateRoomClaimsWithoutLock(t *testing.T) {
+	cluster := &blockingCluster{Cluster: server.cluster, claiming: make(chan string), proceed: make(chan struct{})}
+	server.cluster = cluster
+	defer func() { server.cluster = cluster.Cluster }()
+
+	host, _ := newTestClient("host")
+	created := make(chan *Room)
+	go func() { created <- handleCreateRoom(host, false) }()
+	code := <-cluster.claiming
+
+	// Other rooms stay reachable while the claim is waiting
+	looked := make(chan struct{})
+	go func() {
+		roomCodes()
+		close(looked)
+	}()
+	select {
+	case <-looked:
+	case <-time.After(time.Second):
+		t.Fatal("server.mu is held while claiming a room code")
+	}
+
+	close(cluster.proceed)
+	room := <-created
+	if room == nil || room.Code != code {
+		t.Fatalf("room was not created under the claimed code %s", code)
+	}
+	if !localRoom(code) {
+		t.Errorf("room %s was not registered", code)
+	}
+}
+
+func TestAllocateRoomRespectsMaxRooms(t *testing.T) {
+	first, _ := newTestClient("first")
+	if handleCreateRoom(first, false) == nil {
+		t.Fatal("failed to create room")
+	}
+	server.config.MaxRooms = len(roomCodes())
+	defer func() { server.config.MaxRooms = 0 }()
+
+	host, conn := newTestClient("host")
+	if room := handleCreateRoom(host, false); room != nil {
+		t.Errorf("room %s was created past the limit of %d", room.Code, server.config.MaxRooms)
+	}
+	if types := conn.types(); len(types) != 1 || types[0] != MsgJoinError {
+		t.Errorf("host got %v, want an error", types)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestMain sets up the server as main does, with state kept in memory and
+// no clocks, so tests can drive the handlers directly.
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	server.store = NewMemoryStore()
+	server.ratings = NewRatings(server.store)
+	server.instance = "test"
+	server.cluster = NewLocalCluster()
+	server.config.RoomCodeAlphabet = defaultRoomCodeAlphabet
+	server.config.RoomCodeLength = defaultRoomCodeLength
+	server.config.PrivateRoomCodeLength = defaultPrivateRoomCodeLength
+	server.roomCodeBlocklist = defaultRoomCodeBlocklist
+	server.config.SendQueueSize = 256
+	if err := server.cluster.Subscribe(server.instance, handleEnvelope); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeConn records the messages written to a client.
+type fakeConn struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func (c *fakeConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+// types returns the types of the messages written so far, in order.
+func (c *fakeConn) types() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	types := make([]string, len(c.msgs))
+	for i, msg := range c.msgs {
+		types[i] = msg.Type
+	}
+	return types
+}
+
+// newTestClient returns a client identified as player id, whose messages
+// are recorded by the returned conn.
+func newTestClient(id string) (*Client, *fakeConn) {
+	conn := &fakeConn{}
+	client := &Client{conn: conn, id: newClientID(), addr: "test"}
+	client.setPlayer(PlayerInfo{ID: id, Name: id})
+	return client, conn
+}
+
+// startTestGame opens a room hosted by one new client and joined by another.
+func startTestGame(t *testing.T) (room *Room, host, guest *Client, hostConn, guestConn *fakeConn) {
+	t.Helper()
+	host, hostConn = newTestClient("host")
+	guest, guestConn = newTestClient("guest")
+	room = handleCreateRoom(host, false)
+	if room == nil {
+		t.Fatalf("failed to create room: %v", hostConn.types())
+	}
+	handleJoinRoom(guest, room.Code)
+	if guest.Room() != room {
+		t.Fatalf("guest failed to join room: %v", guestConn.types())
+	}
+	return room, host, guest, hostConn, guestConn
+}
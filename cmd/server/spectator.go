@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Player roles as seen by spectators
+const (
+	RoleHost  = "host"
+	RoleGuest = "guest"
+)
+
+// ShipPlacement is the position of one ship in a fleet.
+type ShipPlacement struct {
+	Name      string   `json:"name"`
+	Positions [][2]int `json:"positions"`
+}
+
+// ShipsPlacedPayload is sent by a player when their fleet is placed. The
+// fleet is kept by the server for spectators and stripped before relaying.
+type ShipsPlacedPayload struct {
+	Fleet []ShipPlacement `json:"fleet,omitempty"`
+}
+
+// AttackResultPayload is the defender's answer to an attack.
+type AttackResultPayload struct {
+	Row          int    `json:"row"`
+	Col          int    `json:"col"`
+	Hit          bool   `json:"hit"`
+	SunkShipName string `json:"sunk_ship_name,omitempty"`
+}
+
+// SpectatorShotPayload describes a resolved shot to spectators.
+type SpectatorShotPayload struct {
+	Shooter      string `json:"shooter"`
+	Row          int    `json:"row"`
+	Col          int    `json:"col"`
+	Hit          bool   `json:"hit"`
+	SunkShipName string `json:"sunk_ship_name,omitempty"`
+}
+
+// FleetRevealPayload reveals both fleets to spectators.
+type FleetRevealPayload struct {
+	Host  []ShipPlacement `json:"host"`
+	Guest []ShipPlacement `json:"guest"`
+}
+
+// SpectatorCountPayload announces how many spectators are watching a room.
+type SpectatorCountPayload struct {
+	Count int `json:"count"`
+}
+
+// SpectateStatePayload is the full state of a room sent to spectators when
+// they join and whenever a player arrives.
+type SpectateStatePayload struct {
+	Code       string                 `json:"code"`
+	HostName   string                 `json:"host_name"`
+	GuestName  string                 `json:"guest_name"`
+	Spectators int                    `json:"spectators"`
+	Shots      []SpectatorShotPayload `json:"shots"`
+	HostFleet  []ShipPlacement        `json:"host_fleet,omitempty"`
+	GuestFleet []ShipPlacement        `json:"guest_fleet,omitempty"`
+	Winner     string                 `json:"winner,omitempty"`
+}
+
+// SpectateGameOverPayload announces the winner to spectators.
+type SpectateGameOverPayload struct {
+	Winner string `json:"winner"`
+}
+
+func handleSpectateRoom(client *Client, code string) {
+	code = strings.ToUpper(code)
+	server.mu.Lock()
+	room, exists := server.rooms[code]
+	server.mu.Unlock()
+
+	if !exists {
+		sendError(client, "Room not found")
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.closed || room.reserved != nil {
+		sendError(client, "Room not found")
+		return
+	}
+
+	room.Spectators = append(room.Spectators, client)
+	client.setRoom(room)
+	client.setSpectator(true)
+
+	sendJSON(client, MsgSpectateState, spectateState(room))
+	broadcastSpectatorCount(room)
+
+	logRoomEvent(room, client, EventSpectating, "spectators", len(room.Spectators))
+}
+
+// spectateState builds the full spectator view of room.
+// Must be called with room.mu held.
+func spectateState(room *Room) SpectateStatePayload {
+	state := SpectateStatePayload{
+		Code:       room.Code,
+		Spectators: len(room.Spectators),
+		Shots:      room.shots,
+		Winner:     room.winner,
+	}
+	if room.Host != nil {
+		state.HostName = room.Host.Player().Name
+	}
+	if room.Guest != nil {
+		state.GuestName = room.Guest.Player().Name
+	}
+	if room.fleetsRevealed {
+		state.HostFleet = room.hostFleet
+		state.GuestFleet = room.guestFleet
+	}
+	return state
+}
+
+// broadcastSpectatorState refreshes every spectator's view of room.
+// Must be called with room.mu held.
+func broadcastSpectatorState(room *Room) {
+	if len(room.Spectators) == 0 {
+		return
+	}
+	state := spectateState(room)
+	for _, s := range room.Spectators {
+		sendJSON(s, MsgSpectateState, state)
+	}
+}
+
+// broadcastSpectatorCount tells players and spectators how many are watching.
+// Must be called with room.mu held.
+func broadcastSpectatorCount(room *Room) {
+	payload := SpectatorCountPayload{Count: len(room.Spectators)}
+	for _, c := range roomMembers(room) {
+		sendJSON(c, MsgSpectatorCount, payload)
+	}
+}
+
+// recordFleet stores a player's fleet for spectators and strips it from the
+// message before it is relayed to the opponent.
+// Must be called with room.mu held.
+func recordFleet(room *Room, sender *Client, msg Message) Message {
+	var payload ShipsPlacedPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err == nil {
+		if sender == room.Host {
+			room.hostFleet = payload.Fleet
+		} else {
+			room.guestFleet = payload.Fleet
+		}
+	}
+
+	if room.hostFleet != nil && room.guestFleet != nil && server.config.SpectatorRevealDelay > 0 {
+		game := room.gamesPlayed
+		time.AfterFunc(server.config.SpectatorRevealDelay, func() {
+			room.mu.Lock()
+			defer room.mu.Unlock()
+			if room.gamesPlayed == game { // Not a later game of the series
+				revealFleets(room)
+			}
+		})
+	}
+
+	return Message{Type: msg.Type, Payload: []byte("{}")}
+}
+
+// recordShot forwards a resolved shot to spectators. The attack result is
+// sent by the defender, so the shooter is the other player.
+// Must be called with room.mu held.
+func recordShot(room *Room, defender *Client, msg Message) {
+	var result AttackResultPayload
+	if err := json.Unmarshal(msg.Payload, &result); err != nil {
+		return
+	}
+
+	shooter := RoleHost
+	if defender == room.Host {
+		shooter = RoleGuest
+	}
+	shot := SpectatorShotPayload{
+		Shooter:      shooter,
+		Row:          result.Row,
+		Col:          result.Col,
+		Hit:          result.Hit,
+		SunkShipName: result.SunkShipName,
+	}
+	room.shots = append(room.shots, shot)
+
+	for _, s := range room.Spectators {
+		sendJSON(s, MsgSpectatorShot, shot)
+	}
+}
+
+// revealFleets shows both fleets to spectators, once.
+// Must be called with room.mu held.
+func revealFleets(room *Room) {
+	if room.fleetsRevealed {
+		return
+	}
+	room.fleetsRevealed = true
+
+	payload := FleetRevealPayload{Host: room.hostFleet, Guest: room.guestFleet}
+	for _, s := range room.Spectators {
+		sendJSON(s, MsgFleetReveal, payload)
+	}
+}
+
+// removeSpectator detaches a spectator from their room.
+// Must be called with room.mu held.
+func removeSpectator(room *Room, client *Client) {
+	for i, s := range room.Spectators {
+		if s == client {
+			room.Spectators = append(room.Spectators[:i], room.Spectators[i+1:]...)
+			break
+		}
+	}
+	client.setRoom(nil)
+	broadcastSpectatorCount(room)
+}
+
+// roomMembers returns the players and spectators currently in room.
+// Must be called with room.mu held.
+func roomMembers(room *Room) []*Client {
+	members := make([]*Client, 0, 2+len(room.Spectators))
+	if room.Host != nil {
+		members = append(members, room.Host)
+	}
+	if room.Guest != nil {
+		members = append(members, room.Guest)
+	}
+	return append(members, room.Spectators...)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists server state so a restart or redeploy does not wipe it.
+type Store interface {
+	// Player returns the stored record for a player, if any.
+	Player(id string) (PlayerRecord, bool, error)
+	// Players returns every stored player.
+	Players() ([]PlayerRecord, error)
+	// SavePlayer creates or replaces a player record.
+	SavePlayer(p PlayerRecord) error
+
+	// RecordGame saves the updated records of a game's winner and loser and
+	// appends the game, all or nothing.
+	RecordGame(winner, loser PlayerRecord, g GameRecord) error
+	// Games returns up to limit finished games, most recent first.
+	Games(limit int) ([]GameRecord, error)
+
+	// SaveRoom creates or replaces the snapshot of an in-flight room.
+	SaveRoom(r RoomSnapshot) error
+	// DeleteRoom removes a room snapshot once the room is torn down.
+	DeleteRoom(code string) error
+	// Rooms returns all in-flight room snapshots.
+	Rooms() ([]RoomSnapshot, error)
+
+	Close() error
+}
+
+// PlayerRecord is the persisted state of a player.
+type PlayerRecord struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Rating    float64   `json:"rating"`
+	Wins      int       `json:"wins"`
+	Losses    int       `json:"losses"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// SecretHash is the SHA-256 of the secret issued for ID, which hello
+	// must present.
+	SecretHash string `json:"secret_hash,omitempty"`
+}
+
+// GameRecord is the persisted result of a finished multiplayer game.
+type GameRecord struct {
+	RoomCode   string    `json:"room_code"`
+	WinnerID   string    `json:"winner_id"`
+	LoserID    string    `json:"loser_id"`
+	Delta      float64   `json:"delta"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// RoomSnapshot is the persisted state of a room that has not been torn down.
+type RoomSnapshot struct {
+	Code      string     `json:"code"`
+	Host      PlayerInfo `json:"host"`
+	Guest     PlayerInfo `json:"guest"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// memoryStore is a Store that keeps everything in process memory.
+// It is used when no database path is configured and in tests.
+type memoryStore struct {
+	players map[string]PlayerRecord
+	games   []GameRecord
+	rooms   map[string]RoomSnapshot
+	mu      sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		players: make(map[string]PlayerRecord),
+		rooms:   make(map[string]RoomSnapshot),
+	}
+}
+
+func (s *memoryStore) Player(id string) (PlayerRecord, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.players[id]
+	return p, ok, nil
+}
+
+func (s *memoryStore) Players() ([]PlayerRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	players := make([]PlayerRecord, 0, len(s.players))
+	for _, p := range s.players {
+		players = append(players, p)
+	}
+	return players, nil
+}
+
+func (s *memoryStore) SavePlayer(p PlayerRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.players[p.ID] = p
+	return nil
+}
+
+func (s *memoryStore) RecordGame(winner, loser PlayerRecord, g GameRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.players[winner.ID] = winner
+	s.players[loser.ID] = loser
+	s.games = append(s.games, g)
+	return nil
+}
+
+func (s *memoryStore) Games(limit int) ([]GameRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	games := make([]GameRecord, 0, len(s.games))
+	for i := len(s.games) - 1; i >= 0; i-- {
+		if limit > 0 && len(games) == limit {
+			break
+		}
+		games = append(games, s.games[i])
+	}
+	return games, nil
+}
+
+func (s *memoryStore) SaveRoom(r RoomSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rooms[r.Code] = r
+	return nil
+}
+
+func (s *memoryStore) DeleteRoom(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rooms, code)
+	return nil
+}
+
+func (s *memoryStore) Rooms() ([]RoomSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rooms := make([]RoomSnapshot, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].CreatedAt.Before(rooms[j].CreatedAt) })
+	return rooms, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	playersBucket = []byte("players")
+	gamesBucket   = []byte("games")
+	roomsBucket   = []byte("rooms")
+)
+
+// boltStore is a Store backed by a single BoltDB file.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens (creating if needed) the database file at path.
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{playersBucket, gamesBucket, roomsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store: %w", err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Player(id string) (PlayerRecord, bool, error) {
+	var p PlayerRecord
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(playersBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &p)
+	})
+	if err != nil {
+		return PlayerRecord{}, false, fmt.Errorf("failed to read player: %w", err)
+	}
+	return p, found, nil
+}
+
+func (s *boltStore) Players() ([]PlayerRecord, error) {
+	var players []PlayerRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(playersBucket).ForEach(func(_, v []byte) error {
+			var p PlayerRecord
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			players = append(players, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read players: %w", err)
+	}
+	return players, nil
+}
+
+func (s *boltStore) SavePlayer(p PlayerRecord) error {
+	if err := s.put(playersBucket, []byte(p.ID), p); err != nil {
+		return fmt.Errorf("failed to save player: %w", err)
+	}
+	return nil
+}
+
+func (s *boltStore) RecordGame(winner, loser PlayerRecord, g GameRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		players := tx.Bucket(playersBucket)
+		for _, p := range []PlayerRecord{winner, loser} {
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := players.Put([]byte(p.ID), data); err != nil {
+				return err
+			}
+		}
+
+		games := tx.Bucket(gamesBucket)
+		seq, err := games.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(g)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return games.Put(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save game: %w", err)
+	}
+	return nil
+}
+
+func (s *boltStore) Games(limit int) ([]GameRecord, error) {
+	var games []GameRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(gamesBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(games) == limit {
+				break
+			}
+			var g GameRecord
+			if err := json.Unmarshal(v, &g); err != nil {
+				return err
+			}
+			games = append(games, g)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read games: %w", err)
+	}
+	return games, nil
+}
+
+func (s *boltStore) SaveRoom(r RoomSnapshot) error {
+	if err := s.put(roomsBucket, []byte(r.Code), r); err != nil {
+		return fmt.Errorf("failed to save room: %w", err)
+	}
+	return nil
+}
+
+func (s *boltStore) DeleteRoom(code string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).Delete([]byte(code))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+	return nil
+}
+
+func (s *boltStore) Rooms() ([]RoomSnapshot, error) {
+	var rooms []RoomSnapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).ForEach(func(_, v []byte) error {
+			var r RoomSnapshot
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			rooms = append(rooms, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rooms: %w", err)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].CreatedAt.Before(rooms[j].CreatedAt) })
+	return rooms, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// put JSON-encodes v and stores it under key in bucket.
+func (s *boltStore) put(bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, data)
+	})
+}
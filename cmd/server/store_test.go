@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// eachStore runs test against a fresh memoryStore and a fresh boltStore.
+func eachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		store, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		test(t, store)
+	})
+}
+
+func TestStorePlayers(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		if _, found, err := store.Player("alice"); err != nil || found {
+			t.Fatalf("Player(alice) before save = found %v, err %v", found, err)
+		}
+
+		alice := PlayerRecord{ID: "alice", Name: "Alice", Rating: 1200}
+		if err := store.SavePlayer(alice); err != nil {
+			t.Fatal(err)
+		}
+		alice.Name = "Alice B"
+		if err := store.SavePlayer(alice); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SavePlayer(PlayerRecord{ID: "bob", Name: "Bob", Rating: 1200}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, found, err := store.Player("alice")
+		if err != nil || !found {
+			t.Fatalf("Player(alice) = found %v, err %v", found, err)
+		}
+		if got.Name != "Alice B" {
+			t.Errorf("Player(alice).Name = %q, want %q", got.Name, "Alice B")
+		}
+
+		players, err := store.Players()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(players) != 2 {
+			t.Errorf("Players() returned %d players, want 2", len(players))
+		}
+	})
+}
+
+func TestStoreRecordGame(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		for i, code := range []string{"AAAA", "BBBB", "CCCC"} {
+			winner := PlayerRecord{ID: "alice", Rating: 1200 + float64(i), Wins: i + 1}
+			loser := PlayerRecord{ID: "bob", Rating: 1200 - float64(i), Losses: i + 1}
+			game := GameRecord{RoomCode: code, WinnerID: "alice", LoserID: "bob", Delta: 1}
+			if err := store.RecordGame(winner, loser, game); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		alice, _, _ := store.Player("alice")
+		bob, _, _ := store.Player("bob")
+		if alice.Wins != 3 || bob.Losses != 3 {
+			t.Errorf("after three games alice has %d wins and bob %d losses, want 3 and 3", alice.Wins, bob.Losses)
+		}
+
+		games, err := store.Games(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(games) != 2 || games[0].RoomCode != "CCCC" || games[1].RoomCode != "BBBB" {
+			t.Errorf("Games(2) = %+v, want CCCC then BBBB", games)
+		}
+		if all, _ := store.Games(0); len(all) != 3 {
+			t.Errorf("Games(0) returned %d games, want 3", len(all))
+		}
+	})
+}
+
+func TestStoreRooms(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		now := time.Now()
+		rooms := []RoomSnapshot{
+			{Code: "LATE", Host: PlayerInfo{ID: "alice"}, CreatedAt: now},
+			{Code: "EARL", Host: PlayerInfo{ID: "bob"}, CreatedAt: now.Add(-time.Minute)},
+			{Code: "GONE", Host: PlayerInfo{ID: "carol"}, CreatedAt: now.Add(-time.Hour)},
+		}
+		for _, r := range rooms {
+			if err := store.SaveRoom(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.DeleteRoom("GONE"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteRoom("NONE"); err != nil {
+			t.Errorf("DeleteRoom of a missing room: %v", err)
+		}
+
+		got, err := store.Rooms()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Code != "EARL" || got[1].Code != "LATE" {
+			t.Errorf("Rooms() = %+v, want EARL then LATE", got)
+		}
+	})
+}
+
+func TestRecordResult(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		ratings := NewRatings(store)
+		alice := PlayerInfo{ID: "alice", Name: "Alice"}
+		bob := PlayerInfo{ID: "bob", Name: "Bob"}
+
+		record, err := ratings.RecordResult("ABCD", alice, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Delta <= 0 {
+			t.Errorf("winner's delta = %v, want > 0", record.Delta)
+		}
+
+		w, _, _ := store.Player("alice")
+		l, _, _ := store.Player("bob")
+		if w.Wins != 1 || l.Losses != 1 {
+			t.Errorf("alice has %d wins and bob %d losses, want 1 and 1", w.Wins, l.Losses)
+		}
+		if math.Abs(w.Rating+l.Rating-2*initialRating) > 1e-9 {
+			t.Errorf("ratings %v and %v don't sum to %v", w.Rating, l.Rating, 2*initialRating)
+		}
+
+		games, _ := store.Games(0)
+		if len(games) != 1 || games[0].WinnerID != "alice" || games[0].LoserID != "bob" {
+			t.Errorf("Games(0) = %+v, want one game alice beat bob", games)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	bnet "battle-ship/net"
+
+	"github.com/gorilla/websocket"
+)
+
+// clientTransport carries a remote client's messages. The connection's read
+// loop reads from it and its writer goroutine writes to it.
+type clientTransport interface {
+	// ReadMessage reads the next message from the client.
+	ReadMessage(msg *Message) error
+	// WriteMessage writes an encoded message to the client.
+	WriteMessage(data []byte) error
+	// CloseWith tells the client, if the transport can, why it is being
+	// disconnected, and closes the connection.
+	CloseWith(code int, reason string)
+	Close() error
+}
+
+// wsTransport is a WebSocket, carrying one message per frame: JSON in text
+// frames, or MessagePack in binary frames. Clients that negotiated
+// bnet.MsgPackSubprotocol are written to in MessagePack.
+type wsTransport struct {
+	ws      *websocket.Conn
+	msgpack bool
+}
+
+func newWSTransport(ws *websocket.Conn) *wsTransport {
+	return &wsTransport{ws: ws, msgpack: ws.Subprotocol() == bnet.MsgPackSubprotocol}
+}
+
+func (t *wsTransport) ReadMessage(msg *Message) error {
+	frameType, data, err := t.ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if frameType == websocket.BinaryMessage {
+		if data, err = bnet.MsgPackToJSON(data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, msg)
+}
+
+func (t *wsTransport) WriteMessage(data []byte) error {
+	frameType := websocket.TextMessage
+	if t.msgpack {
+		var err error
+		if data, err = bnet.JSONToMsgPack(data); err != nil {
+			return err
+		}
+		frameType = websocket.BinaryMessage
+	}
+	t.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.ws.WriteMessage(frameType, data)
+}
+
+func (t *wsTransport) CloseWith(code int, reason string) {
+	closeWebSocket(t.ws, code, reason)
+}
+
+func (t *wsTransport) Close() error {
+	return t.ws.Close()
+}
+
+// tcpTransport is a raw TCP connection carrying one JSON message per line,
+// or MessagePack maps one after another if that is what the client's first
+// message is. TCP has no close frames, so clients are disconnected without a
+// reason.
+type tcpTransport struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	maxSize int
+
+	detect     sync.Once
+	negotiated chan struct{} // Closed once msgpack is known
+	msgpack    bool
+}
+
+// newTCPTransport wraps conn, failing reads of messages longer than maxSize.
+func newTCPTransport(conn net.Conn, maxSize int) *tcpTransport {
+	return &tcpTransport{
+		conn:       conn,
+		reader:     bufio.NewReaderSize(conn, maxSize),
+		maxSize:    maxSize,
+		negotiated: make(chan struct{}),
+	}
+}
+
+func (t *tcpTransport) ReadMessage(msg *Message) error {
+	t.detect.Do(func() {
+		defer close(t.negotiated)
+		if first, err := t.reader.Peek(1); err == nil {
+			t.msgpack = bnet.IsMsgPackMap(first[0])
+		}
+	})
+
+	var data []byte
+	var err error
+	if t.msgpack {
+		data, err = bnet.ReadMsgPack(t.reader, t.maxSize)
+	} else {
+		data, err = bnet.ReadLine(t.reader)
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return fmt.Errorf("failed to decode message: %w", err)
+	}
+	return nil
+}
+
+// WriteMessage waits for the client's first message, which tells the
+// encoding to answer in. Only broadcasts reach clients that haven't sent
+// anything yet, and they wait at most until the client speaks or
+// disconnects.
+func (t *tcpTransport) WriteMessage(data []byte) error {
+	<-t.negotiated
+	if t.msgpack {
+		var err error
+		if data, err = bnet.JSONToMsgPack(data); err != nil {
+			return err
+		}
+	} else {
+		data = append(data, '\n')
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err := t.conn.Write(data)
+	return err
+}
+
+func (t *tcpTransport) CloseWith(code int, reason string) {
+	t.conn.Close()
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// closedByClient reports whether err ends a connection the client closed
+// on purpose.
+func closedByClient(err error) bool {
+	return errors.Is(err, io.EOF) || errors.As(err, new(*websocket.CloseError))
+}
+
+// serveTCP serves clients connecting to ln over raw TCP until ln is closed.
+func serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logError(slog.Default(), errorWebSocket, "Failed to accept TCP connection", err)
+			time.Sleep(100 * time.Millisecond) // Don't spin if we're out of file descriptors
+			continue
+		}
+		go serveClient(newTCPTransport(conn, int(server.config.MaxMessageSize)), conn.RemoteAddr().String())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"battle-ship/game"
+)
+
+// relayValidators lists the messages players may relay to their opponent,
+// each with a check of its payload. Anything else is rejected.
+var relayValidators = map[string]func(payload json.RawMessage) error{
+	MsgShipsPlaced: func(payload json.RawMessage) error {
+		var p ShipsPlacedPayload
+		if err := decodeStrict(payload, &p); err != nil {
+			return err
+		}
+		if len(p.Fleet) == 0 {
+			return nil // Older clients don't share their fleet
+		}
+		_, err := buildBoard(p.Fleet)
+		return err
+	},
+	MsgAttack: func(payload json.RawMessage) error {
+		var p AttackPayload
+		if err := decodeStrict(payload, &p); err != nil {
+			return err
+		}
+		return validateCell(p.Row, p.Col)
+	},
+	MsgAttackResult: func(payload json.RawMessage) error {
+		var p AttackResultPayload
+		if err := decodeStrict(payload, &p); err != nil {
+			return err
+		}
+		if p.SunkShipName != "" && !isShipName(p.SunkShipName) {
+			return fmt.Errorf("unknown ship %q", p.SunkShipName)
+		}
+		return validateCell(p.Row, p.Col)
+	},
+	MsgGameOver: func(payload json.RawMessage) error {
+		var p GameOverPayload
+		return decodeStrict(payload, &p)
+	},
+}
+
+// validateRelay checks that msg may be relayed to an opponent, returning
+// the reason it is rejected otherwise.
+func validateRelay(msg Message) (reason string, err error) {
+	validate, ok := relayValidators[msg.Type]
+	if !ok {
+		return RejectUnknownType, fmt.Errorf("message type %q can't be relayed", msg.Type)
+	}
+	if err := validate(msg.Payload); err != nil {
+		return RejectInvalidPayload, err
+	}
+	return "", nil
+}
+
+// decodeStrict decodes a JSON object payload into v, rejecting unknown fields.
+func decodeStrict(payload json.RawMessage, v any) error {
+	if len(payload) == 0 {
+		return errors.New("missing payload")
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// validateCell checks that row and col are on the board.
+func validateCell(row, col int) error {
+	if row < 0 || row >= game.BoardSize || col < 0 || col >= game.BoardSize {
+		return fmt.Errorf("cell (%d, %d) is off the board", row, col)
+	}
+	return nil
+}
+
+// isShipName reports whether name is one of the standard ships.
+func isShipName(name string) bool {
+	for _, ship := range game.ShipDefinitions() {
+		if ship.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles is the browser client, which speaks the same protocol as the
+// terminal client over /ws.
+//
+//go:embed web
+var webFiles embed.FS
+
+// webHandler serves the browser client.
+func webHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // The directory is embedded above
+	}
+	return http.FileServer(http.FS(files))
+}
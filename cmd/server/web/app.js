@@ -0,0 +1,520 @@
+// Browser client for the Battleship server. It speaks the same protocol as
+// the terminal client (net/network.go) over the server's /ws endpoint, so
+// browser and terminal players can play each other.
+"use strict";
+
+const BOARD_SIZE = 10;
+const SHIPS = [
+  { name: "Carrier", length: 5 },
+  { name: "Battleship", length: 4 },
+  { name: "Cruiser", length: 3 },
+  { name: "Submarine", length: 3 },
+  { name: "Destroyer", length: 2 },
+];
+
+const $ = (id) => document.getElementById(id);
+
+// Every browser keeps one identity, like the terminal client's identity.json,
+// with the secret this server issued for it
+function loadIdentity() {
+  let identity = null;
+  try {
+    identity = JSON.parse(localStorage.getItem("battleship.identity"));
+  } catch (e) {
+    // Start over with a new identity
+  }
+  if (!identity || !identity.id) {
+    const bytes = crypto.getRandomValues(new Uint8Array(16));
+    const id = Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+    identity = { id, name: "" };
+  }
+  return identity;
+}
+
+function saveIdentity() {
+  localStorage.setItem("battleship.identity", JSON.stringify(state.identity));
+}
+
+function emptyGrid() {
+  return Array.from({ length: BOARD_SIZE }, () => Array(BOARD_SIZE).fill(""));
+}
+
+const state = {
+  identity: loadIdentity(),
+  ws: null,
+  isHost: false,
+  roomCode: "",
+  phase: "menu", // menu, waiting, placement, ready, battle, over
+  own: emptyGrid(), // "", ship, hit, miss
+  enemy: emptyGrid(), // "", hit, miss
+  fleet: [], // { name, positions, hits }
+  horizontal: true,
+  shipsPlaced: false,
+  opponentReady: false,
+  hostMovesFirst: true,
+  myTurn: false,
+  lastAttack: null,
+  seriesGame: 1,
+  wins: 0,
+  losses: 0,
+  rematchRequested: false,
+  deadline: null,
+  clockRole: "",
+  spectators: 0,
+};
+
+function role() {
+  return state.isHost ? "host" : "guest";
+}
+
+function send(type, payload) {
+  if (state.ws && state.ws.readyState === WebSocket.OPEN) {
+    state.ws.send(JSON.stringify({ type, payload: payload || {} }));
+  }
+}
+
+function setStatus(text) {
+  $("status").textContent = text;
+}
+
+function appendChat(text, system) {
+  const item = document.createElement("li");
+  item.textContent = text;
+  if (system) {
+    item.className = "system";
+  }
+  $("chat-log").appendChild(item);
+  $("chat-log").scrollTop = $("chat-log").scrollHeight;
+}
+
+// ---------- Connection ----------
+
+function connect(request, payload) {
+  const name = $("name").value.trim();
+  state.identity.name = name;
+  saveIdentity();
+
+  const scheme = location.protocol === "https:" ? "wss" : "ws";
+  const ws = new WebSocket(`${scheme}://${location.host}/ws`);
+  state.ws = ws;
+  setStatus("Connecting...");
+
+  ws.onopen = () => {
+    send("hello", { player_id: state.identity.id, name, secret: state.identity.secret });
+    send(request, payload);
+  };
+  ws.onmessage = (event) => {
+    let msg;
+    try {
+      msg = JSON.parse(event.data);
+    } catch (e) {
+      return;
+    }
+    const handler = handlers[msg.type];
+    if (handler) {
+      handler(msg.payload || {}); // Unknown types are skipped, like the terminal client does
+      render();
+    }
+  };
+  ws.onclose = (event) => {
+    if (state.ws !== ws) {
+      return; // Left on purpose
+    }
+    state.ws = null;
+    showMenu(event.reason ? `Disconnected: ${event.reason}` : "Connection lost.");
+  };
+}
+
+function leave() {
+  const ws = state.ws;
+  state.ws = null;
+  if (ws) {
+    ws.close();
+  }
+  showMenu("");
+}
+
+// ---------- Game flow ----------
+
+function resetBoards() {
+  state.own = emptyGrid();
+  state.enemy = emptyGrid();
+  state.fleet = [];
+  state.horizontal = true;
+  state.shipsPlaced = false;
+  state.opponentReady = false;
+  state.myTurn = false;
+  state.lastAttack = null;
+  state.rematchRequested = false;
+  state.deadline = null;
+}
+
+function showMenu(status) {
+  state.phase = "menu";
+  resetBoards();
+  state.seriesGame = 1;
+  state.wins = 0;
+  state.losses = 0;
+  state.hostMovesFirst = true;
+  state.spectators = 0;
+  $("chat-log").replaceChildren();
+  setStatus(status);
+  render();
+}
+
+function startPlacement(status) {
+  state.phase = "placement";
+  setStatus(status || "Place your ships: click a square for the bow, R to rotate.");
+}
+
+function startBattleIfReady() {
+  if (!state.shipsPlaced || !state.opponentReady) {
+    return;
+  }
+  state.phase = "battle";
+  state.myTurn = state.isHost === state.hostMovesFirst;
+  setStatus(state.myTurn ? "Battle begins! Your turn." : "Battle begins! Opponent's turn.");
+}
+
+function endGame(won) {
+  state.phase = "over";
+  state.myTurn = false;
+  state.deadline = null;
+  state.rematchRequested = false;
+  if (won) {
+    state.wins++;
+  } else {
+    state.losses++;
+  }
+  setStatus(`${won ? "VICTORY! You sank the enemy fleet." : "DEFEAT. Your fleet was sunk."} Series ${state.wins}-${state.losses}.`);
+}
+
+function shipPositions(length, row, col, horizontal) {
+  const positions = [];
+  for (let i = 0; i < length; i++) {
+    const r = horizontal ? row : row + i;
+    const c = horizontal ? col + i : col;
+    if (r >= BOARD_SIZE || c >= BOARD_SIZE) {
+      return null;
+    }
+    positions.push([r, c]);
+  }
+  return positions;
+}
+
+function canPlace(positions) {
+  return positions !== null && positions.every(([r, c]) => state.own[r][c] === "");
+}
+
+function placeShip(row, col) {
+  const ship = SHIPS[state.fleet.length];
+  const positions = shipPositions(ship.length, row, col, state.horizontal);
+  if (!canPlace(positions)) {
+    return;
+  }
+  for (const [r, c] of positions) {
+    state.own[r][c] = "ship";
+  }
+  state.fleet.push({ name: ship.name, positions, hits: positions.map(() => false) });
+
+  if (state.fleet.length < SHIPS.length) {
+    return;
+  }
+  state.shipsPlaced = true;
+  send("ships_placed", { fleet: state.fleet.map(({ name, positions }) => ({ name, positions })) });
+  state.phase = "ready";
+  setStatus("Ships placed! Waiting for opponent...");
+  startBattleIfReady();
+}
+
+function fire(row, col) {
+  if (state.enemy[row][col] !== "") {
+    setStatus("Already attacked this location!");
+    return;
+  }
+  state.lastAttack = [row, col];
+  send("attack", { row, col });
+  state.myTurn = false; // Until the result arrives
+}
+
+// receiveAttack resolves the opponent's shot against our fleet and answers it
+function receiveAttack({ row, col }) {
+  let hit = false;
+  let sunk = "";
+  const alreadyAttacked = state.own[row][col] === "hit" || state.own[row][col] === "miss";
+  for (const ship of alreadyAttacked ? [] : state.fleet) {
+    const i = ship.positions.findIndex(([r, c]) => r === row && c === col);
+    if (i >= 0) {
+      hit = true;
+      ship.hits[i] = true;
+      if (ship.hits.every(Boolean)) {
+        sunk = ship.name;
+      }
+    }
+  }
+  if (!alreadyAttacked) {
+    state.own[row][col] = hit ? "hit" : "miss";
+  }
+  send("attack_result", { row, col, hit, sunk_ship_name: sunk });
+
+  if (hit && state.fleet.every((ship) => ship.hits.every(Boolean))) {
+    send("game_over", { you_won: true });
+    endGame(false);
+    return;
+  }
+  const status = hit ? (sunk ? `Opponent sunk your ${sunk}!` : "Opponent hit your ship!") : "Opponent missed!";
+  state.myTurn = true;
+  setStatus(status + " Your turn.");
+}
+
+// Handlers for messages from the server, by type
+const handlers = {
+  room_created({ code }) {
+    state.isHost = true;
+    state.phase = "waiting";
+    state.roomCode = code;
+    setStatus(`Room created! Give the code ${code} to your opponent.`);
+  },
+  welcome({ secret }) {
+    if (secret) {
+      state.identity.secret = secret;
+      saveIdentity();
+    }
+  },
+  join_error({ message }) {
+    leave();
+    setStatus(`Error: ${message}`);
+  },
+  hello_error({ message }) {
+    leave();
+    setStatus(`Error: ${message}`);
+  },
+  game_start() {
+    state.isHost = false;
+    state.roomCode = $("code").value.trim().toUpperCase();
+    startPlacement();
+  },
+  player_joined() {
+    startPlacement("Player joined! Place your ships: click a square for the bow, R to rotate.");
+  },
+  ships_placed() {
+    state.opponentReady = true;
+    startBattleIfReady();
+  },
+  attack: receiveAttack,
+  attack_result({ hit, sunk_ship_name }) {
+    if (!state.lastAttack) {
+      return;
+    }
+    const [row, col] = state.lastAttack;
+    state.enemy[row][col] = hit ? "hit" : "miss";
+    state.myTurn = false;
+    const status = hit ? (sunk_ship_name ? `HIT! You sunk their ${sunk_ship_name}!` : "HIT!") : "Miss...";
+    setStatus(status + " Opponent's turn.");
+  },
+  game_over({ you_won }) {
+    endGame(you_won);
+  },
+  opponent_left() {
+    leave();
+    setStatus("Opponent disconnected.");
+  },
+  room_expired({ message }) {
+    leave();
+    setStatus(`Room closed: ${message}`);
+  },
+  notice({ message }) {
+    appendChat(`Server notice: ${message}`, true);
+  },
+  rematch_request() {
+    setStatus(`${$("status").textContent} Opponent wants a rematch!`);
+  },
+  rematch_decline() {
+    state.rematchRequested = false;
+    setStatus("Opponent declined the rematch.");
+  },
+  rematch_start({ game, host_moves_first, host_wins, guest_wins }) {
+    resetBoards();
+    state.seriesGame = game;
+    state.hostMovesFirst = host_moves_first;
+    [state.wins, state.losses] = state.isHost ? [host_wins, guest_wins] : [guest_wins, host_wins];
+    const first = state.isHost === host_moves_first ? "you move first" : "opponent moves first";
+    startPlacement(`Rematch! Game ${game} - ${first}. Place your ships.`);
+  },
+  turn_timer({ role: clockRole, remaining_ms }) {
+    state.deadline = Date.now() + remaining_ms;
+    state.clockRole = clockRole;
+  },
+  placement_timer({ remaining_ms }) {
+    state.deadline = Date.now() + remaining_ms;
+    state.clockRole = "";
+  },
+  turn_timeout({ role: timedOut, action, row, col }) {
+    const mine = timedOut === role();
+    state.deadline = null;
+    if (action === "forfeit") {
+      setStatus(mine ? "You ran out of time and forfeit the game." : "Opponent ran out of time and forfeits the game.");
+    } else if (action === "random") {
+      if (mine) {
+        state.lastAttack = [row, col]; // The server fired for us; the result arrives as usual
+        state.myTurn = false;
+        setStatus(`Out of time! A random shot was fired at ${String.fromCharCode(65 + col)}${row + 1}.`);
+      } else {
+        setStatus("Opponent ran out of time. A random shot was fired for them.");
+      }
+    } else {
+      state.myTurn = !mine;
+      setStatus(mine ? "Out of time! Your turn was skipped." : "Opponent ran out of time. Your turn.");
+    }
+  },
+  spectator_count({ count }) {
+    state.spectators = count;
+  },
+  chat({ from, text }) {
+    appendChat(`${from}: ${text}`, false);
+  },
+  chat_rejected({ message }) {
+    appendChat(message, true);
+  },
+};
+
+// ---------- Rendering ----------
+
+function renderBoard(element, cells, onClick, onHover) {
+  element.replaceChildren();
+  element.appendChild(document.createElement("span"));
+  for (let c = 0; c < BOARD_SIZE; c++) {
+    const label = document.createElement("span");
+    label.className = "label";
+    label.textContent = String.fromCharCode(65 + c);
+    element.appendChild(label);
+  }
+  for (let r = 0; r < BOARD_SIZE; r++) {
+    const label = document.createElement("span");
+    label.className = "label";
+    label.textContent = r + 1;
+    element.appendChild(label);
+    for (let c = 0; c < BOARD_SIZE; c++) {
+      const cell = document.createElement("span");
+      cell.className = `cell ${cells[r][c]}`;
+      cell.dataset.row = r;
+      cell.dataset.col = c;
+      if (onClick) {
+        cell.addEventListener("click", () => {
+          onClick(r, c);
+          render();
+        });
+      }
+      if (onHover) {
+        cell.addEventListener("mouseenter", () => onHover(r, c));
+      }
+      element.appendChild(cell);
+    }
+  }
+}
+
+// previewShip highlights where the next ship would go
+function previewShip(row, col) {
+  const board = $("own-board");
+  board.querySelectorAll(".preview, .invalid").forEach((cell) => cell.classList.remove("preview", "invalid"));
+  if (state.phase !== "placement") {
+    return;
+  }
+  const ship = SHIPS[state.fleet.length];
+  const positions = shipPositions(ship.length, row, col, state.horizontal);
+  const valid = canPlace(positions);
+  const squares = positions || shipPositions(1, row, col, true);
+  for (const [r, c] of squares) {
+    board.querySelector(`[data-row="${r}"][data-col="${c}"]`).classList.add(valid ? "preview" : "invalid");
+  }
+}
+
+function render() {
+  const inGame = state.phase !== "menu";
+  $("menu").hidden = inGame;
+  $("game").hidden = !inGame;
+  if (!inGame) {
+    $("clock").textContent = "";
+    return;
+  }
+
+  const placing = state.phase === "placement";
+  renderBoard($("own-board"), state.own, placing ? placeShip : null, placing ? previewShip : null);
+  const firing = state.phase === "battle" && state.myTurn;
+  renderBoard($("enemy-board"), state.enemy, firing ? fire : null, null);
+  $("enemy-board").classList.toggle("active", firing);
+
+  $("rotate").hidden = !placing;
+  $("rematch").hidden = state.phase !== "over" || state.rematchRequested;
+  $("decline").hidden = state.phase !== "over";
+
+  let room = `Room ${state.roomCode}`;
+  if (state.seriesGame > 1 || state.wins + state.losses > 0) {
+    room += ` · Game ${state.seriesGame} · Series ${state.wins}-${state.losses}`;
+  }
+  if (state.spectators > 0) {
+    room += ` · ${state.spectators} watching`;
+  }
+  $("room").textContent = room;
+  renderClock();
+}
+
+function renderClock() {
+  if (!state.deadline) {
+    $("clock").textContent = "";
+    return;
+  }
+  const seconds = Math.max(0, Math.ceil((state.deadline - Date.now()) / 1000));
+  let whose = "Placement";
+  if (state.clockRole) {
+    whose = state.clockRole === role() ? "Your shot" : "Opponent's shot";
+  }
+  $("clock").textContent = `${whose}: ${seconds}s`;
+}
+
+// ---------- Input ----------
+
+$("name").value = state.identity.name;
+$("host").addEventListener("click", () => connect("create_room", { private: false }));
+$("host-private").addEventListener("click", () => connect("create_room", { private: true }));
+$("join").addEventListener("click", () => {
+  const code = $("code").value.trim().toUpperCase();
+  if (code) {
+    connect("join_room", { code });
+  }
+});
+$("code").addEventListener("keydown", (event) => {
+  if (event.key === "Enter") {
+    $("join").click();
+  }
+});
+$("leave").addEventListener("click", leave);
+$("rotate").addEventListener("click", () => {
+  state.horizontal = !state.horizontal;
+});
+$("rematch").addEventListener("click", () => {
+  state.rematchRequested = true;
+  send("rematch_request");
+  setStatus("Rematch requested. Waiting for opponent...");
+  render();
+});
+$("decline").addEventListener("click", () => {
+  send("rematch_decline");
+  leave();
+});
+$("chat-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const text = $("chat-input").value.trim();
+  if (text) {
+    send("chat", { text });
+  }
+  $("chat-input").value = "";
+});
+document.addEventListener("keydown", (event) => {
+  if ((event.key === "r" || event.key === "R") && event.target.tagName !== "INPUT") {
+    state.horizontal = !state.horizontal;
+  }
+});
+
+setInterval(renderClock, 250);
+render();
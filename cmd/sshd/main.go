@@ -0,0 +1,305 @@
+// Command sshd serves Battleship over SSH, so players need nothing but an
+// SSH client:
+//
+//	go run ./cmd/sshd -port 2222
+//	ssh -p 2222 localhost
+//
+// Every session runs its own copy of the game. Multiplayer games are played
+// between sessions of the same sshd, matched by room code in-process rather
+// than through the central server. Players are identified by their SSH
+// public key, so any key is accepted and keeps its player identity from one
+// session to the next.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	bnet "battle-ship/net"
+	"battle-ship/ui"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// roomCodeAlphabet leaves out I and O, which are easily confused with 1
+	// and 0, like the central server's codes.
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	roomCodeLength   = 4
+
+	// maxNameLength keeps SSH user names used as player names short.
+	maxNameLength = 20
+
+	// keyIDExtension carries the player ID derived from the client's key
+	// from authentication to the session.
+	keyIDExtension = "battleship-key-id"
+)
+
+func main() {
+	port := flag.Int("port", 2222, "port to serve SSH on")
+	hostKeyPath := flag.String("host-key", "battleship_host_key", "file holding the server's private host key (created if missing)")
+	flag.Parse()
+
+	hostKey, err := loadHostKey(*hostKeyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{keyIDExtension: keyID(key)}}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	// Sessions are rendered for the client's terminal, not the server's
+	lipgloss.SetColorProfile(termenv.ANSI256)
+
+	lobby := bnet.NewLocalServer(newRoomCode)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Printf("Serving SSH on port %d, host key %s", *port, ssh.FingerprintSHA256(hostKey.PublicKey()))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		go serveConn(conn, config, lobby)
+	}
+}
+
+// loadHostKey reads the host key at path, generating and saving a new
+// ed25519 key if there is none yet.
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %w", err)
+		}
+		block, err := ssh.MarshalPrivateKey(private, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode host key: %w", err)
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save host key: %w", err)
+		}
+		log.Printf("Generated a new host key in %s", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %w", err)
+	}
+	return signer, nil
+}
+
+// keyID derives a player ID from a public key, shaped like the IDs clients
+// generate for themselves.
+func keyID(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return hex.EncodeToString(sum[:16])
+}
+
+// newRoomCode returns a random room code.
+func newRoomCode() string {
+	code := make([]byte, roomCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(roomCodeAlphabet))))
+		if err != nil {
+			panic(err) // crypto/rand doesn't fail on supported platforms
+		}
+		code[i] = roomCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+// serveConn runs the SSH handshake on conn and serves its sessions.
+func serveConn(conn net.Conn, config *ssh.ServerConfig, lobby *bnet.LocalServer) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("Failed handshake with %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	// The name is shown on other players' terminals, so it must not carry
+	// control characters
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, sshConn.User())
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = string(runes[:maxNameLength])
+	}
+	if name == "" {
+		name = "Player"
+	}
+	identity := bnet.Identity{ID: sshConn.Permissions.Extensions[keyIDExtension], Name: name}
+	log.Printf("%s connected from %s as %s", name, sshConn.RemoteAddr(), identity.ID)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("Failed to accept session from %s: %v", name, err)
+			continue
+		}
+		go serveSession(channel, channelRequests, identity, lobby)
+	}
+	log.Printf("%s disconnected", name)
+}
+
+// ptyRequest is the payload of a "pty-req" request (RFC 4254, 6.2).
+type ptyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+	Modes    string
+}
+
+// windowChange is the payload of a "window-change" request (RFC 4254, 6.7).
+type windowChange struct {
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+}
+
+// session is one SSH session playing the game.
+type session struct {
+	channel  ssh.Channel
+	identity bnet.Identity
+	lobby    *bnet.LocalServer
+
+	mu         sync.Mutex
+	transports []bnet.Transport // Connections to the lobby, closed when the session ends
+}
+
+// serveSession runs the game in channel once the client asks for a shell,
+// until the player quits or the client disconnects.
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request, identity bnet.Identity, lobby *bnet.LocalServer) {
+	s := &session{channel: channel, identity: identity, lobby: lobby}
+	var pty *ptyRequest
+	var program *tea.Program
+
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			pty = &ptyRequest{}
+			if err := ssh.Unmarshal(req.Payload, pty); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+		case "window-change":
+			var size windowChange
+			if err := ssh.Unmarshal(req.Payload, &size); err == nil && program != nil {
+				program.Send(tea.WindowSizeMsg{Width: int(size.Columns), Height: int(size.Rows)})
+			}
+		case "shell":
+			if program != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			if pty == nil {
+				fmt.Fprint(channel, "Battleship needs a terminal. Connect with ssh -t.\r\n")
+				s.exit(1)
+				continue
+			}
+			program = s.start(pty)
+		default:
+			req.Reply(false, nil) // Including exec and subsystems
+		}
+	}
+
+	// The client disconnected
+	if program != nil {
+		program.Kill()
+	}
+	s.closeTransports()
+}
+
+// start runs the game for the session's player in the terminal described by
+// pty.
+func (s *session) start(pty *ptyRequest) *tea.Program {
+	model := ui.NewModel()
+	model.Identity = s.identity
+	model.NoLAN = true // Don't let players listen on the server's network
+	model.Dial = func(string) (bnet.Transport, error) {
+		t := s.lobby.Connect()
+		s.mu.Lock()
+		s.transports = append(s.transports, t)
+		s.mu.Unlock()
+		return t, nil
+	}
+
+	program := tea.NewProgram(model,
+		tea.WithInput(s.channel),
+		tea.WithOutput(s.channel),
+		tea.WithAltScreen(),
+		tea.WithoutSignalHandler(),
+		tea.WithEnvironment([]string{"TERM=" + pty.Term}),
+	)
+	go program.Send(tea.WindowSizeMsg{Width: int(pty.Columns), Height: int(pty.Rows)})
+	go func() {
+		_, err := program.Run()
+		s.closeTransports()
+		if err != nil && !errors.Is(err, tea.ErrProgramKilled) {
+			log.Printf("Game of %s failed: %v", s.identity.Name, err)
+			s.exit(1)
+			return
+		}
+		s.exit(0)
+	}()
+	return program
+}
+
+// closeTransports leaves any game the session was in.
+func (s *session) closeTransports() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.transports {
+		t.Close()
+	}
+	s.transports = nil
+}
+
+// exit ends the session with status.
+func (s *session) exit(status uint32) {
+	s.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	s.channel.Close()
+}
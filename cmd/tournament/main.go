@@ -0,0 +1,331 @@
+// Command tournament pits Battleship strategies against each other.
+//
+// Strategies are given as arguments: built-in AIs as "ai:easy", "ai:medium"
+// or "ai:hard", and external bots (see BOTS.md) as "exec:<command>". Either
+// may be named with a "<name>=" prefix:
+//
+//	go run ./cmd/tournament -games 20 ai:easy ai:hard "random=exec:python3 examples/random_bot.py"
+//
+// Every pairing plays a match of several games on boards generated from the
+// seed, so a tournament can be replayed. The standings are printed and
+// written, with a log of every match, to the output directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"battle-ship/game"
+)
+
+// Tournament formats
+const (
+	FormatRoundRobin = "roundrobin"
+	FormatSwiss      = "swiss"
+)
+
+// standing is a strategy's running score.
+type standing struct {
+	Player      int
+	Points      float64 // 1 per match won, 0.5 per match drawn or bye
+	MatchWins   int
+	MatchDraws  int
+	MatchLosses int
+	Byes        int
+	GameWins    int
+	GameLosses  int
+	Forfeits    int // Games lost by crashing, timing out or misbehaving
+	SinkWins    int // Games won by sinking the opponent's fleet
+	WinShots    int // Shots fired in those games
+}
+
+// ahead reports whether s ranks above other.
+func (s *standing) ahead(other *standing) bool {
+	if s.Points != other.Points {
+		return s.Points > other.Points
+	}
+	if s.GameWins != other.GameWins {
+		return s.GameWins > other.GameWins
+	}
+	return s.avgWinShots() < other.avgWinShots()
+}
+
+// avgWinShots is the average number of shots s needed to sink a fleet.
+func (s *standing) avgWinShots() float64 {
+	if s.SinkWins == 0 {
+		return math.Inf(1)
+	}
+	return float64(s.WinShots) / float64(s.SinkWins)
+}
+
+// tournament runs matches between strategies and keeps the standings.
+type tournament struct {
+	strategies []Strategy
+	games      int
+	seed       int64
+	timeout    time.Duration
+	outDir     string
+
+	standings []*standing
+	played    map[[2]int]bool
+	matches   int
+}
+
+func main() {
+	format := flag.String("format", FormatRoundRobin, "tournament format: roundrobin or swiss")
+	rounds := flag.Int("rounds", 0, "rounds to play in a swiss tournament (0 = enough to find a winner)")
+	games := flag.Int("games", 10, "games per match; players alternate firing first and swap boards")
+	seed := flag.Int64("seed", 1, "seed for boards and built-in AIs")
+	timeout := flag.Duration("bot-timeout", game.DefaultBotTimeout, "time external bots have to answer each request")
+	outDir := flag.String("out", "tournament-results", "directory for the standings and match logs")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] strategy...\n\nStrategies are ai:<easy|medium|hard> or exec:<command>, optionally prefixed with <name>=.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *games < 1 {
+		log.Fatal("-games must be at least 1")
+	}
+
+	strategies, err := parseStrategies(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(*outDir, "matches"), 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	t := &tournament{
+		strategies: strategies,
+		games:      *games,
+		seed:       *seed,
+		timeout:    *timeout,
+		outDir:     *outDir,
+		played:     make(map[[2]int]bool),
+	}
+	for i := range strategies {
+		t.standings = append(t.standings, &standing{Player: i})
+	}
+
+	switch *format {
+	case FormatRoundRobin:
+		err = t.runRoundRobin()
+	case FormatSwiss:
+		n := *rounds
+		if n <= 0 {
+			n = int(math.Ceil(math.Log2(float64(len(strategies)))))
+		}
+		err = t.runSwiss(n)
+	default:
+		log.Fatalf("invalid -format %q", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := t.writeStandings(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseStrategies parses strategy specs, making their names unique.
+func parseStrategies(specs []string) ([]Strategy, error) {
+	var strategies []Strategy
+	seen := make(map[string]int)
+	for _, spec := range specs {
+		s, err := parseStrategy(spec)
+		if err != nil {
+			return nil, err
+		}
+		seen[s.Name]++
+		if n := seen[s.Name]; n > 1 {
+			s.Name = fmt.Sprintf("%s#%d", s.Name, n)
+		}
+		strategies = append(strategies, s)
+	}
+	return strategies, nil
+}
+
+func (t *tournament) runRoundRobin() error {
+	for r, pairs := range roundRobinRounds(len(t.strategies)) {
+		for _, p := range pairs {
+			if err := t.playMatch(r+1, p[0], p[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *tournament) runSwiss(rounds int) error {
+	for r := 1; r <= rounds; r++ {
+		pairs, byePlayer := swissPairings(t.standings, t.played)
+		if byePlayer != bye {
+			s := t.standings[byePlayer]
+			s.Byes++
+			s.Points++
+			fmt.Printf("Round %d: %s has a bye\n", r, t.strategies[byePlayer].Name)
+		}
+		for _, p := range pairs {
+			if err := t.playMatch(r, p[0], p[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// playMatch plays t.games games between players a and b and scores the
+// match. Games are played in pairs on the same two boards, with the players
+// swapping boards and who fires first, so neither gets the luckier layout.
+func (t *tournament) playMatch(round, a, b int) error {
+	t.matches++
+	t.played[pairKey(a, b)] = true
+	nameA, nameB := t.strategies[a].Name, t.strategies[b].Name
+
+	logPath := filepath.Join(t.outDir, "matches", fmt.Sprintf("round%02d-match%03d-%s-vs-%s.log", round, t.matches, fileName(nameA), fileName(nameB)))
+	f, err := os.Create(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to create match log: %w", err)
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "Round %d: %s vs %s\n", round, nameA, nameB)
+
+	var wins [2]int
+	for g := 0; g < t.games; g++ {
+		seed := t.gameSeed(g / 2)
+		boards := [2]*game.Board{seededBoard(seed), seededBoard(seed + 1)}
+		players := [2]int{a, b}
+		if g%2 == 1 {
+			players = [2]int{b, a}
+		}
+
+		fmt.Fprintf(f, "\nGame %d (seed %d): %s fires first\n", g+1, seed, t.strategies[players[0]].Name)
+		result, err := t.playGame(players, boards, seed, f)
+		if err != nil {
+			return err
+		}
+
+		winner, loser := players[result.Winner], players[1-result.Winner]
+		if winner == a {
+			wins[0]++
+		} else {
+			wins[1]++
+		}
+		t.standings[winner].GameWins++
+		t.standings[loser].GameLosses++
+		if result.Err != nil {
+			t.standings[loser].Forfeits++
+		} else {
+			t.standings[winner].SinkWins++
+			t.standings[winner].WinShots += result.Shots[result.Winner]
+		}
+	}
+
+	sa, sb := t.standings[a], t.standings[b]
+	switch {
+	case wins[0] > wins[1]:
+		sa.Points++
+		sa.MatchWins++
+		sb.MatchLosses++
+	case wins[1] > wins[0]:
+		sb.Points++
+		sb.MatchWins++
+		sa.MatchLosses++
+	default:
+		sa.Points += 0.5
+		sb.Points += 0.5
+		sa.MatchDraws++
+		sb.MatchDraws++
+	}
+
+	summary := fmt.Sprintf("Round %d: %s %d - %d %s", round, nameA, wins[0], wins[1], nameB)
+	fmt.Fprintf(f, "\n%s\n", summary)
+	fmt.Println(summary)
+	return nil
+}
+
+// playGame plays one game, players[0] firing first, logging it to w.
+func (t *tournament) playGame(players [2]int, boards [2]*game.Board, seed int64, w io.Writer) (game.MatchResult, error) {
+	match := game.Match{Boards: boards, Log: w}
+	for i, p := range players {
+		opponent, err := t.strategies[p].newOpponent(seed+int64(i), t.timeout, w)
+		if err != nil {
+			return game.MatchResult{}, err
+		}
+		defer opponent.Close()
+		match.Players[i] = opponent
+		match.Names[i] = t.strategies[p].Name
+	}
+	return match.Play(), nil
+}
+
+// gameSeed derives the seed of the n-th pair of games in the current match.
+func (t *tournament) gameSeed(n int) int64 {
+	return t.seed*1_000_003 + int64(t.matches)*10_007 + int64(n)*101
+}
+
+// seededBoard places a fleet at random, determined by seed.
+func seededBoard(seed int64) *game.Board {
+	board := game.NewBoard()
+	game.NewSeededAI(game.Medium, seed).PlaceShipsRandomly(board)
+	return board
+}
+
+// writeStandings prints the final standings and saves them to the output directory.
+func (t *tournament) writeStandings() error {
+	ranked := make([]*standing, len(t.standings))
+	copy(ranked, t.standings)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].ahead(ranked[j]) })
+
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Rank\tStrategy\tPoints\tMatches (W-D-L)\tGames (W-L)\tWin %\tAvg Shots to Win\tForfeits")
+	for i, s := range ranked {
+		winRate := 0.0
+		if total := s.GameWins + s.GameLosses; total > 0 {
+			winRate = 100 * float64(s.GameWins) / float64(total)
+		}
+		avgShots := "-"
+		if s.SinkWins > 0 {
+			avgShots = fmt.Sprintf("%.1f", s.avgWinShots())
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%.1f\t%d-%d-%d\t%d-%d\t%.1f\t%s\t%d\n",
+			i+1, t.strategies[s.Player].Name, s.Points,
+			s.MatchWins, s.MatchDraws, s.MatchLosses,
+			s.GameWins, s.GameLosses, winRate, avgShots, s.Forfeits)
+	}
+	tw.Flush()
+
+	fmt.Println()
+	fmt.Print(sb.String())
+
+	path := filepath.Join(t.outDir, "standings.txt")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write standings: %w", err)
+	}
+	fmt.Printf("\nStandings and match logs written to %s\n", t.outDir)
+	return nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileName makes a strategy name safe to use in a file name.
+func fileName(name string) string {
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,99 @@
+package main
+
+import "sort"
+
+// bye marks a player sitting out a round.
+const bye = -1
+
+// roundRobinRounds schedules every player against every other once, using
+// the circle method. With an odd number of players one sits out each round.
+func roundRobinRounds(players int) [][][2]int {
+	ids := make([]int, players)
+	for i := range ids {
+		ids[i] = i
+	}
+	if players%2 == 1 {
+		ids = append(ids, bye)
+	}
+
+	n := len(ids)
+	var rounds [][][2]int
+	for r := 0; r < n-1; r++ {
+		var pairs [][2]int
+		for i := 0; i < n/2; i++ {
+			a, b := ids[i], ids[n-1-i]
+			if a == bye || b == bye {
+				continue
+			}
+			if r%2 == 1 { // Alternate who is listed, and so moves, first
+				a, b = b, a
+			}
+			pairs = append(pairs, [2]int{a, b})
+		}
+		rounds = append(rounds, pairs)
+
+		// Keep the first player fixed and rotate the rest
+		last := ids[n-1]
+		copy(ids[2:], ids[1:n-1])
+		ids[1] = last
+	}
+	return rounds
+}
+
+// swissPairings pairs players with similar scores who have not met yet.
+// Players are taken in standings order and each is paired with the next
+// available player they haven't played, falling back to a rematch when
+// there is no one left. With an odd number of players, the lowest ranked
+// player who hasn't had a bye sits out and is returned as byePlayer.
+func swissPairings(standings []*standing, played map[[2]int]bool) (pairs [][2]int, byePlayer int) {
+	order := make([]*standing, len(standings))
+	copy(order, standings)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].ahead(order[j]) })
+
+	byePlayer = bye
+	if len(order)%2 == 1 {
+		sitOut := len(order) - 1 // Everyone has had a bye; the last player sits out again
+		for i := len(order) - 1; i >= 0; i-- {
+			if order[i].Byes == 0 {
+				sitOut = i
+				break
+			}
+		}
+		byePlayer = order[sitOut].Player
+		order = append(order[:sitOut:sitOut], order[sitOut+1:]...)
+	}
+
+	paired := make([]bool, len(order))
+	for i := range order {
+		if paired[i] {
+			continue
+		}
+		opponent := -1
+		for j := i + 1; j < len(order); j++ {
+			if paired[j] {
+				continue
+			}
+			if opponent == -1 {
+				opponent = j // Rematch fallback
+			}
+			if !played[pairKey(order[i].Player, order[j].Player)] {
+				opponent = j
+				break
+			}
+		}
+		if opponent == -1 {
+			break
+		}
+		paired[i], paired[opponent] = true, true
+		pairs = append(pairs, [2]int{order[i].Player, order[opponent].Player})
+	}
+	return pairs, byePlayer
+}
+
+// pairKey identifies a pairing regardless of order.
+func pairKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoundRobinRounds(t *testing.T) {
+	for _, players := range []int{2, 3, 4, 5, 8, 9} {
+		rounds := roundRobinRounds(players)
+
+		wantRounds := players - 1
+		if players%2 == 1 {
+			wantRounds = players
+		}
+		if len(rounds) != wantRounds {
+			t.Errorf("%d players: %d rounds, want %d", players, len(rounds), wantRounds)
+		}
+
+		met := make(map[[2]int]int)
+		byes := make([]int, players)
+		for r, pairs := range rounds {
+			if len(pairs) != players/2 {
+				t.Errorf("%d players: round %d has %d pairs, want %d", players, r, len(pairs), players/2)
+			}
+			seated := make([]bool, players)
+			for _, p := range pairs {
+				for _, id := range p {
+					if id < 0 || id >= players || seated[id] {
+						t.Fatalf("%d players: round %d pairs %v", players, r, pairs)
+					}
+					seated[id] = true
+				}
+				met[pairKey(p[0], p[1])]++
+			}
+			for id, ok := range seated {
+				if !ok {
+					byes[id]++
+				}
+			}
+		}
+
+		for a := 0; a < players; a++ {
+			for b := a + 1; b < players; b++ {
+				if n := met[[2]int{a, b}]; n != 1 {
+					t.Errorf("%d players: %d and %d met %d times", players, a, b, n)
+				}
+			}
+			if wantByes := players % 2; byes[a] != wantByes {
+				t.Errorf("%d players: %d sat out %d rounds, want %d", players, a, byes[a], wantByes)
+			}
+		}
+	}
+}
+
+func TestSwissPairings(t *testing.T) {
+	tests := []struct {
+		name      string
+		standings []*standing
+		played    [][2]int
+		wantPairs [][2]int
+		wantBye   int
+	}{
+		{
+			name:      "first round",
+			standings: []*standing{{Player: 0}, {Player: 1}, {Player: 2}, {Player: 3}},
+			wantPairs: [][2]int{{0, 1}, {2, 3}},
+			wantBye:   bye,
+		},
+		{
+			name:      "by score",
+			standings: []*standing{{Player: 0}, {Player: 1, Points: 1}, {Player: 2}, {Player: 3, Points: 1}},
+			wantPairs: [][2]int{{1, 3}, {0, 2}},
+			wantBye:   bye,
+		},
+		{
+			name:      "skips opponents already played",
+			standings: []*standing{{Player: 0, Points: 2}, {Player: 1, Points: 1}, {Player: 2, Points: 1}, {Player: 3}},
+			played:    [][2]int{{0, 1}},
+			wantPairs: [][2]int{{0, 2}, {1, 3}},
+			wantBye:   bye,
+		},
+		{
+			name:      "rematch when everyone left has played",
+			standings: []*standing{{Player: 0, Points: 2}, {Player: 1, Points: 1}, {Player: 2, Points: 1}, {Player: 3}},
+			played:    [][2]int{{0, 1}, {0, 2}, {0, 3}, {1, 2}},
+			wantPairs: [][2]int{{0, 1}, {2, 3}},
+			wantBye:   bye,
+		},
+		{
+			name:      "lowest ranked player sits out",
+			standings: []*standing{{Player: 0, Points: 1}, {Player: 1}, {Player: 2, Points: 2}},
+			wantPairs: [][2]int{{2, 0}},
+			wantBye:   1,
+		},
+		{
+			name:      "no second bye while others haven't had one",
+			standings: []*standing{{Player: 0, Points: 1}, {Player: 1, Points: 0.5, Byes: 1}, {Player: 2, Points: 2}},
+			played:    [][2]int{{0, 2}},
+			wantPairs: [][2]int{{2, 1}},
+			wantBye:   0,
+		},
+		{
+			name:      "last player sits out again once everyone has had a bye",
+			standings: []*standing{{Player: 0, Points: 1, Byes: 1}, {Player: 1, Points: 0.5, Byes: 1}, {Player: 2, Points: 2, Byes: 1}},
+			wantPairs: [][2]int{{2, 0}},
+			wantBye:   1,
+		},
+	}
+	for _, tt := range tests {
+		played := make(map[[2]int]bool)
+		for _, p := range tt.played {
+			played[pairKey(p[0], p[1])] = true
+		}
+		pairs, byePlayer := swissPairings(tt.standings, played)
+		if !reflect.DeepEqual(pairs, tt.wantPairs) || byePlayer != tt.wantBye {
+			t.Errorf("%s: pairs %v, bye %d; want %v, bye %d", tt.name, pairs, byePlayer, tt.wantPairs, tt.wantBye)
+		}
+	}
+}
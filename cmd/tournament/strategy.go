@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"battle-ship/game"
+)
+
+// Strategy is a contestant: the built-in AI at some difficulty, or an
+// external bot executable.
+type Strategy struct {
+	Name       string
+	Difficulty game.Difficulty // Used when Command is empty
+	Command    string          // External bot command line
+}
+
+// parseStrategy parses a strategy spec: "ai:<difficulty>" or
+// "exec:<command>", optionally prefixed with "<name>=".
+func parseStrategy(spec string) (Strategy, error) {
+	var s Strategy
+	if name, rest, ok := strings.Cut(spec, "="); ok && !strings.Contains(name, ":") {
+		s.Name = strings.TrimSpace(name)
+		spec = rest
+	}
+
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Strategy{}, fmt.Errorf("invalid strategy %q: expected ai:<difficulty> or exec:<command>", spec)
+	}
+	switch kind {
+	case "ai":
+		d, err := game.ParseDifficulty(arg)
+		if err != nil {
+			return Strategy{}, fmt.Errorf("invalid strategy %q: %w", spec, err)
+		}
+		s.Difficulty = d
+		if s.Name == "" {
+			s.Name = "ai-" + d.String()
+		}
+	case "exec":
+		s.Command = strings.TrimSpace(arg)
+		if s.Command == "" {
+			return Strategy{}, fmt.Errorf("invalid strategy %q: missing command", spec)
+		}
+		if s.Name == "" {
+			// Name the bot after its program, e.g. "random_bot" for "python3 random_bot.py"
+			fields := strings.Fields(s.Command)
+			base := filepath.Base(fields[len(fields)-1])
+			s.Name = strings.TrimSuffix(base, filepath.Ext(base))
+		}
+	default:
+		return Strategy{}, fmt.Errorf("invalid strategy %q: unknown kind %q", spec, kind)
+	}
+	return s, nil
+}
+
+// newOpponent starts a fresh opponent playing s for one game. Built-in AIs
+// are seeded so the game can be replayed.
+func (s Strategy) newOpponent(seed int64, timeout time.Duration, stderr io.Writer) (game.Opponent, error) {
+	if s.Command == "" {
+		return game.NewSeededAIOpponent(s.Difficulty, seed), nil
+	}
+	return game.StartExternalBot(s.Command, timeout, stderr)
+}
@@ -0,0 +1,54 @@
+#!/usr/bin/env python3
+"""A minimal Battleship bot for the local bot protocol (see BOTS.md).
+
+Places its fleet at random and fires at random cells it has not tried yet.
+
+    go run . -bot "python3 examples/random_bot.py"
+"""
+import random
+import sys
+
+SIZE = 10
+COLUMNS = "ABCDEFGHIJ"
+
+
+def coord(row, col):
+    return f"{COLUMNS[col]}{row + 1}"
+
+
+def place(fleet):
+    taken = set()
+    for name, length in fleet:
+        while True:
+            horizontal = random.random() < 0.5
+            row = random.randrange(SIZE if horizontal else SIZE - length + 1)
+            col = random.randrange(SIZE - length + 1 if horizontal else SIZE)
+            cells = {(row, col + i) if horizontal else (row + i, col) for i in range(length)}
+            if not cells & taken:
+                taken |= cells
+                print(f"{name} {coord(row, col)} {'H' if horizontal else 'V'}", flush=True)
+                break
+
+
+def main():
+    untried = []
+    for line in sys.stdin:
+        words = line.split()
+        if not words:
+            continue
+        command = words[0]
+        if command in ("PLACE", "FLEET"):
+            if command == "PLACE":
+                fleet = [(name, int(length)) for name, length in (w.split(":") for w in words[1:])]
+                place(fleet)
+            untried = [(r, c) for r in range(SIZE) for c in range(SIZE)]
+            random.shuffle(untried)
+        elif command == "FIRE":
+            print(coord(*untried.pop()), flush=True)
+        elif command == "END":
+            break
+        # RESULT and INCOMING are ignored by this bot
+
+
+if __name__ == "__main__":
+    main()
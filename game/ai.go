@@ -1,12 +1,53 @@
 package game
 
 import (
+	"fmt"
 	"math/rand"
+	"strings"
+	"time"
 )
 
+// Difficulty is how well the AI plays.
+type Difficulty int
+
+const (
+	// Easy fires at random.
+	Easy Difficulty = iota
+	// Medium fires at random until it hits, then targets neighboring cells.
+	Medium
+	// Hard is Medium, but only searches a checkerboard of cells, since
+	// every ship covers at least two.
+	Hard
+)
+
+// String returns the name of the difficulty.
+func (d Difficulty) String() string {
+	switch d {
+	case Easy:
+		return "easy"
+	case Medium:
+		return "medium"
+	case Hard:
+		return "hard"
+	}
+	return fmt.Sprintf("Difficulty(%d)", int(d))
+}
+
+// ParseDifficulty parses a difficulty name: easy, medium or hard.
+func ParseDifficulty(s string) (Difficulty, error) {
+	for _, d := range []Difficulty{Easy, Medium, Hard} {
+		if strings.EqualFold(s, d.String()) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown difficulty %q", s)
+}
+
 // AI handles computer opponent logic.
 // It maintains state about past attacks and uses a hunt/target strategy.
 type AI struct {
+	difficulty    Difficulty
+	rng           *rand.Rand
 	lastHit       *[2]int
 	huntMode      bool
 	huntTargets   [][2]int
@@ -15,7 +56,15 @@ type AI struct {
 
 // NewAI creates a new AI opponent with initialized state.
 func NewAI() *AI {
+	return NewSeededAI(Medium, time.Now().UnixNano())
+}
+
+// NewSeededAI creates an AI of the given difficulty whose ship placement and
+// shots are determined by seed, so games can be replayed.
+func NewSeededAI(difficulty Difficulty, seed int64) *AI {
 	return &AI{
+		difficulty:    difficulty,
+		rng:           rand.New(rand.NewSource(seed)),
 		attackedCells: make(map[[2]int]bool),
 	}
 }
@@ -28,9 +77,9 @@ func (ai *AI) PlaceShipsRandomly(board *Board) {
 	for _, ship := range ships {
 		placed := false
 		for !placed {
-			row := rand.Intn(BoardSize)
-			col := rand.Intn(BoardSize)
-			horizontal := rand.Intn(2) == 0
+			row := ai.rng.Intn(BoardSize)
+			col := ai.rng.Intn(BoardSize)
+			horizontal := ai.rng.Intn(2) == 0
 
 			if board.PlaceShip(ship, row, col, horizontal) {
 				placed = true
@@ -44,7 +93,7 @@ func (ai *AI) PlaceShipsRandomly(board *Board) {
 // Returns the row and column of the target cell.
 func (ai *AI) ChooseAttack() (int, int) {
 	// If we have hunt targets from a previous hit, try those first
-	if ai.huntMode && len(ai.huntTargets) > 0 {
+	if ai.difficulty != Easy && ai.huntMode && len(ai.huntTargets) > 0 {
 		for len(ai.huntTargets) > 0 {
 			target := ai.huntTargets[0]
 			ai.huntTargets = ai.huntTargets[1:]
@@ -58,11 +107,15 @@ func (ai *AI) ChooseAttack() (int, int) {
 	}
 
 	// Random attack
+	checkerboard := ai.difficulty == Hard && ai.checkerboardOpen()
 	for {
-		row := rand.Intn(BoardSize)
-		col := rand.Intn(BoardSize)
+		row := ai.rng.Intn(BoardSize)
+		col := ai.rng.Intn(BoardSize)
 		pos := [2]int{row, col}
 
+		if checkerboard && (row+col)%2 != 0 {
+			continue
+		}
 		if !ai.attackedCells[pos] {
 			ai.attackedCells[pos] = true
 			return row, col
@@ -70,6 +123,18 @@ func (ai *AI) ChooseAttack() (int, int) {
 	}
 }
 
+// checkerboardOpen reports whether any checkerboard cell is left to attack.
+func (ai *AI) checkerboardOpen() bool {
+	for row := 0; row < BoardSize; row++ {
+		for col := row % 2; col < BoardSize; col += 2 {
+			if !ai.attackedCells[[2]int{row, col}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // RecordHit tells the AI about a successful hit at the given coordinates.
 // This triggers "hunt mode" where the AI will target adjacent cells in subsequent turns.
 func (ai *AI) RecordHit(row, col int) {
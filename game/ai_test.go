@@ -0,0 +1,66 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+// playAI has ai place a fleet and then fire at target until its fleet is
+// sunk, returning the fleet and the shots.
+func playAI(ai *AI, target *Board) (*Board, [][2]int) {
+	fleet := NewBoard()
+	ai.PlaceShipsRandomly(fleet)
+
+	var shots [][2]int
+	for !target.AllShipsSunk() && len(shots) < BoardSize*BoardSize {
+		row, col := ai.ChooseAttack()
+		shots = append(shots, [2]int{row, col})
+		if hit, _, _ := target.Attack(row, col); hit {
+			ai.RecordHit(row, col)
+		} else {
+			ai.RecordMiss(row, col)
+		}
+	}
+	return fleet, shots
+}
+
+func TestSeededAIReplays(t *testing.T) {
+	for _, difficulty := range []Difficulty{Easy, Medium, Hard} {
+		var fleets []*Board
+		var shots [][][2]int
+		for _, seed := range []int64{42, 42, 7} {
+			target := NewBoard()
+			NewSeededAI(Easy, 1).PlaceShipsRandomly(target)
+			fleet, s := playAI(NewSeededAI(difficulty, seed), target)
+			fleets, shots = append(fleets, fleet), append(shots, s)
+		}
+
+		if !reflect.DeepEqual(fleets[0], fleets[1]) {
+			t.Errorf("%v: seed 42 placed two different fleets", difficulty)
+		}
+		if !reflect.DeepEqual(shots[0], shots[1]) {
+			t.Errorf("%v: seed 42 fired %v, then %v", difficulty, shots[0], shots[1])
+		}
+		if reflect.DeepEqual(fleets[0], fleets[2]) || reflect.DeepEqual(shots[0], shots[2]) {
+			t.Errorf("%v: seeds 42 and 7 played the same game", difficulty)
+		}
+	}
+}
+
+func TestHardAIFiresCheckerboardFirst(t *testing.T) {
+	// Against an empty board the AI never hunts, so it covers the
+	// checkerboard before any other cell
+	ai := NewSeededAI(Hard, 42)
+	seen := make(map[[2]int]bool)
+	for i := 0; i < BoardSize*BoardSize; i++ {
+		row, col := ai.ChooseAttack()
+		if seen[[2]int{row, col}] {
+			t.Fatalf("shot %d fired at (%d, %d) again", i, row, col)
+		}
+		seen[[2]int{row, col}] = true
+		if onCheckerboard := (row+col)%2 == 0; onCheckerboard != (i < BoardSize*BoardSize/2) {
+			t.Fatalf("shot %d fired at (%d, %d), checkerboard %v", i, row, col, onCheckerboard)
+		}
+		ai.RecordMiss(row, col)
+	}
+}
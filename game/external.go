@@ -0,0 +1,276 @@
+package game
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBotTimeout is how long an external bot has to answer a request.
+const DefaultBotTimeout = 5 * time.Second
+
+// ExternalBot is an Opponent played by a separate program that speaks a
+// line-based protocol over its stdin and stdout (see BOTS.md).
+type ExternalBot struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string
+	exited  chan struct{}
+	waitErr error
+	timeout time.Duration
+
+	closeOnce sync.Once
+}
+
+// StartExternalBot runs command, split on spaces, as a bot. Each request
+// must be answered within timeout. The bot's stderr is copied to stderr,
+// which may be nil to discard it.
+func StartExternalBot(command string, timeout time.Duration, stderr io.Writer) (*ExternalBot, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("failed to start bot: empty command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start bot: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start bot: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start bot: %w", err)
+	}
+
+	b := &ExternalBot{
+		cmd:     cmd,
+		stdin:   stdin,
+		lines:   make(chan string, 16),
+		exited:  make(chan struct{}),
+		timeout: timeout,
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				b.lines <- line
+			}
+		}
+		close(b.lines)
+	}()
+	go func() {
+		// Wait closes stdout once the bot exits, even if a child process it
+		// started still holds it open
+		b.waitErr = cmd.Wait()
+		close(b.exited)
+	}()
+
+	return b, nil
+}
+
+// PlaceShips asks the bot for its fleet. The bot answers with one line per
+// ship: its name, the coordinate of its top or left end, and H or V.
+func (b *ExternalBot) PlaceShips(board *Board) error {
+	defs := ShipDefinitions()
+	request := []string{"PLACE"}
+	for _, ship := range defs {
+		request = append(request, fmt.Sprintf("%s:%d", ship.Name, ship.Length))
+	}
+	if err := b.send(strings.Join(request, " ")); err != nil {
+		return err
+	}
+
+	for range defs {
+		line, err := b.receive()
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("bot sent invalid placement %q", line)
+		}
+		var ship *Ship
+		for i, def := range defs {
+			if def != nil && strings.EqualFold(def.Name, fields[0]) {
+				ship = def
+				defs[i] = nil // Each ship is placed once
+				break
+			}
+		}
+		if ship == nil {
+			return fmt.Errorf("bot placed unknown or duplicate ship %q", fields[0])
+		}
+		row, col, err := ParseCoord(fields[1])
+		if err != nil {
+			return fmt.Errorf("bot sent invalid placement %q: %w", line, err)
+		}
+		var horizontal bool
+		switch strings.ToUpper(fields[2]) {
+		case "H":
+			horizontal = true
+		case "V":
+			horizontal = false
+		default:
+			return fmt.Errorf("bot sent invalid placement %q: direction must be H or V", line)
+		}
+		if !board.PlaceShip(ship, row, col, horizontal) {
+			return fmt.Errorf("bot placed %s off the board or over another ship", ship.Name)
+		}
+	}
+	return nil
+}
+
+// AssignFleet tells the bot where its ships were placed for it, in the same
+// format as its answers to PLACE, all on one line.
+func (b *ExternalBot) AssignFleet(board *Board) error {
+	request := []string{"FLEET"}
+	for _, ship := range board.Ships {
+		first, last := ship.Positions[0], ship.Positions[len(ship.Positions)-1]
+		direction := "V"
+		if first[0] == last[0] && len(ship.Positions) > 1 {
+			direction = "H"
+		}
+		request = append(request, ship.Name, FormatCoord(first[0], first[1]), direction)
+	}
+	return b.send(strings.Join(request, " "))
+}
+
+// ChooseAttack asks the bot where to fire.
+func (b *ExternalBot) ChooseAttack() (int, int, error) {
+	if err := b.send("FIRE"); err != nil {
+		return 0, 0, err
+	}
+	line, err := b.receive()
+	if err != nil {
+		return 0, 0, err
+	}
+	row, col, err := ParseCoord(line)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bot sent invalid attack %q: %w", line, err)
+	}
+	return row, col, nil
+}
+
+// RecordResult tells the bot the outcome of its last attack.
+func (b *ExternalBot) RecordResult(row, col int, hit bool, sunkShipName string) error {
+	return b.send("RESULT " + formatShot(row, col, hit, sunkShipName))
+}
+
+// RecordIncoming tells the bot about a shot fired at its board.
+func (b *ExternalBot) RecordIncoming(row, col int, hit bool, sunkShipName string) error {
+	return b.send("INCOMING " + formatShot(row, col, hit, sunkShipName))
+}
+
+// GameOver tells the bot whether it won.
+func (b *ExternalBot) GameOver(won bool) error {
+	if won {
+		return b.send("END WIN")
+	}
+	return b.send("END LOSE")
+}
+
+// Close closes the bot's stdin and waits briefly for it to exit, killing it
+// if it does not.
+func (b *ExternalBot) Close() error {
+	b.closeOnce.Do(func() {
+		b.stdin.Close()
+		go func() {
+			for range b.lines { // Unblock the reader so it can see the exit
+			}
+		}()
+		select {
+		case <-b.exited:
+		case <-time.After(b.timeout):
+			b.cmd.Process.Kill()
+			<-b.exited
+		}
+	})
+	return nil
+}
+
+// send writes a line to the bot.
+func (b *ExternalBot) send(line string) error {
+	if _, err := io.WriteString(b.stdin, line+"\n"); err != nil {
+		return b.exitError(err)
+	}
+	return nil
+}
+
+// receive reads the bot's next line, failing if it takes too long or the
+// bot exits.
+func (b *ExternalBot) receive() (string, error) {
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+
+	select {
+	case line, ok := <-b.lines:
+		if !ok {
+			select {
+			case <-b.exited:
+			case <-timer.C:
+			}
+			return "", b.exitError(io.EOF)
+		}
+		return line, nil
+	case <-timer.C:
+		return "", fmt.Errorf("bot did not answer within %v", b.timeout)
+	}
+}
+
+// exitError describes why talking to the bot failed, preferring the
+// process's exit status when it has exited.
+func (b *ExternalBot) exitError(err error) error {
+	select {
+	case <-b.exited:
+		if b.waitErr != nil {
+			return fmt.Errorf("bot crashed: %w", b.waitErr)
+		}
+		return errors.New("bot exited")
+	default:
+		return fmt.Errorf("failed to talk to bot: %w", err)
+	}
+}
+
+// formatShot describes a shot as used by RESULT and INCOMING.
+func formatShot(row, col int, hit bool, sunkShipName string) string {
+	switch {
+	case sunkShipName != "":
+		return FormatCoord(row, col) + " HIT SUNK " + sunkShipName
+	case hit:
+		return FormatCoord(row, col) + " HIT"
+	default:
+		return FormatCoord(row, col) + " MISS"
+	}
+}
+
+// FormatCoord returns the board coordinate of a cell, such as "B7": the
+// column letter followed by the row number, as labeled on screen.
+func FormatCoord(row, col int) string {
+	return fmt.Sprintf("%c%d", 'A'+col, row+1)
+}
+
+// ParseCoord parses a board coordinate such as "B7" or "j10".
+func ParseCoord(s string) (int, int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return 0, 0, fmt.Errorf("invalid coordinate %q", s)
+	}
+	col := int(s[0] - 'A')
+	row, err := strconv.Atoi(s[1:])
+	if err != nil || col < 0 || col >= BoardSize || row < 1 || row > BoardSize {
+		return 0, 0, fmt.Errorf("invalid coordinate %q", s)
+	}
+	return row - 1, col, nil
+}
@@ -0,0 +1,174 @@
+package game
+
+import (
+	"fmt"
+	"io"
+)
+
+// Opponent is a computer player: the built-in AI or an external bot.
+type Opponent interface {
+	// PlaceShips places the opponent's fleet on board.
+	PlaceShips(board *Board) error
+	// AssignFleet tells the opponent that its fleet was placed for it, as
+	// shown on board.
+	AssignFleet(board *Board) error
+	// ChooseAttack selects the next cell to fire at.
+	ChooseAttack() (row, col int, err error)
+	// RecordResult tells the opponent the outcome of its last attack.
+	RecordResult(row, col int, hit bool, sunkShipName string) error
+	// RecordIncoming tells the opponent about a shot fired at its board.
+	RecordIncoming(row, col int, hit bool, sunkShipName string) error
+	// GameOver tells the opponent whether it won.
+	GameOver(won bool) error
+	// Close releases any resources held by the opponent.
+	Close() error
+}
+
+// aiOpponent adapts the built-in AI to the Opponent interface.
+type aiOpponent struct {
+	ai *AI
+}
+
+// NewAIOpponent creates an Opponent backed by the built-in AI.
+func NewAIOpponent() Opponent {
+	return &aiOpponent{ai: NewAI()}
+}
+
+// NewSeededAIOpponent creates an Opponent backed by a seeded AI of the given
+// difficulty (see NewSeededAI).
+func NewSeededAIOpponent(difficulty Difficulty, seed int64) Opponent {
+	return &aiOpponent{ai: NewSeededAI(difficulty, seed)}
+}
+
+func (o *aiOpponent) PlaceShips(board *Board) error {
+	o.ai.PlaceShipsRandomly(board)
+	return nil
+}
+
+func (o *aiOpponent) AssignFleet(board *Board) error {
+	return nil
+}
+
+func (o *aiOpponent) ChooseAttack() (int, int, error) {
+	row, col := o.ai.ChooseAttack()
+	return row, col, nil
+}
+
+func (o *aiOpponent) RecordResult(row, col int, hit bool, sunkShipName string) error {
+	if hit {
+		o.ai.RecordHit(row, col)
+	} else {
+		o.ai.RecordMiss(row, col)
+	}
+	return nil
+}
+
+func (o *aiOpponent) RecordIncoming(row, col int, hit bool, sunkShipName string) error {
+	return nil
+}
+
+func (o *aiOpponent) GameOver(won bool) error {
+	return nil
+}
+
+func (o *aiOpponent) Close() error {
+	return nil
+}
+
+// MatchResult is the outcome of a game between two opponents.
+type MatchResult struct {
+	Winner int    // Index of the winning opponent
+	Shots  [2]int // Shots fired by each opponent
+	// Err is set when the loser forfeited by failing to play, for example
+	// by crashing, timing out or firing at the same cell twice.
+	Err error
+}
+
+// Match is a game between two opponents, Players[0] firing first.
+type Match struct {
+	Players [2]Opponent
+	// Boards are preset fleets for each player. A nil board asks the player
+	// to place its own ships.
+	Boards [2]*Board
+	// Names identify the players in Log and errors. They default to
+	// "player 1" and "player 2".
+	Names [2]string
+	// Log, if set, receives a line for every shot.
+	Log io.Writer
+}
+
+// PlayMatch plays a game between two opponents, players[0] firing first.
+func PlayMatch(players [2]Opponent) MatchResult {
+	return Match{Players: players}.Play()
+}
+
+// Play plays the match to the end.
+func (m Match) Play() MatchResult {
+	for i := range m.Names {
+		if m.Names[i] == "" {
+			m.Names[i] = fmt.Sprintf("player %d", i+1)
+		}
+	}
+	logf := func(format string, args ...any) {
+		if m.Log != nil {
+			fmt.Fprintf(m.Log, format+"\n", args...)
+		}
+	}
+
+	players := m.Players
+	var result MatchResult
+	forfeit := func(loser int, err error) MatchResult {
+		result.Winner = 1 - loser
+		result.Err = fmt.Errorf("%s forfeited: %w", m.Names[loser], err)
+		logf("%v", result.Err)
+		players[result.Winner].GameOver(true)
+		players[loser].GameOver(false)
+		return result
+	}
+
+	var boards [2]*Board
+	for i, p := range players {
+		if m.Boards[i] != nil {
+			boards[i] = m.Boards[i]
+			if err := p.AssignFleet(boards[i]); err != nil {
+				return forfeit(i, err)
+			}
+			continue
+		}
+		boards[i] = NewBoard()
+		if err := p.PlaceShips(boards[i]); err != nil {
+			return forfeit(i, err)
+		}
+	}
+
+	for turn := 0; ; turn = 1 - turn {
+		shooter, target := players[turn], players[1-turn]
+		board := boards[1-turn]
+
+		row, col, err := shooter.ChooseAttack()
+		if err != nil {
+			return forfeit(turn, err)
+		}
+		hit, alreadyAttacked, sunkShipName := board.Attack(row, col)
+		if alreadyAttacked {
+			return forfeit(turn, fmt.Errorf("invalid attack at %s", FormatCoord(row, col)))
+		}
+		result.Shots[turn]++
+		logf("%s: %s", m.Names[turn], formatShot(row, col, hit, sunkShipName))
+
+		if err := shooter.RecordResult(row, col, hit, sunkShipName); err != nil {
+			return forfeit(turn, err)
+		}
+		if err := target.RecordIncoming(row, col, hit, sunkShipName); err != nil {
+			return forfeit(1-turn, err)
+		}
+
+		if board.AllShipsSunk() {
+			result.Winner = turn
+			logf("%s wins", m.Names[turn])
+			shooter.GameOver(true)
+			target.GameOver(false)
+			return result
+		}
+	}
+}
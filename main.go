@@ -1,18 +1,92 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"time"
 
+	"battle-ship/game"
+	bnet "battle-ship/net"
 	"battle-ship/ui"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func main() {
-	p := tea.NewProgram(ui.NewModel(), tea.WithAltScreen())
+	botCommand := flag.String("bot", "", "external bot command to play against instead of the built-in AI")
+	botTimeout := flag.Duration("bot-timeout", game.DefaultBotTimeout, "time the external bot has to answer each request")
+	vsAI := flag.Int("bot-vs-ai", 0, "play this many games between the external bot and the built-in AI, without the TUI")
+	lanPort := flag.Int("lan-port", bnet.DefaultLANPort, "port to host LAN games on, and to join them on when no port is given")
+	serverAddress := flag.String("server", "", "multiplayer server: host[:port], ws://host:port/ws or tcp://host:port (default the central server)")
+	encodingName := flag.String("encoding", "json", "wire encoding to ask the multiplayer server for: json or msgpack")
+	flag.Parse()
+
+	encoding, err := bnet.ParseEncoding(*encodingName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if *vsAI > 0 {
+		if *botCommand == "" {
+			fmt.Println("-bot-vs-ai requires -bot")
+			os.Exit(2)
+		}
+		if err := playBotVsAI(*botCommand, *botTimeout, *vsAI); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	model := ui.NewModel()
+	model.BotCommand = *botCommand
+	model.BotTimeout = *botTimeout
+	model.LANPort = *lanPort
+	model.Encoding = encoding
+	if *serverAddress != "" {
+		model.ServerAddress = *serverAddress
+	}
+
+	p := tea.NewProgram(model, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running program: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// playBotVsAI plays games between an external bot and the built-in AI,
+// alternating who fires first, and prints each result.
+func playBotVsAI(command string, timeout time.Duration, games int) error {
+	wins := 0
+	for i := 0; i < games; i++ {
+		bot, err := game.StartExternalBot(command, timeout, os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		players := [2]game.Opponent{bot, game.NewAIOpponent()}
+		botIndex := 0
+		if i%2 == 1 {
+			players[0], players[1] = players[1], players[0]
+			botIndex = 1
+		}
+		result := game.PlayMatch(players)
+		bot.Close()
+
+		outcome := "lost"
+		if result.Winner == botIndex {
+			outcome = "won"
+			wins++
+		}
+		fmt.Printf("Game %d: bot %s in %d shots", i+1, outcome, result.Shots[botIndex])
+		if result.Err != nil {
+			fmt.Printf(" (%v)", result.Err)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Bot won %d of %d games\n", wins, games)
+	return nil
+}
@@ -0,0 +1,148 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	stdnet "net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DiscoveryPort is the UDP port LAN games are announced on.
+	DiscoveryPort = 4251
+	// announceInterval is how often a host announces its game.
+	announceInterval = time.Second
+	// announceExpiry is how long a game is listed after its last
+	// announcement.
+	announceExpiry = 3 * announceInterval
+
+	// announceGame tells announcements of this game apart from other
+	// programs' traffic on the discovery port.
+	announceGame = "battle-ship"
+)
+
+// LANGame is a game hosted on the local network, as announced by its host.
+type LANGame struct {
+	Name  string `json:"name"`
+	Rules string `json:"rules"`
+	Port  int    `json:"port"`
+	// Address is the host and port to join, taken from where the
+	// announcement came from.
+	Address string `json:"-"`
+}
+
+// announcement is the datagram a host broadcasts.
+type announcement struct {
+	Game string `json:"game"`
+	LANGame
+}
+
+// announce broadcasts game on the local network every announceInterval,
+// while waiting reports it still needs a player, until stop is closed.
+func announce(game LANGame, stop <-chan struct{}, waiting func() bool) {
+	data, err := json.Marshal(announcement{Game: announceGame, LANGame: game})
+	if err != nil {
+		return
+	}
+	conn, err := stdnet.ListenUDP("udp4", nil)
+	if err != nil {
+		return // Joining by address still works
+	}
+	defer conn.Close()
+
+	broadcast := &stdnet.UDPAddr{IP: stdnet.IPv4bcast, Port: DiscoveryPort}
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		if waiting() {
+			conn.WriteToUDP(data, broadcast) // Best effort; the next one may get through
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// LANFinder listens for games announced on the local network.
+type LANFinder struct {
+	conn *stdnet.UDPConn
+
+	mu       sync.Mutex
+	games    map[string]LANGame // By address
+	lastSeen map[string]time.Time
+}
+
+// FindLANGames starts listening for announced games. Only one program on a
+// machine can listen at a time.
+func FindLANGames() (*LANFinder, error) {
+	conn, err := stdnet.ListenUDP("udp4", &stdnet.UDPAddr{Port: DiscoveryPort})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for LAN games: %w", err)
+	}
+
+	f := &LANFinder{
+		conn:     conn,
+		games:    make(map[string]LANGame),
+		lastSeen: make(map[string]time.Time),
+	}
+	go f.readLoop()
+	return f, nil
+}
+
+func (f *LANFinder) readLoop() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return // Closed
+		}
+
+		var a announcement
+		if err := json.Unmarshal(buf[:n], &a); err != nil || a.Game != announceGame {
+			continue
+		}
+		if a.Port <= 0 || a.Port > 65535 {
+			continue
+		}
+		game := a.LANGame
+		game.Address = stdnet.JoinHostPort(from.IP.String(), strconv.Itoa(game.Port))
+
+		f.mu.Lock()
+		f.games[game.Address] = game
+		f.lastSeen[game.Address] = time.Now()
+		f.mu.Unlock()
+	}
+}
+
+// Games returns the games announced recently, sorted by name.
+func (f *LANFinder) Games() []LANGame {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var games []LANGame
+	for address, game := range f.games {
+		if time.Since(f.lastSeen[address]) > announceExpiry {
+			delete(f.games, address)
+			delete(f.lastSeen, address)
+			continue
+		}
+		games = append(games, game)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Name != games[j].Name {
+			return games[i].Name < games[j].Name
+		}
+		return games[i].Address < games[j].Address
+	})
+	return games
+}
+
+// Close stops listening.
+func (f *LANFinder) Close() error {
+	return f.conn.Close()
+}
@@ -0,0 +1,59 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Encoding is how messages are written on the wire.
+type Encoding string
+
+const (
+	// EncodingJSON writes each message as a JSON object. It is the default,
+	// and what every client and server speaks.
+	EncodingJSON Encoding = "json"
+	// EncodingMsgPack writes each message as a MessagePack map holding the
+	// same fields as the JSON object, for bots and spectators that want
+	// smaller messages.
+	EncodingMsgPack Encoding = "msgpack"
+)
+
+// MsgPackSubprotocol is the WebSocket subprotocol a client offers to
+// exchange MessagePack in binary frames. A server that doesn't accept it
+// keeps speaking JSON.
+const MsgPackSubprotocol = "battleship.msgpack"
+
+// ParseEncoding returns the encoding called name.
+func ParseEncoding(name string) (Encoding, error) {
+	switch Encoding(name) {
+	case "", EncodingJSON:
+		return EncodingJSON, nil
+	case EncodingMsgPack:
+		return EncodingMsgPack, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q (want json or msgpack)", name)
+	}
+}
+
+// Marshal encodes msg.
+func (e Encoding) Marshal(msg *Message) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil || e != EncodingMsgPack {
+		return data, err
+	}
+	return JSONToMsgPack(data)
+}
+
+// Unmarshal decodes data into msg.
+func (e Encoding) Unmarshal(data []byte, msg *Message) error {
+	if e == EncodingMsgPack {
+		var err error
+		if data, err = MsgPackToJSON(data); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return fmt.Errorf("failed to decode message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package net
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Identity is the persistent player identity sent to the server in hello.
+type Identity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Secrets holds the secret each server issued for ID, by server
+	// address, so a secret is only ever sent back to the server that issued
+	// it.
+	Secrets map[string]string `json:"secrets,omitempty"`
+}
+
+// LoadIdentity reads the player's identity from the user config directory,
+// creating and saving a new one on first run.
+func LoadIdentity() (Identity, error) {
+	path, err := identityPath()
+	if err != nil {
+		return NewIdentity(), err
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		var id Identity
+		if err := json.Unmarshal(data, &id); err == nil && id.ID != "" {
+			return id, nil
+		}
+	}
+
+	id := NewIdentity()
+	return id, SaveIdentity(id)
+}
+
+// SaveIdentity writes the player's identity to the user config directory.
+func SaveIdentity(id Identity) error {
+	path, err := identityPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal identity: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save identity: %w", err)
+	}
+	return nil
+}
+
+func identityPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config dir: %w", err)
+	}
+	return filepath.Join(dir, "battle-ship", "identity.json"), nil
+}
+
+// NewIdentity creates a random identity named after the current user.
+func NewIdentity() Identity {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	name := os.Getenv("USER")
+	if name == "" {
+		name = os.Getenv("USERNAME")
+	}
+	if name == "" {
+		name = "Player"
+	}
+
+	return Identity{ID: hex.EncodeToString(b), Name: name}
+}
+
+// PublicPlayerID returns the name a player ID is published under, as on the
+// leaderboard. It can't be turned back into the ID, which only its player
+// should know. An empty ID stays empty.
+func PublicPlayerID(id string) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("battle-ship player " + id))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,112 @@
+package net
+
+import (
+	"fmt"
+	"log"
+	stdnet "net"
+	"sync"
+)
+
+// DefaultLANPort is the TCP port LAN games are hosted on unless another is
+// chosen.
+const DefaultLANPort = 4250
+
+// lanHost is a game hosted on the local network, served by a LocalServer
+// whose one room is known by the host's address.
+type lanHost struct {
+	listener stdnet.Listener
+	server   *LocalServer
+
+	mu     sync.Mutex
+	conns  map[stdnet.Conn]bool
+	closed bool
+}
+
+// HostLAN starts hosting a game on port, or on a free port if port is 0,
+// and returns the hosting player's connection to it. The connection answers
+// create_room with the address the guest should join, and the game is
+// announced to the local network as game until a guest joins. Closing the
+// connection stops hosting and disconnects the guest.
+func HostLAN(port int, game LANGame) (*Connection, error) {
+	listener, err := stdnet.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to host LAN game: %w", err)
+	}
+	game.Port = listener.Addr().(*stdnet.TCPAddr).Port
+	address := LANAddress(game.Port)
+	log.Printf("Hosting LAN game at %s", address)
+
+	server := NewLocalServer(func() string { return address })
+	server.joinAny = true // Guests may know the host by another address
+	h := &lanHost{listener: listener, server: server, conns: make(map[stdnet.Conn]bool)}
+
+	local, remote := Pipe(64)
+	stop := make(chan struct{})
+	go announce(game, stop, server.Waiting)
+	go h.acceptLoop()
+	go func() {
+		server.Serve(remote) // Until the hosting player leaves
+		close(stop)
+		h.close()
+	}()
+	return NewConnection(local), nil
+}
+
+// LANAddress returns the address other machines on the local network can
+// reach port on: the first private IPv4 address of this machine, else its
+// first other non-loopback IPv4 address, else localhost.
+func LANAddress(port int) string {
+	host := "localhost"
+	if addrs, err := stdnet.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*stdnet.IPNet)
+			if !ok || ipNet.IP.To4() == nil || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ipNet.IP.IsPrivate() {
+				host = ipNet.IP.String()
+				break
+			}
+			if host == "localhost" {
+				host = ipNet.IP.String()
+			}
+		}
+	}
+	return stdnet.JoinHostPort(host, fmt.Sprint(port))
+}
+
+// acceptLoop serves would-be guests until the host stops.
+func (h *lanHost) acceptLoop() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return // Closed
+		}
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			conn.Close()
+			return
+		}
+		h.conns[conn] = true
+		h.mu.Unlock()
+
+		go func() {
+			h.server.Serve(NewStreamTransport(conn, EncodingJSON))
+			h.mu.Lock()
+			delete(h.conns, conn)
+			h.mu.Unlock()
+		}()
+	}
+}
+
+// close stops accepting guests and disconnects those connected.
+func (h *lanHost) close() {
+	h.listener.Close()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for conn := range h.conns {
+		conn.Close()
+	}
+}
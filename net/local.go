@@ -0,0 +1,281 @@
+package net
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxLocalChatLength matches the central server's limit on chat messages.
+const maxLocalChatLength = 200
+
+// LocalServer plays the central server's part for clients handed to it
+// directly: players in the same process over pipes, or on the local network
+// over TCP. It pairs players into rooms by code and relays their games, and
+// both players speak the same protocol they would with the central server.
+// Timers, ratings, spectators and server bots are left out.
+type LocalServer struct {
+	newCode func() string
+	joinAny bool
+
+	mu    sync.Mutex
+	rooms map[string]*localRoom
+}
+
+// localClient is a client of a LocalServer.
+type localClient struct {
+	t            Transport
+	name         string
+	room         *localRoom
+	role         string
+	wantsRematch bool
+	wins         int
+}
+
+// localRoom is a game between two clients of a LocalServer.
+type localRoom struct {
+	code        string
+	host        *localClient
+	guest       *localClient
+	finished    bool
+	gamesPlayed int
+}
+
+// NewLocalServer creates a server whose rooms get codes from newCode.
+func NewLocalServer(newCode func() string) *LocalServer {
+	return &LocalServer{newCode: newCode, rooms: make(map[string]*localRoom)}
+}
+
+// Connect returns the client end of a new in-memory connection to s.
+func (s *LocalServer) Connect() Transport {
+	client, server := Pipe(64)
+	go s.Serve(server)
+	return client
+}
+
+// Serve handles the messages of the client at the other end of t until it
+// disconnects, and then closes t.
+func (s *LocalServer) Serve(t Transport) {
+	client := &localClient{t: t}
+	defer t.Close()
+	for {
+		msg, err := t.ReadMessage()
+		if err != nil {
+			s.leave(client)
+			return
+		}
+		s.mu.Lock()
+		s.handle(client, msg)
+		s.mu.Unlock()
+	}
+}
+
+// Waiting reports whether a room is waiting for a guest.
+func (s *LocalServer) Waiting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, room := range s.rooms {
+		if room.guest == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// handle handles a message from client. Must be called with s.mu held.
+func (s *LocalServer) handle(client *localClient, msg *Message) {
+	switch msg.Type {
+	case MsgHello:
+		var hello HelloPayload
+		if json.Unmarshal(msg.Payload, &hello) == nil {
+			client.name = hello.Name
+		}
+		return
+	case MsgCreateRoom:
+		if client.room == nil {
+			s.createRoom(client)
+		}
+		return
+	case MsgJoinRoom:
+		var payload JoinRoomPayload
+		if client.room == nil && json.Unmarshal(msg.Payload, &payload) == nil {
+			s.joinRoom(client, payload.Code)
+		}
+		return
+	case MsgCreateBotRoom, MsgSpectateRoom, MsgGetLeaderboard:
+		send(client.t, MsgJoinError, ErrorPayload{Message: "Not available on this server"})
+		return
+	}
+
+	room := client.room
+	if room == nil {
+		return
+	}
+	opponent := room.opponent(client)
+	switch msg.Type {
+	case MsgShipsPlaced, MsgAttack, MsgAttackResult:
+		if opponent != nil {
+			opponent.t.WriteMessage(msg)
+		}
+	case MsgGameOver:
+		if opponent == nil {
+			return
+		}
+		opponent.t.WriteMessage(msg)
+		var payload GameOverPayload
+		if json.Unmarshal(msg.Payload, &payload) == nil && payload.YouWon && !room.finished {
+			room.finished = true
+			opponent.wins++
+		}
+	case MsgChat:
+		room.chat(client, msg)
+	case MsgRematchRequest:
+		if !room.finished || opponent == nil {
+			return
+		}
+		client.wantsRematch = true
+		send(opponent.t, MsgRematchRequest, struct{}{})
+		if opponent.wantsRematch {
+			room.startRematch()
+		}
+	case MsgRematchDecline:
+		client.wantsRematch = false
+		if opponent != nil {
+			opponent.wantsRematch = false
+			send(opponent.t, MsgRematchDecline, struct{}{})
+		}
+	}
+}
+
+// createRoom opens a room hosted by client. Must be called with s.mu held.
+func (s *LocalServer) createRoom(client *localClient) {
+	code := s.newCode()
+	if _, taken := s.rooms[code]; taken {
+		send(client.t, MsgJoinError, ErrorPayload{Message: "Could not create a room, try again"})
+		return
+	}
+	room := &localRoom{code: code, host: client}
+	s.rooms[code] = room
+	client.room = room
+	client.role = RoleHost
+	client.wins = 0
+	send(client.t, MsgRoomCreated, CreateRoomResponse{Code: code})
+}
+
+// joinRoom makes client the guest of the room with code, or, for a server
+// that joins any room, of the room waiting for one. Must be called with s.mu
+// held.
+func (s *LocalServer) joinRoom(client *localClient, code string) {
+	room := s.rooms[code]
+	if room == nil && s.joinAny {
+		for _, r := range s.rooms {
+			if r.guest == nil {
+				room = r
+				break
+			}
+		}
+	}
+	if room == nil {
+		send(client.t, MsgJoinError, ErrorPayload{Message: "Room not found"})
+		return
+	}
+	if room.guest != nil {
+		send(client.t, MsgJoinError, ErrorPayload{Message: "Room is full"})
+		return
+	}
+
+	room.guest = client
+	client.room = room
+	client.role = RoleGuest
+	client.wins = 0
+	send(client.t, MsgGameStart, struct{}{})
+	send(room.host.t, MsgPlayerJoined, struct{}{})
+}
+
+// leave handles client disconnecting. The game in its room ends.
+func (s *LocalServer) leave(client *localClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room := client.room
+	if room == nil {
+		return
+	}
+	client.room = nil
+	delete(s.rooms, room.code)
+	if opponent := room.opponent(client); opponent != nil {
+		opponent.room = nil
+		send(opponent.t, MsgOpponentLeft, struct{}{})
+	}
+}
+
+// opponent returns the other player in the room, if there is one yet.
+func (r *localRoom) opponent(client *localClient) *localClient {
+	if client == r.host {
+		return r.guest
+	}
+	return r.host
+}
+
+// chat sends a chat message from client to both players, like the central
+// server does.
+func (r *localRoom) chat(client *localClient, msg *Message) {
+	var payload ChatPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return
+	}
+	text := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, payload.Text))
+	if text == "" {
+		return
+	}
+	if len([]rune(text)) > maxLocalChatLength {
+		send(client.t, MsgChatRejected, ErrorPayload{Message: "Message too long"})
+		return
+	}
+
+	name := client.name
+	if name == "" {
+		name = client.role
+	}
+	chat := ChatPayload{From: name, Role: client.role, Text: text, SentAt: time.Now()}
+	for _, c := range []*localClient{r.host, r.guest} {
+		if c != nil {
+			send(c.t, MsgChat, chat)
+		}
+	}
+}
+
+// startRematch starts the next game of the series, alternating who moves
+// first.
+func (r *localRoom) startRematch() {
+	r.gamesPlayed++
+	r.finished = false
+	r.host.wantsRematch = false
+	r.guest.wantsRematch = false
+
+	payload := RematchStartPayload{
+		Game:           r.gamesPlayed + 1,
+		HostMovesFirst: r.gamesPlayed%2 == 0,
+		HostWins:       r.host.wins,
+		GuestWins:      r.guest.wins,
+	}
+	send(r.host.t, MsgRematchStart, payload)
+	send(r.guest.t, MsgRematchStart, payload)
+}
+
+// send writes a message with payload to t. Errors are left to t's reader,
+// which sees the connection fail too.
+func send(t Transport, msgType MessageType, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	t.WriteMessage(&Message{Type: msgType, Payload: data})
+}
@@ -0,0 +1,420 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// MessagePack is written by transcoding a message's JSON, and read by
+// transcoding back to JSON, so both encodings carry exactly the same values
+// and the protocol types need no second set of tags. Only the types JSON
+// has are used: nil, booleans, integers, floats, strings, arrays and maps
+// with string keys.
+
+// maxMsgPackDepth limits how deeply arrays and maps may nest in a message.
+const maxMsgPackDepth = 64
+
+var (
+	// ErrMessageTooLarge is returned when reading a message longer than
+	// allowed.
+	ErrMessageTooLarge = errors.New("message too large")
+
+	errMsgPackTooDeep = errors.New("MessagePack value nested too deeply")
+)
+
+// JSONToMsgPack transcodes one JSON value to MessagePack.
+func JSONToMsgPack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	out, err := appendJSONValue(nil, dec, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode MessagePack: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("failed to encode MessagePack: data after the JSON value")
+	}
+	return out, nil
+}
+
+// MsgPackToJSON transcodes one MessagePack value to JSON.
+func MsgPackToJSON(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	d := msgPackDecoder{r: r, left: len(data)}
+	out, err := d.appendValue(nil, 0)
+	if errors.Is(err, ErrMessageTooLarge) {
+		err = io.ErrUnexpectedEOF // Ran past the end of data
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MessagePack: %w", err)
+	}
+	if r.Len() > 0 {
+		return nil, errors.New("failed to decode MessagePack: data after the value")
+	}
+	return out, nil
+}
+
+// ReadMsgPack reads the next MessagePack value of at most maxSize bytes from
+// a stream and returns it as JSON. It returns io.EOF if the stream ends
+// before the value starts.
+func ReadMsgPack(r *bufio.Reader, maxSize int) ([]byte, error) {
+	if _, err := r.Peek(1); err != nil {
+		return nil, err
+	}
+	d := msgPackDecoder{r: r, left: maxSize}
+	out, err := d.appendValue(nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MessagePack: %w", err)
+	}
+	return out, nil
+}
+
+// IsMsgPackMap reports whether b starts a MessagePack map, as every
+// MessagePack message does. No JSON message starts with such a byte.
+func IsMsgPackMap(b byte) bool {
+	return b&0xf0 == 0x80 || b == 0xde || b == 0xdf
+}
+
+// appendJSONValue appends the next value of dec to out as MessagePack.
+func appendJSONValue(out []byte, dec *json.Decoder, depth int) ([]byte, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := token.(type) {
+	case json.Delim:
+		if depth >= maxMsgPackDepth {
+			return nil, errMsgPackTooDeep
+		}
+		// Headers hold the number of elements, so encode those first
+		var body []byte
+		n := 0
+		for dec.More() {
+			if v == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				body = appendMsgPackString(body, key.(string))
+			}
+			if body, err = appendJSONValue(body, dec, depth+1); err != nil {
+				return nil, err
+			}
+			n++
+		}
+		if _, err := dec.Token(); err != nil { // The closing delimiter
+			return nil, err
+		}
+		if v == '{' {
+			out = appendMsgPackHeader(out, n, 0x80, 0xde)
+		} else {
+			out = appendMsgPackHeader(out, n, 0x90, 0xdc)
+		}
+		return append(out, body...), nil
+	case string:
+		return appendMsgPackString(out, v), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMsgPackInt(out, i), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendMsgPackUint(out, u), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(out, 0xcb), math.Float64bits(f)), nil
+	case bool:
+		if v {
+			return append(out, 0xc3), nil
+		}
+		return append(out, 0xc2), nil
+	default: // null
+		return append(out, 0xc0), nil
+	}
+}
+
+// appendMsgPackHeader appends the header of an array or map of n elements:
+// fix | n if it fits, else 16-bit, else 32-bit, whose type bytes follow
+// long's.
+func appendMsgPackHeader(out []byte, n int, fix, long byte) []byte {
+	switch {
+	case n < 16:
+		return append(out, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(out, long), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(out, long+1), uint32(n))
+	}
+}
+
+func appendMsgPackString(out []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		out = append(out, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		out = append(out, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		out = binary.BigEndian.AppendUint16(append(out, 0xda), uint16(n))
+	default:
+		out = binary.BigEndian.AppendUint32(append(out, 0xdb), uint32(n))
+	}
+	return append(out, s...)
+}
+
+func appendMsgPackInt(out []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgPackUint(out, uint64(i))
+	case i >= -32:
+		return append(out, byte(i)) // Negative fixint
+	case i >= math.MinInt8:
+		return append(out, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(out, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(out, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(out, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgPackUint(out []byte, u uint64) []byte {
+	switch {
+	case u < 128:
+		return append(out, byte(u)) // Positive fixint
+	case u <= math.MaxUint8:
+		return append(out, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(out, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(out, 0xce), uint32(u))
+	default:
+		return binary.BigEndian.AppendUint64(append(out, 0xcf), u)
+	}
+}
+
+// msgPackDecoder transcodes MessagePack read from r to JSON, reading no more
+// than left bytes.
+type msgPackDecoder struct {
+	r interface {
+		io.Reader
+		io.ByteReader
+	}
+	left int
+}
+
+func (d *msgPackDecoder) readByte() (byte, error) {
+	if d.left < 1 {
+		return 0, ErrMessageTooLarge
+	}
+	d.left--
+	b, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func (d *msgPackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || n > d.left {
+		return nil, ErrMessageTooLarge
+	}
+	d.left -= n
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readUint reads a big-endian unsigned integer of size bytes.
+func (d *msgPackDecoder) readUint(size int) (uint64, error) {
+	buf, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, b := range buf {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+// appendValue appends the next value to out as JSON.
+func (d *msgPackDecoder) appendValue(out []byte, depth int) ([]byte, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b < 0x80: // Positive fixint
+		return strconv.AppendInt(out, int64(b), 10), nil
+	case b >= 0xe0: // Negative fixint
+		return strconv.AppendInt(out, int64(int8(b)), 10), nil
+	case b&0xf0 == 0x80:
+		return d.appendMap(out, int(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return d.appendArray(out, int(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return d.appendString(out, int(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return append(out, "null"...), nil
+	case 0xc2:
+		return append(out, "false"...), nil
+	case 0xc3:
+		return append(out, "true"...), nil
+	case 0xca:
+		u, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONFloat(out, float64(math.Float32frombits(uint32(u))), 32)
+	case 0xcb:
+		u, err := d.readUint(8)
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONFloat(out, math.Float64frombits(u), 64)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendUint(out, u, 10), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		u, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size // Sign-extend
+		return strconv.AppendInt(out, int64(u<<shift)>>shift, 10), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.appendString(out, int(n))
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.appendArray(out, int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.appendMap(out, int(n), depth)
+	default: // Binary, extension types and the unused 0xc1
+		return nil, fmt.Errorf("unsupported MessagePack type 0x%02x", b)
+	}
+}
+
+func (d *msgPackDecoder) appendString(out []byte, n int) ([]byte, error) {
+	buf, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	s, err := json.Marshal(string(buf))
+	if err != nil {
+		return nil, err
+	}
+	return append(out, s...), nil
+}
+
+// appendArray appends an array of n elements. Every element takes at least
+// a byte, so a large n runs into the size limit rather than a long loop.
+func (d *msgPackDecoder) appendArray(out []byte, n, depth int) ([]byte, error) {
+	if depth >= maxMsgPackDepth {
+		return nil, errMsgPackTooDeep
+	}
+	out = append(out, '[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		var err error
+		if out, err = d.appendValue(out, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return append(out, ']'), nil
+}
+
+// appendMap appends a map of n entries, whose keys must be strings.
+func (d *msgPackDecoder) appendMap(out []byte, n, depth int) ([]byte, error) {
+	if depth >= maxMsgPackDepth {
+		return nil, errMsgPackTooDeep
+	}
+	out = append(out, '{')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		var size int
+		switch {
+		case b&0xe0 == 0xa0:
+			size = int(b & 0x1f)
+		case b >= 0xd9 && b <= 0xdb:
+			n, err := d.readUint(1 << (b - 0xd9))
+			if err != nil {
+				return nil, err
+			}
+			size = int(n)
+		default:
+			return nil, errors.New("MessagePack map key is not a string")
+		}
+		if out, err = d.appendString(out, size); err != nil {
+			return nil, err
+		}
+		out = append(out, ':')
+		if out, err = d.appendValue(out, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return append(out, '}'), nil
+}
+
+// appendJSONFloat appends f as encoding/json would, so whole numbers can be
+// decoded into integer fields.
+func appendJSONFloat(out []byte, f float64, bits int) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("unsupported MessagePack float %v", f)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	start := len(out)
+	out = strconv.AppendFloat(out, f, format, -1, bits)
+	if format == 'e' {
+		// Like encoding/json, write e-07 as e-7
+		if b := out[start:]; len(b) >= 4 && b[len(b)-4] == 'e' && b[len(b)-3] == '-' && b[len(b)-2] == '0' {
+			b[len(b)-2] = b[len(b)-1]
+			out = out[:len(out)-1]
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,163 @@
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// bufferConn is a stream that reads back what was written to it.
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (*bufferConn) Close() error {
+	return nil
+}
+
+// payloads holds a value of every payload type, covering integers at the
+// edges of each MessagePack size, floats, times, nil and empty slices,
+// omitted and set omitempty fields and nested positions.
+var payloads = []struct {
+	msgType MessageType
+	payload any
+}{
+	{MsgRoomCreated, &CreateRoomResponse{Code: "ABCD"}},
+	{MsgCreateRoom, &CreateRoomPayload{Private: true}},
+	{MsgJoinRoom, &JoinRoomPayload{Code: "<q&a>"}},
+	{MsgJoinError, &ErrorPayload{Message: "Room \"ABCD\" is full\n" + string(make([]byte, 40))}},
+	{MsgShipsPlaced, &ShipsPlacedPayload{}},
+	{MsgShipsPlaced, &ShipsPlacedPayload{Fleet: []ShipPlacement{
+		{Name: "Carrier", Positions: [][2]int{{0, 0}, {0, 1}, {0, 2}, {0, 3}, {0, 4}}},
+		{Name: "Nowhere", Positions: [][2]int{}},
+		{Name: "Lost"},
+	}}},
+	{MsgAttack, &AttackPayload{Row: 0, Col: 9}},
+	{MsgAttack, &AttackPayload{Row: -1, Col: -33}},
+	{MsgAttackResult, &AttackResultPayload{Row: 127, Col: 128, Hit: true, SunkShipName: "Destroyer"}},
+	{MsgAttackResult, &AttackResultPayload{Row: 255, Col: 256}},
+	{MsgGameOver, &GameOverPayload{YouWon: true}},
+	{MsgHello, &HelloPayload{PlayerID: "p-1", Name: "ünïcode ✓"}},
+	{MsgHello, &HelloPayload{PlayerID: "p-1", Name: "ann", Secret: "s3cret"}},
+	{MsgWelcome, &WelcomePayload{}},
+	{MsgLeaderboard, &LeaderboardPayload{}},
+	{MsgLeaderboard, &LeaderboardPayload{Entries: []LeaderboardEntry{}}},
+	{MsgLeaderboard, &LeaderboardPayload{Entries: []LeaderboardEntry{
+		{Rank: 1, Player: "abc", Name: "ann", Rating: 1500, Wins: 65535, Losses: 65536},
+		{Rank: 2, Player: "def", Name: "bob", Rating: 1487.125, Wins: math.MaxInt32, Losses: math.MaxInt32 + 1},
+		{Rank: 3, Player: "ghi", Name: "cy", Rating: -0.5e-7, Wins: math.MaxInt64, Losses: math.MinInt64},
+		{Rank: 4, Player: "jkl", Name: "di", Rating: 1.5e300, Wins: math.MinInt8, Losses: math.MinInt16 - 1},
+	}}},
+	{MsgSpectatorShot, &SpectatorShotPayload{Shooter: "host", Row: 3, Col: 4, Hit: true, SunkShipName: "Submarine"}},
+	{MsgFleetReveal, &FleetRevealPayload{Host: []ShipPlacement{{Name: "Destroyer", Positions: [][2]int{{9, 8}, {9, 9}}}}}},
+	{MsgSpectatorCount, &SpectatorCountPayload{Count: 70000}},
+	{MsgSpectateState, &SpectateStatePayload{Code: "ABCD", HostName: "ann"}},
+	{MsgSpectateState, &SpectateStatePayload{
+		Code:       "ABCD",
+		HostName:   "ann",
+		GuestName:  "bob",
+		Spectators: 2,
+		Shots:      []SpectatorShotPayload{{Shooter: "guest", Row: 1, Col: 2}},
+		HostFleet:  []ShipPlacement{{Name: "Destroyer", Positions: [][2]int{{1, 2}, {1, 3}}}},
+		GuestFleet: []ShipPlacement{},
+		Winner:     "guest",
+	}},
+	{MsgSpectateGameOver, &SpectateGameOverPayload{}},
+	{MsgRematchStart, &RematchStartPayload{Game: 2, HostMovesFirst: true, HostWins: 1}},
+	{MsgTurnTimer, &TimerPayload{Role: "host", RemainingMs: 29999}},
+	{MsgPlacementTimer, &TimerPayload{RemainingMs: math.MaxUint32 + 1}},
+	{MsgTurnTimeout, &TurnTimeoutPayload{Role: "guest", Action: "random", Timeouts: 2, Row: 5, Col: 6}}, // The server's TimeoutRandom
+	{MsgChat, &ChatPayload{Text: "hi"}},
+	{MsgChat, &ChatPayload{From: "ann", Role: "host", Text: "gg", SentAt: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)}},
+}
+
+// decodePayload decodes the payload of msg into a new value of the type of
+// like.
+func decodePayload(t *testing.T, msg *Message, like any) any {
+	t.Helper()
+	v := reflect.New(reflect.TypeOf(like).Elem()).Interface()
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		t.Fatalf("failed to decode %s payload %s: %v", msg.Type, msg.Payload, err)
+	}
+	return v
+}
+
+func TestEncodingsCarrySamePayloads(t *testing.T) {
+	for _, tt := range payloads {
+		data, err := json.Marshal(tt.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &Message{Type: tt.msgType, Payload: data}
+
+		encoded, err := EncodingMsgPack.Marshal(msg)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", tt.msgType, err)
+		}
+		if !IsMsgPackMap(encoded[0]) {
+			t.Errorf("%s encoded as MessagePack starts with 0x%02x, not a map", tt.msgType, encoded[0])
+		}
+		var got Message
+		if err := EncodingMsgPack.Unmarshal(encoded, &got); err != nil {
+			t.Fatalf("failed to decode %s: %v", tt.msgType, err)
+		}
+		if got.Type != tt.msgType || !bytes.Equal(got.Payload, data) {
+			t.Errorf("%s payload came back from MessagePack as %s, want %s", tt.msgType, got.Payload, data)
+		}
+		if want, got := decodePayload(t, msg, tt.payload), decodePayload(t, &got, tt.payload); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s decoded from MessagePack as %+v, from JSON as %+v", tt.msgType, got, want)
+		}
+	}
+}
+
+func TestStreamTransportRoundTrip(t *testing.T) {
+	for _, encoding := range []Encoding{EncodingJSON, EncodingMsgPack} {
+		conn := NewConnection(NewStreamTransport(&bufferConn{}, encoding))
+		for _, tt := range payloads {
+			if err := conn.Send(tt.msgType, tt.payload); err != nil {
+				t.Fatalf("%s: failed to send %s: %v", encoding, tt.msgType, err)
+			}
+		}
+		for _, tt := range payloads {
+			msg, err := conn.Receive()
+			if err != nil {
+				t.Fatalf("%s: failed to receive %s: %v", encoding, tt.msgType, err)
+			}
+			if msg.Type != tt.msgType {
+				t.Fatalf("%s: received %s, want %s", encoding, msg.Type, tt.msgType)
+			}
+			if got := decodePayload(t, msg, tt.payload); !reflect.DeepEqual(got, tt.payload) && !emptiedByOmitempty(tt.payload) {
+				t.Errorf("%s: %s payload came back as %+v, want %+v", encoding, tt.msgType, got, tt.payload)
+			}
+		}
+	}
+}
+
+// emptiedByOmitempty reports whether payload has an empty slice that JSON
+// omits, so it decodes as nil rather than as itself.
+func emptiedByOmitempty(payload any) bool {
+	state, ok := payload.(*SpectateStatePayload)
+	return ok && state.GuestFleet != nil && len(state.GuestFleet) == 0
+}
+
+func TestMsgPackFloats(t *testing.T) {
+	// Floats MessagePack encodes as 32 bits come back as JSON numbers too
+	tests := []struct {
+		msgpack []byte
+		want    string
+	}{
+		{[]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, "1.5"},
+		{[]byte{0xca, 0x44, 0x7a, 0x00, 0x00}, "1000"},
+		{[]byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}, "0.1"},
+		{[]byte{0xcb, 0x44, 0x4b, 0x1a, 0xe4, 0xd6, 0xe2, 0xef, 0x50}, "1e+21"},
+	}
+	for _, tt := range tests {
+		got, err := MsgPackToJSON(tt.msgpack)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("MsgPackToJSON(% x) = %s, %v; want %s", tt.msgpack, got, err, tt.want)
+		}
+	}
+}
@@ -5,8 +5,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
-
-	"github.com/gorilla/websocket"
+	"time"
 )
 
 // MessageType identifies the type of network message
@@ -27,6 +26,53 @@ const (
 	MsgJoinError    MessageType = "join_error"
 	MsgGameStart    MessageType = "game_start"
 	MsgOpponentLeft MessageType = "opponent_left"
+	MsgRoomExpired  MessageType = "room_expired"
+	MsgNotice       MessageType = "notice"
+
+	// Server-hosted AI opponent
+	MsgCreateBotRoom MessageType = "create_bot_room"
+
+	// Identity and ratings
+	MsgHello          MessageType = "hello"
+	MsgWelcome        MessageType = "welcome"
+	MsgHelloError     MessageType = "hello_error" // The hello was refused
+	MsgGetLeaderboard MessageType = "get_leaderboard"
+	MsgLeaderboard    MessageType = "leaderboard"
+
+	// Spectator Messages
+	MsgSpectateRoom     MessageType = "spectate_room"
+	MsgSpectateState    MessageType = "spectate_state"
+	MsgSpectatorShot    MessageType = "spectator_shot"
+	MsgFleetReveal      MessageType = "fleet_reveal"
+	MsgSpectatorCount   MessageType = "spectator_count"
+	MsgSpectateGameOver MessageType = "spectate_game_over"
+
+	// Chat Messages
+	MsgChat         MessageType = "chat"
+	MsgChatRejected MessageType = "chat_rejected"
+
+	// Rematch Messages
+	MsgRematchRequest MessageType = "rematch_request"
+	MsgRematchDecline MessageType = "rematch_decline"
+	MsgRematchStart   MessageType = "rematch_start"
+
+	// Shot Clock Messages
+	MsgTurnTimer      MessageType = "turn_timer"
+	MsgPlacementTimer MessageType = "placement_timer"
+	MsgTurnTimeout    MessageType = "turn_timeout"
+)
+
+// Actions the server takes when a turn times out
+const (
+	TimeoutSkip    = "skip"
+	TimeoutRandom  = "random"
+	TimeoutForfeit = "forfeit"
+)
+
+// Player roles as seen by spectators
+const (
+	RoleHost  = "host"
+	RoleGuest = "guest"
 )
 
 // Message is the wrapper for all network messages
@@ -41,6 +87,12 @@ type CreateRoomResponse struct {
 	Code string `json:"code"`
 }
 
+// CreateRoomPayload asks for a public room with a short code, or a private
+// one with a long code that can't be guessed.
+type CreateRoomPayload struct {
+	Private bool `json:"private"`
+}
+
 type JoinRoomPayload struct {
 	Code string `json:"code"`
 }
@@ -49,6 +101,18 @@ type ErrorPayload struct {
 	Message string `json:"message"`
 }
 
+// ShipPlacement is the position of one ship in a fleet
+type ShipPlacement struct {
+	Name      string   `json:"name"`
+	Positions [][2]int `json:"positions"`
+}
+
+// ShipsPlacedPayload carries the fleet to the server, which keeps it for
+// spectators and strips it before relaying to the opponent
+type ShipsPlacedPayload struct {
+	Fleet []ShipPlacement `json:"fleet,omitempty"`
+}
+
 type AttackPayload struct {
 	Row int `json:"row"`
 	Col int `json:"col"`
@@ -65,10 +129,107 @@ type GameOverPayload struct {
 	YouWon bool `json:"you_won"`
 }
 
-// Connection wraps a WebSocket connection
+// HelloPayload identifies the player. Secret is the one the server issued
+// for PlayerID in welcome, and is left out the first time.
+type HelloPayload struct {
+	PlayerID string `json:"player_id"`
+	Name     string `json:"name"`
+	Secret   string `json:"secret,omitempty"`
+}
+
+// WelcomePayload accepts a hello. Secret is set when the server issues one
+// for the player ID, which must be kept and sent with every later hello.
+type WelcomePayload struct {
+	Secret string `json:"secret,omitempty"`
+}
+
+// LeaderboardEntry is a row of the leaderboard. Player is PublicPlayerID of
+// the player's ID, which stays private.
+type LeaderboardEntry struct {
+	Rank   int     `json:"rank"`
+	Player string  `json:"player"`
+	Name   string  `json:"name"`
+	Rating float64 `json:"rating"`
+	Wins   int     `json:"wins"`
+	Losses int     `json:"losses"`
+}
+
+type LeaderboardPayload struct {
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+type SpectatorShotPayload struct {
+	Shooter      string `json:"shooter"`
+	Row          int    `json:"row"`
+	Col          int    `json:"col"`
+	Hit          bool   `json:"hit"`
+	SunkShipName string `json:"sunk_ship_name,omitempty"`
+}
+
+type FleetRevealPayload struct {
+	Host  []ShipPlacement `json:"host"`
+	Guest []ShipPlacement `json:"guest"`
+}
+
+type SpectatorCountPayload struct {
+	Count int `json:"count"`
+}
+
+type SpectateStatePayload struct {
+	Code       string                 `json:"code"`
+	HostName   string                 `json:"host_name"`
+	GuestName  string                 `json:"guest_name"`
+	Spectators int                    `json:"spectators"`
+	Shots      []SpectatorShotPayload `json:"shots"`
+	HostFleet  []ShipPlacement        `json:"host_fleet,omitempty"`
+	GuestFleet []ShipPlacement        `json:"guest_fleet,omitempty"`
+	Winner     string                 `json:"winner,omitempty"`
+}
+
+type SpectateGameOverPayload struct {
+	Winner string `json:"winner"`
+}
+
+// RematchStartPayload starts the next game of a series in the same room
+type RematchStartPayload struct {
+	Game           int  `json:"game"`
+	HostMovesFirst bool `json:"host_moves_first"`
+	HostWins       int  `json:"host_wins"`
+	GuestWins      int  `json:"guest_wins"`
+}
+
+// TimerPayload starts a countdown; Role is empty for the placement deadline
+type TimerPayload struct {
+	Role        string `json:"role,omitempty"`
+	RemainingMs int64  `json:"remaining_ms"`
+}
+
+// TurnTimeoutPayload reports that Role ran out of time and what the server did
+type TurnTimeoutPayload struct {
+	Role     string `json:"role"`
+	Action   string `json:"action"`
+	Timeouts int    `json:"timeouts"`
+	Row      int    `json:"row"`
+	Col      int    `json:"col"`
+}
+
+// ChatPayload is a chat message; the server fills in the sender
+type ChatPayload struct {
+	From   string    `json:"from,omitempty"`
+	Role   string    `json:"role,omitempty"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Connection sends and receives messages over a Transport
 type Connection struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	transport Transport
+	mu        sync.Mutex
+}
+
+// NewConnection wraps an open transport
+func NewConnection(transport Transport) *Connection {
+	return &Connection{transport: transport}
 }
 
 // Send sends a message over the connection
@@ -86,38 +247,30 @@ func (c *Connection) Send(msgType MessageType, payload any) error { // Changed r
 		Payload: payloadBytes,
 	}
 
-	return c.conn.WriteJSON(msg)
+	return c.transport.WriteMessage(&msg)
 }
 
 // Receive receives a message from the connection
 func (c *Connection) Receive() (*Message, error) { // Changed receiver to pointer
-	var msg Message
-	err := c.conn.ReadJSON(&msg)
-	if err != nil {
-		return nil, err
-	}
-	return &msg, nil
+	return c.transport.ReadMessage()
 }
 
 // Close closes the connection
 func (c *Connection) Close() error { // Changed receiver to pointer
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.conn.Close()
+	return c.transport.Close()
 }
 
-// Connect connects to the central server
-func Connect(address string) (*Connection, error) {
-	url := fmt.Sprintf("wss://%s/ws", address)
-	log.Printf("Connecting to %s", url)
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+// Connect connects to the server at address, speaking encoding if the server
+// does; see Dial for the forms address takes
+func Connect(address string, encoding Encoding) (*Connection, error) {
+	log.Printf("Connecting to %s", address)
+	transport, err := DialEncoding(address, encoding)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server: %w", err)
+		return nil, err
 	}
-
-	return &Connection{
-		conn: conn,
-	}, nil
+	return NewConnection(transport), nil
 }
 
 // Helper functions for parsing payloads
@@ -161,3 +314,91 @@ func ParseGameOverPayload(payload json.RawMessage) (*GameOverPayload, error) {
 	}
 	return &p, nil
 }
+
+func ParseWelcomePayload(payload json.RawMessage) (*WelcomePayload, error) {
+	var p WelcomePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseLeaderboardPayload(payload json.RawMessage) (*LeaderboardPayload, error) {
+	var p LeaderboardPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseSpectateStatePayload(payload json.RawMessage) (*SpectateStatePayload, error) {
+	var p SpectateStatePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseSpectatorShotPayload(payload json.RawMessage) (*SpectatorShotPayload, error) {
+	var p SpectatorShotPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseFleetRevealPayload(payload json.RawMessage) (*FleetRevealPayload, error) {
+	var p FleetRevealPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseSpectatorCountPayload(payload json.RawMessage) (*SpectatorCountPayload, error) {
+	var p SpectatorCountPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseSpectateGameOverPayload(payload json.RawMessage) (*SpectateGameOverPayload, error) {
+	var p SpectateGameOverPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseChatPayload(payload json.RawMessage) (*ChatPayload, error) {
+	var p ChatPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseRematchStartPayload(payload json.RawMessage) (*RematchStartPayload, error) {
+	var p RematchStartPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseTimerPayload(payload json.RawMessage) (*TimerPayload, error) {
+	var p TimerPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParseTurnTimeoutPayload(payload json.RawMessage) (*TurnTimeoutPayload, error) {
+	var p TurnTimeoutPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
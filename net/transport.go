@@ -0,0 +1,266 @@
+package net
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	stdnet "net"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxTCPMessageSize is the largest message, in bytes, read from a TCP
+// transport.
+const MaxTCPMessageSize = 64 * 1024
+
+// ErrTransportClosed is returned by a pipe transport once either end is
+// closed.
+var ErrTransportClosed = errors.New("transport closed")
+
+// Transport carries messages between two peers. WriteMessage and
+// ReadMessage may be called at the same time, but each by one goroutine at
+// a time.
+type Transport interface {
+	WriteMessage(msg *Message) error
+	ReadMessage() (*Message, error)
+	Close() error
+}
+
+// WebSocketTransport carries messages as WebSocket text frames, one JSON
+// object each, or, if MsgPackSubprotocol was negotiated, as binary frames
+// of MessagePack. It is what the central server speaks.
+type WebSocketTransport struct {
+	conn     *websocket.Conn
+	encoding Encoding
+}
+
+// NewWebSocketTransport wraps an open WebSocket.
+func NewWebSocketTransport(conn *websocket.Conn) *WebSocketTransport {
+	encoding := EncodingJSON
+	if conn.Subprotocol() == MsgPackSubprotocol {
+		encoding = EncodingMsgPack
+	}
+	return &WebSocketTransport{conn: conn, encoding: encoding}
+}
+
+// DialWebSocket connects to the WebSocket at url, asking to speak encoding.
+// If the server only speaks JSON, the transport does too.
+func DialWebSocket(url string, encoding Encoding) (*WebSocketTransport, error) {
+	dialer := *websocket.DefaultDialer
+	if encoding == EncodingMsgPack {
+		dialer.Subprotocols = []string{MsgPackSubprotocol}
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	t := NewWebSocketTransport(conn)
+	if t.encoding != encoding {
+		log.Printf("Server doesn't speak %s, using %s", encoding, t.encoding)
+	}
+	return t, nil
+}
+
+func (t *WebSocketTransport) WriteMessage(msg *Message) error {
+	data, err := t.encoding.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	frameType := websocket.TextMessage
+	if t.encoding == EncodingMsgPack {
+		frameType = websocket.BinaryMessage
+	}
+	return t.conn.WriteMessage(frameType, data)
+}
+
+func (t *WebSocketTransport) ReadMessage() (*Message, error) {
+	frameType, data, err := t.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	encoding := EncodingJSON
+	if frameType == websocket.BinaryMessage {
+		encoding = EncodingMsgPack
+	}
+	var msg Message
+	if err := encoding.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (t *WebSocketTransport) Close() error {
+	return t.conn.Close()
+}
+
+// StreamTransport carries messages over a byte stream, such as a TCP
+// connection, as JSON objects one per line, or as MessagePack maps one after
+// another.
+type StreamTransport struct {
+	conn     io.ReadWriteCloser
+	reader   *bufio.Reader
+	encoding Encoding
+}
+
+// NewStreamTransport wraps conn, speaking encoding. Messages longer than
+// MaxTCPMessageSize fail to read.
+func NewStreamTransport(conn io.ReadWriteCloser, encoding Encoding) *StreamTransport {
+	return &StreamTransport{
+		conn:     conn,
+		reader:   bufio.NewReaderSize(conn, MaxTCPMessageSize),
+		encoding: encoding,
+	}
+}
+
+// DialTCP connects to address, a host and port, over TCP. A server answers
+// a client in the encoding of its first message, so a server that only
+// speaks JSON drops a client speaking MessagePack.
+func DialTCP(address string, encoding Encoding) (*StreamTransport, error) {
+	conn, err := stdnet.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	return NewStreamTransport(conn, encoding), nil
+}
+
+func (t *StreamTransport) WriteMessage(msg *Message) error {
+	data, err := t.encoding.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if t.encoding != EncodingMsgPack {
+		data = append(data, '\n')
+	}
+	_, err = t.conn.Write(data)
+	return err
+}
+
+func (t *StreamTransport) ReadMessage() (*Message, error) {
+	var data []byte
+	var err error
+	if t.encoding == EncodingMsgPack {
+		data, err = ReadMsgPack(t.reader, MaxTCPMessageSize)
+	} else {
+		data, err = ReadLine(t.reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	return &msg, nil
+}
+
+// ReadLine reads the next line from r, without its newline. Lines must fit
+// r's buffer.
+func ReadLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		return nil, ErrMessageTooLarge
+	case errors.Is(err, io.EOF) && len(line) > 0:
+		return line, nil // The last line needn't end with a newline
+	case err != nil:
+		return nil, err
+	}
+	return line[:len(line)-1], nil
+}
+
+func (t *StreamTransport) Close() error {
+	return t.conn.Close()
+}
+
+// pipeTransport is one end of an in-memory pipe.
+type pipeTransport struct {
+	in     <-chan *Message
+	out    chan<- *Message
+	closed chan struct{} // Shared by both ends
+	once   *sync.Once
+}
+
+// Pipe returns the two ends of an in-memory transport, for running a client
+// and a server, or two peers, in one process. Up to buffer messages may be
+// written to each end before a write waits for the other end to read.
+func Pipe(buffer int) (Transport, Transport) {
+	aToB := make(chan *Message, buffer)
+	bToA := make(chan *Message, buffer)
+	closed := make(chan struct{})
+	once := new(sync.Once)
+	return &pipeTransport{in: bToA, out: aToB, closed: closed, once: once},
+		&pipeTransport{in: aToB, out: bToA, closed: closed, once: once}
+}
+
+func (t *pipeTransport) WriteMessage(msg *Message) error {
+	// Copy, as a real transport would, so neither end sees the other's changes
+	copied := &Message{Type: msg.Type, Payload: append(json.RawMessage(nil), msg.Payload...)}
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.out <- copied:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+func (t *pipeTransport) ReadMessage() (*Message, error) {
+	select {
+	case msg := <-t.in: // Messages written before the pipe closed are still read
+		return msg, nil
+	default:
+	}
+	select {
+	case msg := <-t.in:
+		return msg, nil
+	case <-t.closed:
+		return nil, ErrTransportClosed
+	}
+}
+
+// Close closes both ends of the pipe.
+func (t *pipeTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+// Dial connects to a server at address and returns the transport for it,
+// speaking JSON:
+//
+//	tcp://host:port    a raw TCP connection
+//	ws://host:port/ws  a WebSocket at that URL (also wss://)
+//	host[:port]        the central server's WebSocket, wss://host/ws
+func Dial(address string) (Transport, error) {
+	return DialEncoding(address, EncodingJSON)
+}
+
+// DialEncoding is like Dial, but speaks encoding if the server does.
+func DialEncoding(address string, encoding Encoding) (Transport, error) {
+	if hostPort, ok := strings.CutPrefix(address, "tcp://"); ok {
+		t, err := DialTCP(hostPort, encoding)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	url := address
+	if !strings.HasPrefix(address, "ws://") && !strings.HasPrefix(address, "wss://") {
+		url = fmt.Sprintf("wss://%s/ws", address)
+	}
+	t, err := DialWebSocket(url, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
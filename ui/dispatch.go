@@ -0,0 +1,142 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bnet "battle-ship/net"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// messageReceiver is the receiving end of a connection to the server.
+type messageReceiver interface {
+	Receive() (*bnet.Message, error)
+}
+
+// messageHandler turns the payload of a message from the server into the
+// tea.Msg the model handles, or returns an error if the payload is malformed.
+type messageHandler func(payload json.RawMessage) (tea.Msg, error)
+
+// protocolErrorMsg reports a message from the server that could not be
+// understood. The game carries on without it.
+type protocolErrorMsg struct {
+	err error
+}
+
+// messageHandlers maps each message type the client understands to its
+// handler. Other types are skipped, so a newer server can add messages
+// without breaking older clients.
+var messageHandlers = map[bnet.MessageType]messageHandler{
+	bnet.MsgRoomCreated: handle(bnet.ParseCreateRoomResponse, func(p *bnet.CreateRoomResponse) tea.Msg {
+		return roomCreatedMsg{code: p.Code}
+	}),
+	bnet.MsgJoinError: handle(bnet.ParseErrorPayload, func(p *bnet.ErrorPayload) tea.Msg {
+		return joinErrorMsg{err: p.Message}
+	}),
+	bnet.MsgGameStart:    signal(playerJoinedMsg{}), // Guest joined
+	bnet.MsgPlayerJoined: signal(playerJoinedMsg{}), // Host notified
+	bnet.MsgShipsPlaced:  signal(opponentReadyMsg{}),
+	bnet.MsgAttack: handle(bnet.ParseAttackPayload, func(p *bnet.AttackPayload) tea.Msg {
+		return opponentAttackMsg{row: p.Row, col: p.Col}
+	}),
+	bnet.MsgAttackResult: handle(bnet.ParseAttackResultPayload, func(p *bnet.AttackResultPayload) tea.Msg {
+		return attackResultMsg{hit: p.Hit, sunkShipName: p.SunkShipName}
+	}),
+	bnet.MsgGameOver: handle(bnet.ParseGameOverPayload, func(p *bnet.GameOverPayload) tea.Msg {
+		return opponentGameOverMsg{youWon: p.YouWon}
+	}),
+	bnet.MsgOpponentLeft: signal(opponentLeftMsg{}),
+	bnet.MsgWelcome: handle(bnet.ParseWelcomePayload, func(p *bnet.WelcomePayload) tea.Msg {
+		return welcomeMsg{secret: p.Secret}
+	}),
+	bnet.MsgHelloError: handle(bnet.ParseErrorPayload, func(p *bnet.ErrorPayload) tea.Msg {
+		return helloErrorMsg{err: p.Message}
+	}),
+	bnet.MsgNotice: handle(bnet.ParseErrorPayload, func(p *bnet.ErrorPayload) tea.Msg {
+		return noticeMsg{text: p.Message}
+	}),
+	bnet.MsgRoomExpired: handle(bnet.ParseErrorPayload, func(p *bnet.ErrorPayload) tea.Msg {
+		return roomExpiredMsg{reason: p.Message}
+	}),
+
+	bnet.MsgSpectateState: handle(bnet.ParseSpectateStatePayload, func(p *bnet.SpectateStatePayload) tea.Msg {
+		return spectateStateMsg{state: p}
+	}),
+	bnet.MsgSpectatorShot: handle(bnet.ParseSpectatorShotPayload, func(p *bnet.SpectatorShotPayload) tea.Msg {
+		return spectatorShotMsg{shot: p}
+	}),
+	bnet.MsgFleetReveal: handle(bnet.ParseFleetRevealPayload, func(p *bnet.FleetRevealPayload) tea.Msg {
+		return fleetRevealMsg{host: p.Host, guest: p.Guest}
+	}),
+	bnet.MsgSpectatorCount: handle(bnet.ParseSpectatorCountPayload, func(p *bnet.SpectatorCountPayload) tea.Msg {
+		return spectatorCountMsg{count: p.Count}
+	}),
+	bnet.MsgSpectateGameOver: handle(bnet.ParseSpectateGameOverPayload, func(p *bnet.SpectateGameOverPayload) tea.Msg {
+		return spectateGameOverMsg{winner: p.Winner}
+	}),
+
+	bnet.MsgRematchRequest: signal(rematchRequestMsg{}),
+	bnet.MsgRematchDecline: signal(rematchDeclineMsg{}),
+	bnet.MsgRematchStart: handle(bnet.ParseRematchStartPayload, func(p *bnet.RematchStartPayload) tea.Msg {
+		return rematchStartMsg{start: p}
+	}),
+
+	bnet.MsgTurnTimer: handle(bnet.ParseTimerPayload, func(p *bnet.TimerPayload) tea.Msg {
+		return turnTimerMsg{role: p.Role, remaining: time.Duration(p.RemainingMs) * time.Millisecond}
+	}),
+	bnet.MsgPlacementTimer: handle(bnet.ParseTimerPayload, func(p *bnet.TimerPayload) tea.Msg {
+		return placementTimerMsg{remaining: time.Duration(p.RemainingMs) * time.Millisecond}
+	}),
+	bnet.MsgTurnTimeout: handle(bnet.ParseTurnTimeoutPayload, func(p *bnet.TurnTimeoutPayload) tea.Msg {
+		return turnTimeoutMsg{timeout: p}
+	}),
+
+	bnet.MsgChat: handle(bnet.ParseChatPayload, func(p *bnet.ChatPayload) tea.Msg {
+		return chatMsg{chat: p}
+	}),
+	bnet.MsgChatRejected: handle(bnet.ParseErrorPayload, func(p *bnet.ErrorPayload) tea.Msg {
+		return chatRejectedMsg{reason: p.Message}
+	}),
+}
+
+// handle builds a handler that parses a payload with parse and turns it
+// into a tea.Msg with convert.
+func handle[P any](parse func(json.RawMessage) (*P, error), convert func(*P) tea.Msg) messageHandler {
+	return func(payload json.RawMessage) (tea.Msg, error) {
+		p, err := parse(payload)
+		if err != nil {
+			return nil, err
+		}
+		return convert(p), nil
+	}
+}
+
+// signal builds a handler for a message whose payload carries nothing.
+func signal(msg tea.Msg) messageHandler {
+	return func(json.RawMessage) (tea.Msg, error) {
+		return msg, nil
+	}
+}
+
+// receiveMessage waits for the next message from conn the client
+// understands and returns its tea.Msg. Messages of unknown types are skipped.
+func receiveMessage(conn messageReceiver) tea.Msg {
+	for {
+		msg, err := conn.Receive()
+		if err != nil {
+			return connectionErrorMsg{err: err}
+		}
+
+		handler, ok := messageHandlers[msg.Type]
+		if !ok {
+			continue
+		}
+		result, err := handler(msg.Payload)
+		if err != nil {
+			return protocolErrorMsg{err: fmt.Errorf("malformed %s message: %w", msg.Type, err)}
+		}
+		return result
+	}
+}
@@ -0,0 +1,160 @@
+package ui
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	bnet "battle-ship/net"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// scriptedReceiver plays back messages, then fails as a closed connection.
+type scriptedReceiver struct {
+	msgs []*bnet.Message
+}
+
+func (r *scriptedReceiver) Receive() (*bnet.Message, error) {
+	if len(r.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return msg, nil
+}
+
+// serverMessage builds a message of type msgType with payload encoded as
+// the server would.
+func serverMessage(t *testing.T, msgType bnet.MessageType, payload any) *bnet.Message {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &bnet.Message{Type: msgType, Payload: data}
+}
+
+func TestReceiveMessage(t *testing.T) {
+	sentAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		msg  *bnet.Message
+		want tea.Msg
+	}{
+		{serverMessage(t, bnet.MsgRoomCreated, bnet.CreateRoomResponse{Code: "ABCD"}), roomCreatedMsg{code: "ABCD"}},
+		{serverMessage(t, bnet.MsgJoinError, bnet.ErrorPayload{Message: "Room is full"}), joinErrorMsg{err: "Room is full"}},
+		{serverMessage(t, bnet.MsgGameStart, struct{}{}), playerJoinedMsg{}},
+		{serverMessage(t, bnet.MsgPlayerJoined, struct{}{}), playerJoinedMsg{}},
+		{serverMessage(t, bnet.MsgAttack, bnet.AttackPayload{Row: 3, Col: 7}), opponentAttackMsg{row: 3, col: 7}},
+		{serverMessage(t, bnet.MsgAttackResult, bnet.AttackResultPayload{Row: 3, Col: 7, Hit: true, SunkShipName: "Destroyer"}), attackResultMsg{hit: true, sunkShipName: "Destroyer"}},
+		{serverMessage(t, bnet.MsgGameOver, bnet.GameOverPayload{YouWon: true}), opponentGameOverMsg{youWon: true}},
+		{serverMessage(t, bnet.MsgWelcome, bnet.WelcomePayload{Secret: "s3cret"}), welcomeMsg{secret: "s3cret"}},
+		{serverMessage(t, bnet.MsgHelloError, bnet.ErrorPayload{Message: "Player identity unavailable"}), helloErrorMsg{err: "Player identity unavailable"}},
+		{serverMessage(t, bnet.MsgRematchDecline, struct{}{}), rematchDeclineMsg{}},
+		{serverMessage(t, bnet.MsgSpectateGameOver, bnet.SpectateGameOverPayload{}), spectateGameOverMsg{}},
+		{serverMessage(t, bnet.MsgTurnTimer, bnet.TimerPayload{Role: "host", RemainingMs: 1500}), turnTimerMsg{role: "host", remaining: 1500 * time.Millisecond}},
+		{serverMessage(t, bnet.MsgChat, bnet.ChatPayload{From: "ann", Text: "hi", SentAt: sentAt}), chatMsg{chat: &bnet.ChatPayload{From: "ann", Text: "hi", SentAt: sentAt}}},
+	}
+	for _, tt := range tests {
+		if got := receiveMessage(&scriptedReceiver{msgs: []*bnet.Message{tt.msg}}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s message became %#v, want %#v", tt.msg.Type, got, tt.want)
+		}
+	}
+}
+
+func TestReceiveMessageSkipsUnknownTypes(t *testing.T) {
+	conn := &scriptedReceiver{msgs: []*bnet.Message{
+		serverMessage(t, "from_the_future", struct{}{}),
+		serverMessage(t, bnet.MsgOpponentLeft, struct{}{}),
+	}}
+	if got := receiveMessage(conn); got != (opponentLeftMsg{}) {
+		t.Errorf("got %#v, want the message after the unknown one", got)
+	}
+}
+
+func TestReceiveMessageErrors(t *testing.T) {
+	conn := &scriptedReceiver{msgs: []*bnet.Message{
+		{Type: bnet.MsgAttack, Payload: json.RawMessage(`{"row":"three"}`)},
+	}}
+	if got, ok := receiveMessage(conn).(protocolErrorMsg); !ok {
+		t.Errorf("malformed attack became %#v, want a protocol error", got)
+	}
+	if got, ok := receiveMessage(conn).(connectionErrorMsg); !ok || !errors.Is(got.err, io.EOF) {
+		t.Errorf("closed connection became %#v, want a connection error", got)
+	}
+}
+
+// TestDispatchDrivesModel feeds a host's session through the dispatcher
+// into the model.
+func TestDispatchDrivesModel(t *testing.T) {
+	conn := &scriptedReceiver{msgs: []*bnet.Message{
+		serverMessage(t, bnet.MsgWelcome, bnet.WelcomePayload{Secret: "s3cret"}),
+		serverMessage(t, bnet.MsgRoomCreated, bnet.CreateRoomResponse{Code: "ABCD"}),
+		serverMessage(t, bnet.MsgPlayerJoined, struct{}{}),
+		{Type: bnet.MsgAttack, Payload: json.RawMessage(`not json`)},
+		serverMessage(t, bnet.MsgRematchDecline, struct{}{}),
+		serverMessage(t, bnet.MsgOpponentLeft, struct{}{}),
+	}}
+	m := newTestModel(t)
+	m.State = StateMPConnecting
+	// The model's commands aren't run, as they would read from its own
+	// connection
+	step := func() {
+		t.Helper()
+		next, _ := m.Update(receiveMessage(conn))
+		m = next.(Model)
+	}
+
+	step()
+	if m.Identity.Secrets[m.ServerAddress] != "s3cret" {
+		t.Errorf("secrets = %v after welcome, want the server's secret", m.Identity.Secrets)
+	}
+	step()
+	if m.State != StateMPHostWaiting || m.RoomCode != "ABCD" || !m.IsHost {
+		t.Errorf("state %v, room %q, host %v after room_created; want hosting ABCD", m.State, m.RoomCode, m.IsHost)
+	}
+	step()
+	if m.State != StateMPPlacement {
+		t.Errorf("state = %v after player_joined, want placement", m.State)
+	}
+	step()
+	if m.State != StateMPPlacement {
+		t.Errorf("state = %v after a malformed message, want placement to carry on", m.State)
+	}
+	m.RematchRequested = true
+	step()
+	if m.RematchRequested || m.Message != "Opponent declined the rematch." {
+		t.Errorf("rematch requested %v, message %q after rematch_decline", m.RematchRequested, m.Message)
+	}
+	step()
+	if m.State != StateMenu || m.Message != "Opponent disconnected." {
+		t.Errorf("state %v, message %q after opponent_left; want the menu", m.State, m.Message)
+	}
+	step()
+	if m.State != StateMenu || m.Message != "Connection error: EOF" {
+		t.Errorf("state %v, message %q after the connection closed", m.State, m.Message)
+	}
+}
+
+// TestRefusalsCloseConnection checks that a refused hello or join closes
+// the connection, which closes any room requested with it.
+func TestRefusalsCloseConnection(t *testing.T) {
+	for _, msg := range []tea.Msg{helloErrorMsg{err: "This player ID belongs to someone else"}, joinErrorMsg{err: "Room is full"}} {
+		client, server := bnet.Pipe(1)
+		m := newTestModel(t)
+		m.State = StateMPHostWaiting
+		m.Connection = bnet.NewConnection(client)
+
+		next, cmd := m.Update(msg)
+		m = next.(Model)
+		if m.State != StateMPMenu || cmd != nil {
+			t.Errorf("after %#v: state %v, command %v; want the multiplayer menu and no more reads", msg, m.State, cmd != nil)
+		}
+		if _, err := server.ReadMessage(); !errors.Is(err, bnet.ErrTransportClosed) {
+			t.Errorf("after %#v the connection is still open: %v", msg, err)
+		}
+	}
+}
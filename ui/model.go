@@ -2,6 +2,9 @@ package ui
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"battle-ship/game"
@@ -26,6 +29,19 @@ const (
 	StateMPPlacement
 	StateMPWaitingForOpponent
 	StateMPBattle
+	StateLeaderboard
+	StateSpectating
+)
+
+const (
+	// maxChatHistory is how many chat lines are kept for scrollback
+	maxChatHistory = 200
+	// maxChatInput caps the chat input to the server's message limit
+	maxChatInput = 200
+	// maxRoomCodeLength fits the long codes of private rooms
+	maxRoomCodeLength = 16
+	// maxLANAddressLength fits a host name or IPv6 address and a port
+	maxLANAddressLength = 64
 )
 
 // GameMode represents the type of game being played
@@ -42,8 +58,8 @@ type Model struct {
 	GameMode          GameMode
 	PlayerBoard       *game.Board
 	AIBoard           *game.Board
-	OpponentBoard     *game.Board // Used in multiplayer
-	AI                *game.AI
+	OpponentBoard     *game.Board   // Used in multiplayer
+	Opponent          game.Opponent // Built-in AI or an external bot
 	CursorRow         int
 	CursorCol         int
 	PlayerTurn        bool
@@ -56,26 +72,81 @@ type Model struct {
 	// Menu selection
 	MenuSelection int
 
+	// External bot played instead of the built-in AI, if set
+	BotCommand    string
+	BotTimeout    time.Duration
+	ForfeitReason string // Why the opponent stopped playing, if it did
+
 	// Multiplayer
 	Connection    *bnet.Connection
 	ServerAddress string
-	RoomCode      string
+	Dial          func(address string) (bnet.Transport, error) // Opens the transport to the server; bnet.Dial if nil
+	Encoding      bnet.Encoding                                // Wire encoding asked of the server; LAN games always speak JSON
+	Identity      bnet.Identity
+	RoomCode      string // Or, on the LAN, the host's address
+	LAN           bool   // Playing directly with another machine on the network
+	LANPort       int    // Port LAN games are hosted on
+	NoLAN         bool   // LAN games aren't offered, as when served over SSH
+	LANFinder     *bnet.LANFinder
+	LANGames      []bnet.LANGame // Games announced on the network, while joining
+	LANSelection  int
 	IsHost        bool
 	ShipsPlaced   bool
 	OpponentReady bool
 	LastAttackRow int
 	LastAttackCol int
+
+	// Leaderboard
+	Leaderboard []bnet.LeaderboardEntry
+
+	// Spectating
+	Spectating     bool
+	SpectatorCount int         // Also shown to players
+	HostBoard      *game.Board // Spectator view of the host's waters
+	GuestBoard     *game.Board // Spectator view of the guest's waters
+	HostName       string
+	GuestName      string
+	SpectateWinner string
+
+	// Rematch series against the same opponent
+	SeriesGame       int
+	SeriesWins       int
+	SeriesLosses     int
+	HostMovesFirst   bool // Alternates between games of a series
+	RematchRequested bool // We asked for a rematch
+	RematchOffered   bool // Opponent asked for a rematch
+
+	// Shot clock, set by the server
+	TurnDeadline      time.Time
+	TurnClockRole     string
+	PlacementDeadline time.Time
+	ClockTicking      bool
+
+	// Chat
+	ChatHistory []ChatLine
+	ChatInput   string
+	ChatFocused bool
+	ChatScroll  int // Lines scrolled back from the newest message
+}
+
+// ChatLine is one entry in the chat scrollback
+type ChatLine struct {
+	From   string
+	Text   string
+	Own    bool
+	System bool // Notices from the server rather than a player
 }
 
 // NewModel creates a new game model
 func NewModel() Model {
+	identity, _ := bnet.LoadIdentity() // Falls back to a session-only identity
 	return Model{
 		State:             StateMenu,
 		GameMode:          ModeVsAI,
 		PlayerBoard:       game.NewBoard(),
 		AIBoard:           game.NewBoard(),
 		OpponentBoard:     game.NewBoard(),
-		AI:                game.NewAI(),
+		Opponent:          game.NewAIOpponent(),
 		CursorRow:         0,
 		CursorCol:         0,
 		PlayerTurn:        true,
@@ -84,6 +155,10 @@ func NewModel() Model {
 		PlacingHorizontal: true,
 		MenuSelection:     0,
 		ServerAddress:     "battleship-server-350181966586.us-central1.run.app", // Default central server or localhost:8080 for local development
+		LANPort:           bnet.DefaultLANPort,
+		Identity:          identity,
+		SeriesGame:        1,
+		HostMovesFirst:    true,
 	}
 }
 
@@ -96,10 +171,23 @@ func (m Model) Init() tea.Cmd {
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		// A focused chat input gets every key so typing never fires shots
+		if m.ChatFocused && msg.String() != "ctrl+c" {
+			return m.updateChat(msg)
+		}
+
 		switch msg.String() {
 		case "ctrl+c", "q":
+			if msg.String() == "q" && m.State == StateMPJoinInput {
+				break // Part of the room code or address being typed
+			}
 			m.cleanup()
 			return m, tea.Quit
+		case "tab":
+			if m.chatAvailable() {
+				m.ChatFocused = true
+				return m, nil
+			}
 		}
 
 		switch m.State {
@@ -123,10 +211,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.updateMPWaiting(msg)
 		case StateMPBattle:
 			return m.updateMPBattle(msg)
+		case StateLeaderboard:
+			return m.updateLeaderboard(msg)
+		case StateSpectating:
+			return m.updateSpectating(msg)
 		}
 
+	case aiPlacedMsg:
+		return m.handleAIPlaced(msg)
+
 	case aiTurnMsg:
-		return m.handleAITurn()
+		return m.handleAITurn(msg)
 
 	case connectionEstablishedMsg:
 		return m.handleConnectionEstablished(msg)
@@ -136,8 +231,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.State = StateMenu
 		return m, nil
 
+	case protocolErrorMsg:
+		m.Message = "Ignored a message from the server: " + msg.err.Error()
+		return m, m.messageLoop()
+
 	case roomCreatedMsg:
 		m.RoomCode = msg.code
+		m.IsHost = true // Also true when rejoining a restored room first
 		m.State = StateMPHostWaiting
 		m.Message = fmt.Sprintf("Room Created! Code: %s. Waiting for opponent...", m.RoomCode)
 		return m, m.messageLoop()
@@ -151,6 +251,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case joinErrorMsg:
 		m.Message = "Error: " + msg.err
 		m.State = StateMPMenu
+		m.cleanup()
+		return m, nil
+
+	case helloErrorMsg:
+		// A room requested along with the hello is closed with the connection
+		m.Message = "Error: " + msg.err
+		m.State = StateMPMenu
+		m.cleanup()
 		return m, nil
 
 	case opponentReadyMsg:
@@ -169,11 +277,132 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, tea.Batch(cmd, m.messageLoop())
 
 	case opponentGameOverMsg:
-		m.State = StateGameOver
-		m.PlayerWon = msg.youWon
+		m.endMPGame(msg.youWon)
+		return m, m.messageLoop() // Keep listening for a rematch
+
+	case rematchRequestMsg:
+		m.RematchOffered = true
+		return m, m.messageLoop()
+
+	case rematchDeclineMsg:
+		m.RematchOffered = false
+		m.RematchRequested = false
+		m.Message = "Opponent declined the rematch."
+		return m, m.messageLoop()
+
+	case rematchStartMsg:
+		m.startRematch(msg.start)
+		return m, m.messageLoop()
+
+	case turnTimerMsg:
+		m.TurnDeadline = time.Now().Add(msg.remaining)
+		m.TurnClockRole = msg.role
+		m.PlacementDeadline = time.Time{}
+		return m, tea.Batch(m.startClockTick(), m.messageLoop())
+
+	case placementTimerMsg:
+		m.PlacementDeadline = time.Now().Add(msg.remaining)
+		m.TurnDeadline = time.Time{}
+		return m, tea.Batch(m.startClockTick(), m.messageLoop())
+
+	case turnTimeoutMsg:
+		m.handleTurnTimeout(msg.timeout)
+		return m, m.messageLoop()
+
+	case lanDiscoveryTickMsg:
+		if m.LANFinder == nil {
+			return m, nil
+		}
+		m.LANGames = m.LANFinder.Games()
+		if m.LANSelection >= len(m.LANGames) {
+			m.LANSelection = max(len(m.LANGames)-1, 0)
+		}
+		return m, tickLANDiscovery()
+
+	case clockTickMsg:
+		if m.clockActive() {
+			return m, tickClock()
+		}
+		m.ClockTicking = false
+		return m, nil
+
+	case leaderboardMsg:
+		m.Leaderboard = msg.entries
+		m.Message = ""
+		return m, nil
+
+	case spectateStateMsg:
+		m.applySpectateState(msg.state)
+		m.State = StateSpectating
+		return m, m.messageLoop()
+
+	case spectatorShotMsg:
+		m.applySpectatorShot(msg.shot)
+		return m, m.messageLoop()
+
+	case fleetRevealMsg:
+		applyFleet(m.HostBoard, msg.host)
+		applyFleet(m.GuestBoard, msg.guest)
+		return m, m.messageLoop()
+
+	case spectatorCountMsg:
+		m.SpectatorCount = msg.count
+		return m, m.messageLoop()
+
+	case spectateGameOverMsg:
+		m.SpectateWinner = msg.winner
+		if msg.winner == "" {
+			m.Message = "The game ended with no winner."
+		} else {
+			m.Message = m.spectatorName(msg.winner) + " wins!"
+		}
+		return m, m.messageLoop()
+
+	case chatMsg:
+		m.appendChat(ChatLine{
+			From: msg.chat.From,
+			Text: msg.chat.Text,
+			Own:  !m.Spectating && msg.chat.Role == m.role(),
+		})
+		return m, m.messageLoop()
+
+	case chatRejectedMsg:
+		m.appendChat(ChatLine{Text: msg.reason, System: true})
+		return m, m.messageLoop()
+
+	case welcomeMsg:
+		if msg.secret == "" || m.LAN { // Only the server issues secrets
+			return m, m.messageLoop()
+		}
+		// Copy, as commands still running may read the old secrets
+		secrets := make(map[string]string, len(m.Identity.Secrets)+1)
+		for address, secret := range m.Identity.Secrets {
+			secrets[address] = secret
+		}
+		secrets[m.ServerAddress] = msg.secret
+		m.Identity.Secrets = secrets
+		return m, tea.Batch(m.messageLoop(), saveIdentity(m.Identity))
+
+	case noticeMsg:
+		m.appendChat(ChatLine{Text: "Server notice: " + msg.text, System: true})
+		m.Message = "Server notice: " + msg.text
+		return m, m.messageLoop()
+
+	case roomExpiredMsg:
+		m.Message = "Room closed: " + msg.reason
+		m.State = StateMPMenu
+		m.Spectating = false
+		m.cleanup()
 		return m, nil
 
 	case opponentLeftMsg:
+		if m.Spectating {
+			m.Message = "A player left the game."
+			m.State = StateMPMenu
+			m.Spectating = false
+			m.cleanup()
+			return m, nil
+		}
 		m.Message = "Opponent disconnected."
 		m.State = StateMenu // Or game over
 		m.cleanup()
@@ -208,6 +437,10 @@ func (m Model) View() string {
 		return m.renderMPWaiting()
 	case StateMPBattle:
 		return m.renderMPBattle()
+	case StateLeaderboard:
+		return m.renderLeaderboard()
+	case StateSpectating:
+		return m.renderSpectating()
 	default:
 		return "Unknown state"
 	}
@@ -215,9 +448,13 @@ func (m Model) View() string {
 
 // cleanup closes network connections
 func (m *Model) cleanup() {
+	m.stopLANDiscovery()
 	if m.Connection != nil {
 		m.Connection.Close()
 	}
+	if m.Opponent != nil {
+		m.Opponent.Close()
+	}
 }
 
 // updateMenu handles input during the menu state
@@ -228,23 +465,46 @@ func (m Model) updateMenu(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.MenuSelection--
 		}
 	case "down", "j":
-		if m.MenuSelection < 1 { // Reduced since we moved host/join to submenu
+		if m.MenuSelection < len(menuOptions)-1 {
 			m.MenuSelection++
 		}
 	case "enter":
 		switch m.MenuSelection {
 		case 0: // vs AI
+			if m.BotCommand != "" {
+				bot, err := game.StartExternalBot(m.BotCommand, m.BotTimeout, nil)
+				if err != nil {
+					m.Message = "Error: " + err.Error()
+					return m, nil
+				}
+				m.Opponent = bot
+			}
 			m.GameMode = ModeVsAI
 			m.State = StatePlacement
 		case 1: // Multiplayer
 			m.GameMode = ModeMultiplayer
 			m.State = StateMPMenu
 			m.MenuSelection = 0 // Reset for submenu
+		case 2: // Leaderboard
+			m.State = StateLeaderboard
+			m.Leaderboard = nil
+			m.Message = "Loading leaderboard..."
+			return m, m.fetchLeaderboard()
 		}
 	}
 	return m, nil
 }
 
+// updateLeaderboard handles input on the leaderboard screen
+func (m Model) updateLeaderboard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "enter":
+		m.State = StateMenu
+		m.Message = ""
+	}
+	return m, nil
+}
+
 func (m Model) updateMPMenu(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "esc":
@@ -256,19 +516,54 @@ func (m Model) updateMPMenu(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.MenuSelection--
 		}
 	case "down", "j":
-		if m.MenuSelection < 1 {
+		if m.MenuSelection < len(mpMenuOptions)-1 {
 			m.MenuSelection++
 		}
 	case "enter":
+		m.LAN = false
+		if m.NoLAN && (m.MenuSelection == 5 || m.MenuSelection == 6) {
+			m.Message = "LAN games are not available here."
+			return m, nil
+		}
 		switch m.MenuSelection {
 		case 0: // Host
 			m.IsHost = true
+			m.Spectating = false
+			m.State = StateMPConnecting
+			return m, m.connectAndCreateRoom(bnet.MsgCreateRoom, bnet.CreateRoomPayload{})
+		case 1: // Host private
+			m.IsHost = true
+			m.Spectating = false
+			m.State = StateMPConnecting
+			return m, m.connectAndCreateRoom(bnet.MsgCreateRoom, bnet.CreateRoomPayload{Private: true})
+		case 2: // Join
+			m.IsHost = false
+			m.Spectating = false
+			m.State = StateMPJoinInput
+			m.RoomCode = ""
+		case 3: // Spectate
+			m.IsHost = false
+			m.Spectating = true
+			m.State = StateMPJoinInput
+			m.RoomCode = ""
+		case 4: // vs server bot
+			m.IsHost = true
+			m.Spectating = false
+			m.State = StateMPConnecting
+			return m, m.connectAndCreateRoom(bnet.MsgCreateBotRoom, struct{}{})
+		case 5: // Host on LAN
+			m.IsHost = true
+			m.Spectating = false
+			m.LAN = true
 			m.State = StateMPConnecting
-			return m, m.connectAndCreateRoom()
-		case 1: // Join
+			return m, m.hostLAN()
+		case 6: // Join on LAN
 			m.IsHost = false
+			m.Spectating = false
+			m.LAN = true
 			m.State = StateMPJoinInput
 			m.RoomCode = ""
+			return m.startLANDiscovery()
 		}
 	}
 	return m, nil
@@ -276,6 +571,10 @@ func (m Model) updateMPMenu(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 // updatePlacement handles ship placement in single player
 func (m Model) updatePlacement(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.CurrentShipIndex >= len(m.ShipsToPlace) {
+		return m, nil // Waiting for the opponent to place its ships
+	}
+
 	switch msg.String() {
 	case "up", "k":
 		if m.CursorRow > 0 {
@@ -300,12 +599,14 @@ func (m Model) updatePlacement(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if m.PlayerBoard.PlaceShip(ship, m.CursorRow, m.CursorCol, m.PlacingHorizontal) {
 			m.CurrentShipIndex++
 			if m.CurrentShipIndex >= len(m.ShipsToPlace) {
-				// All ships placed, start battle
-				m.AI.PlaceShipsRandomly(m.AIBoard)
-				m.State = StateBattle
-				m.CursorRow = 0
-				m.CursorCol = 0
-				m.Message = "All ships placed! Fire at will!"
+				// All ships placed. An external bot may take a while to
+				// place its own, so it is asked off the update loop.
+				opponent := m.Opponent
+				return m, func() tea.Msg {
+					board := game.NewBoard()
+					err := opponent.PlaceShips(board)
+					return aiPlacedMsg{opponent: opponent, board: board, err: err}
+				}
 			}
 		}
 	}
@@ -342,6 +643,10 @@ func (m Model) updateBattle(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		if err := m.Opponent.RecordIncoming(m.CursorRow, m.CursorCol, hit, sunkShipName); err != nil {
+			return m.opponentForfeited(err)
+		}
+
 		if hit {
 			if sunkShipName != "" {
 				m.Message = "HIT! You sunk their " + sunkShipName + "!"
@@ -349,6 +654,7 @@ func (m Model) updateBattle(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.Message = "HIT!"
 			}
 			if m.AIBoard.AllShipsSunk() {
+				m.Opponent.GameOver(false)
 				m.State = StateGameOver
 				m.PlayerWon = true
 				return m, nil
@@ -357,37 +663,75 @@ func (m Model) updateBattle(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.Message = "Miss..."
 		}
 
-		// AI's turn
+		// AI's turn. An external bot may take a while to answer, so it is
+		// asked off the update loop.
 		m.PlayerTurn = false
+		opponent := m.Opponent
 		return m, tea.Tick(time.Millisecond*500, func(t time.Time) tea.Msg {
-			return aiTurnMsg{}
+			row, col, err := opponent.ChooseAttack()
+			return aiTurnMsg{row: row, col: col, err: err}
 		})
 	}
 	return m, nil
 }
 
-// aiTurnMsg is sent when it's time for the AI to make a move
-type aiTurnMsg struct{}
+// aiPlacedMsg carries the board the AI placed its ships on
+type aiPlacedMsg struct {
+	opponent game.Opponent
+	board    *game.Board
+	err      error
+}
+
+// handleAIPlaced starts the battle once the AI has placed its ships
+func (m Model) handleAIPlaced(msg aiPlacedMsg) (tea.Model, tea.Cmd) {
+	if msg.opponent != m.Opponent || m.State != StatePlacement {
+		return m, nil // The game was left while the AI was placing
+	}
+	if msg.err != nil {
+		return m.opponentForfeited(msg.err)
+	}
+	m.AIBoard = msg.board
+	m.State = StateBattle
+	m.CursorRow = 0
+	m.CursorCol = 0
+	m.Message = "All ships placed! Fire at will!"
+	return m, nil
+}
+
+// aiTurnMsg carries the AI's chosen attack
+type aiTurnMsg struct {
+	row int
+	col int
+	err error
+}
 
 // handleAITurn processes the AI's attack
-func (m Model) handleAITurn() (tea.Model, tea.Cmd) {
-	row, col := m.AI.ChooseAttack()
-	hit, _, sunkShipName := m.PlayerBoard.Attack(row, col)
+func (m Model) handleAITurn(msg aiTurnMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		return m.opponentForfeited(msg.err)
+	}
+	row, col := msg.row, msg.col
+	hit, alreadyAttacked, sunkShipName := m.PlayerBoard.Attack(row, col)
+	if alreadyAttacked {
+		return m.opponentForfeited(fmt.Errorf("invalid attack at %s", game.FormatCoord(row, col)))
+	}
+	if err := m.Opponent.RecordResult(row, col, hit, sunkShipName); err != nil {
+		return m.opponentForfeited(err)
+	}
 
 	if hit {
-		m.AI.RecordHit(row, col)
 		if sunkShipName != "" {
 			m.Message += " | Enemy sunk your " + sunkShipName + "!"
 		} else {
 			m.Message += " | Enemy hit your ship!"
 		}
 		if m.PlayerBoard.AllShipsSunk() {
+			m.Opponent.GameOver(true)
 			m.State = StateGameOver
 			m.PlayerWon = false
 			return m, nil
 		}
 	} else {
-		m.AI.RecordMiss(row, col)
 		m.Message += " | Enemy missed."
 	}
 
@@ -395,13 +739,42 @@ func (m Model) handleAITurn() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// opponentForfeited ends a game against the AI that stopped playing, such as
+// an external bot that crashed or timed out
+func (m Model) opponentForfeited(err error) (tea.Model, tea.Cmd) {
+	m.Opponent.Close()
+	m.State = StateGameOver
+	m.PlayerWon = true
+	m.ForfeitReason = err.Error()
+	return m, nil
+}
+
+// newGame returns a fresh model for the next game, keeping settings
+func (m Model) newGame() Model {
+	next := NewModel()
+	next.BotCommand = m.BotCommand
+	next.BotTimeout = m.BotTimeout
+	return next
+}
+
 // updateGameOver handles input during game over
 func (m Model) updateGameOver(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
 		// Reset the game
 		m.cleanup()
-		return NewModel(), nil
+		return m.newGame(), nil
+	case "y":
+		if m.canRematch() && !m.RematchRequested {
+			m.Connection.Send(bnet.MsgRematchRequest, struct{}{})
+			m.RematchRequested = true
+		}
+	case "n":
+		if m.canRematch() {
+			m.Connection.Send(bnet.MsgRematchDecline, struct{}{})
+			m.cleanup()
+			return m.newGame(), nil
+		}
 	}
 	return m, nil
 }
@@ -445,16 +818,214 @@ type opponentGameOverMsg struct {
 
 type opponentLeftMsg struct{}
 
-// connectAndCreateRoom connects to server and requests a room
-func (m Model) connectAndCreateRoom() tea.Cmd {
+// helloErrorMsg refuses this player's hello, e.g. because the player ID
+// belongs to someone else
+type helloErrorMsg struct {
+	err string
+}
+
+// welcomeMsg accepts this player's hello, with the secret the server
+// issued for the player's ID if this was the first hello
+type welcomeMsg struct {
+	secret string
+}
+
+type noticeMsg struct {
+	text string
+}
+
+type roomExpiredMsg struct {
+	reason string
+}
+
+type leaderboardMsg struct {
+	entries []bnet.LeaderboardEntry
+}
+
+type spectateStateMsg struct {
+	state *bnet.SpectateStatePayload
+}
+
+type spectatorShotMsg struct {
+	shot *bnet.SpectatorShotPayload
+}
+
+type fleetRevealMsg struct {
+	host  []bnet.ShipPlacement
+	guest []bnet.ShipPlacement
+}
+
+type spectatorCountMsg struct {
+	count int
+}
+
+type spectateGameOverMsg struct {
+	winner string
+}
+
+type rematchRequestMsg struct{}
+
+type rematchDeclineMsg struct{}
+
+type rematchStartMsg struct {
+	start *bnet.RematchStartPayload
+}
+
+type turnTimerMsg struct {
+	role      string
+	remaining time.Duration
+}
+
+type placementTimerMsg struct {
+	remaining time.Duration
+}
+
+type turnTimeoutMsg struct {
+	timeout *bnet.TurnTimeoutPayload
+}
+
+// clockTickMsg redraws the shot clock countdown
+type clockTickMsg struct{}
+
+// lanDiscoveryTickMsg refreshes the games found on the network
+type lanDiscoveryTickMsg struct{}
+
+type chatMsg struct {
+	chat *bnet.ChatPayload
+}
+
+type chatRejectedMsg struct {
+	reason string
+}
+
+// dial connects to the server and identifies this player
+func (m Model) dial() (*bnet.Connection, error) {
+	return m.dialAddress(m.ServerAddress)
+}
+
+// dialAddress connects to the server, or LAN host, at address and
+// identifies this player. Only the server is sent the secret it issued.
+func (m Model) dialAddress(address string) (*bnet.Connection, error) {
+	conn, err := m.connect(address)
+	if err != nil {
+		return nil, err
+	}
+
+	hello := bnet.HelloPayload{PlayerID: m.Identity.ID, Name: m.Identity.Name}
+	if !m.LAN {
+		hello.Secret = m.Identity.Secrets[m.ServerAddress]
+	}
+	if err := conn.Send(bnet.MsgHello, hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect connects to the server, or LAN host, at address
+func (m Model) connect(address string) (*bnet.Connection, error) {
+	var conn *bnet.Connection
+	if m.Dial != nil {
+		t, err := m.Dial(address)
+		if err != nil {
+			return nil, err
+		}
+		conn = bnet.NewConnection(t)
+	} else {
+		var err error
+		encoding := m.Encoding
+		if m.LAN {
+			encoding = bnet.EncodingJSON
+		}
+		conn, err = bnet.Connect(address, encoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// saveIdentity saves identity, with a secret the server just issued
+func saveIdentity(identity bnet.Identity) tea.Cmd {
 	return func() tea.Msg {
-		conn, err := bnet.Connect(m.ServerAddress)
+		bnet.SaveIdentity(identity) // If it fails, the secret lasts this session
+		return nil
+	}
+}
+
+// hostLAN starts hosting a game on the local network and creates its room,
+// whose code is the address the other player joins
+func (m Model) hostLAN() tea.Cmd {
+	return func() tea.Msg {
+		rules := fmt.Sprintf("%dx%d, %d ships, no shot clock", game.BoardSize, game.BoardSize, len(game.ShipDefinitions()))
+		conn, err := bnet.HostLAN(m.LANPort, bnet.LANGame{Name: m.Identity.Name, Rules: rules})
+		if err != nil {
+			return connectionErrorMsg{err: err}
+		}
+
+		hello := bnet.HelloPayload{PlayerID: m.Identity.ID, Name: m.Identity.Name}
+		if err := conn.Send(bnet.MsgHello, hello); err != nil {
+			conn.Close()
+			return connectionErrorMsg{err: err}
+		}
+		if err := conn.Send(bnet.MsgCreateRoom, bnet.CreateRoomPayload{}); err != nil {
+			conn.Close()
+			return connectionErrorMsg{err: err}
+		}
+
+		return connectionEstablishedMsg{conn: conn}
+	}
+}
+
+// fetchLeaderboard opens a short-lived connection to request the
+// leaderboard, without identifying this player
+func (m Model) fetchLeaderboard() tea.Cmd {
+	return func() tea.Msg {
+		conn, err := m.connect(m.ServerAddress)
+		if err != nil {
+			return connectionErrorMsg{err: err}
+		}
+		defer conn.Close()
+
+		if err := conn.Send(bnet.MsgGetLeaderboard, struct{}{}); err != nil {
+			return connectionErrorMsg{err: err}
+		}
+
+		for {
+			msg, err := conn.Receive()
+			if err != nil {
+				return connectionErrorMsg{err: err}
+			}
+
+			switch msg.Type {
+			case bnet.MsgLeaderboard:
+				payload, err := bnet.ParseLeaderboardPayload(msg.Payload)
+				if err != nil {
+					return connectionErrorMsg{err: err}
+				}
+				return leaderboardMsg{entries: payload.Entries}
+			case bnet.MsgJoinError:
+				payload, err := bnet.ParseErrorPayload(msg.Payload)
+				if err != nil {
+					return connectionErrorMsg{err: err}
+				}
+				return connectionErrorMsg{err: fmt.Errorf("%s", payload.Message)}
+			}
+		}
+	}
+}
+
+// connectAndCreateRoom connects to server and requests a room, either an
+// open one (create_room) or one against a server bot (create_bot_room)
+func (m Model) connectAndCreateRoom(request bnet.MessageType, payload any) tea.Cmd {
+	return func() tea.Msg {
+		conn, err := m.dial()
 		if err != nil {
 			return connectionErrorMsg{err: err}
 		}
 
 		// Send create room request
-		if err := conn.Send(bnet.MsgCreateRoom, struct{}{}); err != nil {
+		if err := conn.Send(request, payload); err != nil {
 			return connectionErrorMsg{err: err}
 		}
 
@@ -477,19 +1048,45 @@ func (m Model) updateMPHostWaiting(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 func (m Model) updateMPJoinInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "esc":
+		m.stopLANDiscovery()
 		m.State = StateMPMenu
 		return m, nil
+	case "up":
+		if m.LAN && m.LANSelection > 0 {
+			m.LANSelection--
+		}
+		return m, nil
+	case "down":
+		if m.LAN && m.LANSelection < len(m.LANGames)-1 {
+			m.LANSelection++
+		}
+		return m, nil
 	case "enter":
+		if m.LAN && m.RoomCode == "" {
+			if len(m.LANGames) == 0 {
+				return m, nil
+			}
+			m.RoomCode = m.LANGames[m.LANSelection].Address
+		}
+		m.stopLANDiscovery()
 		m.State = StateMPConnecting
 		m.Message = "Connecting..."
 		return m, func() tea.Msg {
-			conn, err := bnet.Connect(m.ServerAddress)
+			address := m.ServerAddress
+			if m.LAN {
+				address = "tcp://" + withDefaultPort(m.RoomCode, m.LANPort)
+			}
+			conn, err := m.dialAddress(address)
 			if err != nil {
 				return connectionErrorMsg{err: err}
 			}
 
-			// Join room
-			if err := conn.Send(bnet.MsgJoinRoom, bnet.JoinRoomPayload{Code: m.RoomCode}); err != nil {
+			// Join room, or watch it
+			msgType := bnet.MsgJoinRoom
+			if m.Spectating {
+				msgType = bnet.MsgSpectateRoom
+			}
+			if err := conn.Send(msgType, bnet.JoinRoomPayload{Code: m.RoomCode}); err != nil {
 				return connectionErrorMsg{err: err}
 			}
 
@@ -500,16 +1097,50 @@ func (m Model) updateMPJoinInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.RoomCode = m.RoomCode[:len(m.RoomCode)-1]
 		}
 	default:
-		// Uppercase only
-		if len(msg.String()) == 1 && len(m.RoomCode) < 4 {
-			char := msg.String()
-			// Very basic implementation, ideally force upper
-			m.RoomCode += char
+		if m.LAN {
+			if len(msg.String()) == 1 && len(m.RoomCode) < maxLANAddressLength {
+				m.RoomCode += msg.String()
+			}
+			return m, nil
+		}
+		// Room codes are uppercase; private codes are longer
+		if len(msg.String()) == 1 && len(m.RoomCode) < maxRoomCodeLength {
+			m.RoomCode += strings.ToUpper(msg.String())
 		}
 	}
 	return m, nil
 }
 
+// startLANDiscovery starts listening for games announced on the network
+func (m Model) startLANDiscovery() (tea.Model, tea.Cmd) {
+	m.LANGames = nil
+	m.LANSelection = 0
+	finder, err := bnet.FindLANGames()
+	if err != nil {
+		m.Message = "Can't look for games on the network; enter the host's address instead."
+		return m, nil
+	}
+	m.LANFinder = finder
+	return m, tickLANDiscovery()
+}
+
+// stopLANDiscovery stops listening for games announced on the network
+func (m *Model) stopLANDiscovery() {
+	if m.LANFinder != nil {
+		m.LANFinder.Close()
+		m.LANFinder = nil
+	}
+	m.LANGames = nil
+}
+
+// withDefaultPort adds port to address unless it has one
+func withDefaultPort(address string, port int) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(port))
+}
+
 // handleConnectionEstablished handles successful connection
 func (m Model) handleConnectionEstablished(msg connectionEstablishedMsg) (tea.Model, tea.Cmd) {
 	m.Connection = msg.conn
@@ -518,52 +1149,12 @@ func (m Model) handleConnectionEstablished(msg connectionEstablishedMsg) (tea.Mo
 	return m, m.messageLoop()
 }
 
-// messageLoop continuously reads messages from the connection
+// messageLoop waits for the next message from the server. Update starts it
+// again after each message that keeps the connection in use.
 func (m Model) messageLoop() tea.Cmd {
+	conn := m.Connection
 	return func() tea.Msg {
-		msg, err := m.Connection.Receive()
-		if err != nil {
-			// Assuming naive disconnect
-			return connectionErrorMsg{err: err}
-		}
-
-		switch msg.Type {
-		case bnet.MsgRoomCreated:
-			payload, _ := bnet.ParseCreateRoomResponse(msg.Payload)
-			return roomCreatedMsg{code: payload.Code}
-
-		case bnet.MsgJoinError:
-			payload, _ := bnet.ParseErrorPayload(msg.Payload)
-			return joinErrorMsg{err: payload.Message}
-
-		case bnet.MsgGameStart: // Guest joined or Host notified
-			return playerJoinedMsg{}
-
-		case bnet.MsgPlayerJoined: // Host notified
-			return playerJoinedMsg{}
-
-		case bnet.MsgShipsPlaced:
-			return opponentReadyMsg{}
-
-		case bnet.MsgAttack:
-			payload, _ := bnet.ParseAttackPayload(msg.Payload)
-			return opponentAttackMsg{row: payload.Row, col: payload.Col}
-
-		case bnet.MsgAttackResult:
-			payload, _ := bnet.ParseAttackResultPayload(msg.Payload)
-			return attackResultMsg{hit: payload.Hit, sunkShipName: payload.SunkShipName}
-
-		case bnet.MsgGameOver:
-			payload, _ := bnet.ParseGameOverPayload(msg.Payload)
-			return opponentGameOverMsg{youWon: payload.YouWon}
-
-		case bnet.MsgOpponentLeft:
-			return opponentLeftMsg{}
-		}
-
-		// Continue loop if not handled or non-terminal
-		// We rely on the Update function to restart the loop for valid messages
-		return nil
+		return receiveMessage(conn)
 	}
 }
 
@@ -608,14 +1199,14 @@ func (m Model) updateMPPlacement(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			if m.CurrentShipIndex >= len(m.ShipsToPlace) {
 				// All ships placed, notify opponent
 				m.ShipsPlaced = true
-				m.Connection.Send(bnet.MsgShipsPlaced, struct{}{})
+				m.Connection.Send(bnet.MsgShipsPlaced, bnet.ShipsPlacedPayload{Fleet: fleetPlacements(m.PlayerBoard)})
 
 				if m.OpponentReady {
 					// Both ready, start battle
 					m.State = StateMPBattle
 					m.CursorRow = 0
 					m.CursorCol = 0
-					m.PlayerTurn = m.IsHost
+					m.PlayerTurn = m.IsHost == m.HostMovesFirst
 					if m.PlayerTurn {
 						m.Message = "Battle begins! Your turn."
 					} else {
@@ -646,7 +1237,7 @@ func (m Model) handleOpponentReady() (tea.Model, tea.Cmd) {
 		m.State = StateMPBattle
 		m.CursorRow = 0
 		m.CursorCol = 0
-		m.PlayerTurn = m.IsHost
+		m.PlayerTurn = m.IsHost == m.HostMovesFirst
 		if m.PlayerTurn {
 			m.Message = "Battle begins! Your turn."
 		} else {
@@ -723,8 +1314,7 @@ func (m Model) handleOpponentAttack(msg opponentAttackMsg) (tea.Model, tea.Cmd)
 
 		if m.PlayerBoard.AllShipsSunk() {
 			m.Connection.Send(bnet.MsgGameOver, bnet.GameOverPayload{YouWon: true})
-			m.State = StateGameOver
-			m.PlayerWon = false
+			m.endMPGame(false)
 			return m, nil
 		}
 	} else {
@@ -758,3 +1348,291 @@ func (m Model) handleAttackResult(msg attackResultMsg) (tea.Model, tea.Cmd) {
 
 	return m, nil
 }
+
+// ========== Spectator Methods ==========
+
+// updateSpectating handles input while watching a game
+func (m Model) updateSpectating(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.cleanup()
+		m.Spectating = false
+		m.State = StateMPMenu
+		m.Message = ""
+	}
+	return m, nil
+}
+
+// applySpectateState rebuilds the spectator view from a full room state
+func (m *Model) applySpectateState(state *bnet.SpectateStatePayload) {
+	m.RoomCode = state.Code
+	m.HostName = state.HostName
+	m.GuestName = state.GuestName
+	m.SpectatorCount = state.Spectators
+	m.SpectateWinner = state.Winner
+	m.HostBoard = game.NewBoard()
+	m.GuestBoard = game.NewBoard()
+
+	for i := range state.Shots {
+		m.applySpectatorShot(&state.Shots[i])
+	}
+	applyFleet(m.HostBoard, state.HostFleet)
+	applyFleet(m.GuestBoard, state.GuestFleet)
+
+	switch {
+	case state.Winner != "":
+		m.Message = m.spectatorName(state.Winner) + " wins!"
+	case state.GuestName == "":
+		m.Message = "Waiting for a second player to join..."
+	default:
+		m.Message = ""
+	}
+}
+
+// applySpectatorShot marks a resolved shot on the defender's board
+func (m *Model) applySpectatorShot(shot *bnet.SpectatorShotPayload) {
+	target := m.GuestBoard
+	if shot.Shooter == bnet.RoleGuest {
+		target = m.HostBoard
+	}
+	if shot.Row < 0 || shot.Row >= game.BoardSize || shot.Col < 0 || shot.Col >= game.BoardSize {
+		return
+	}
+
+	if shot.Hit {
+		target.Cells[shot.Row][shot.Col] = game.Hit
+	} else {
+		target.Cells[shot.Row][shot.Col] = game.Miss
+	}
+
+	cell := fmt.Sprintf("%c%d", 'A'+shot.Col, shot.Row+1)
+	switch {
+	case shot.SunkShipName != "":
+		m.Message = fmt.Sprintf("%s fires at %s: sunk the %s!", m.spectatorName(shot.Shooter), cell, shot.SunkShipName)
+	case shot.Hit:
+		m.Message = fmt.Sprintf("%s fires at %s: HIT!", m.spectatorName(shot.Shooter), cell)
+	default:
+		m.Message = fmt.Sprintf("%s fires at %s: miss.", m.spectatorName(shot.Shooter), cell)
+	}
+}
+
+// spectatorName returns the display name of the player with the given role
+func (m Model) spectatorName(role string) string {
+	name := m.HostName
+	if role == bnet.RoleGuest {
+		name = m.GuestName
+	}
+	if name == "" {
+		name = role
+	}
+	return name
+}
+
+// applyFleet marks revealed ship positions on a spectator board
+func applyFleet(board *game.Board, fleet []bnet.ShipPlacement) {
+	for _, ship := range fleet {
+		for _, pos := range ship.Positions {
+			if pos[0] < 0 || pos[0] >= game.BoardSize || pos[1] < 0 || pos[1] >= game.BoardSize {
+				continue
+			}
+			if board.Cells[pos[0]][pos[1]] == game.Empty {
+				board.Cells[pos[0]][pos[1]] = game.ShipCell
+			}
+		}
+	}
+}
+
+// fleetPlacements describes the ships on a board for the server
+func fleetPlacements(board *game.Board) []bnet.ShipPlacement {
+	fleet := make([]bnet.ShipPlacement, 0, len(board.Ships))
+	for _, ship := range board.Ships {
+		fleet = append(fleet, bnet.ShipPlacement{Name: ship.Name, Positions: ship.Positions})
+	}
+	return fleet
+}
+
+// ========== Rematch Methods ==========
+
+// endMPGame finishes a multiplayer game and updates the series score
+func (m *Model) endMPGame(won bool) {
+	m.State = StateGameOver
+	m.PlayerWon = won
+	m.RematchRequested = false
+	m.RematchOffered = false
+	m.Message = ""
+	m.TurnDeadline = time.Time{}
+	m.PlacementDeadline = time.Time{}
+	if won {
+		m.SeriesWins++
+	} else {
+		m.SeriesLosses++
+	}
+}
+
+// canRematch reports whether a rematch can be offered from the game over screen
+func (m Model) canRematch() bool {
+	return m.GameMode == ModeMultiplayer && m.Connection != nil
+}
+
+// startRematch resets both boards for the next game of the series
+func (m *Model) startRematch(start *bnet.RematchStartPayload) {
+	m.PlayerBoard = game.NewBoard()
+	m.OpponentBoard = game.NewBoard()
+	m.ShipsToPlace = game.ShipDefinitions()
+	m.CurrentShipIndex = 0
+	m.PlacingHorizontal = true
+	m.ShipsPlaced = false
+	m.OpponentReady = false
+	m.PlayerWon = false
+	m.RematchRequested = false
+	m.RematchOffered = false
+	m.CursorRow = 0
+	m.CursorCol = 0
+
+	m.SeriesGame = start.Game
+	m.HostMovesFirst = start.HostMovesFirst
+	if m.IsHost {
+		m.SeriesWins, m.SeriesLosses = start.HostWins, start.GuestWins
+	} else {
+		m.SeriesWins, m.SeriesLosses = start.GuestWins, start.HostWins
+	}
+
+	m.State = StateMPPlacement
+	if m.IsHost == m.HostMovesFirst {
+		m.Message = fmt.Sprintf("Rematch! Game %d - you move first. Place your ships.", m.SeriesGame)
+	} else {
+		m.Message = fmt.Sprintf("Rematch! Game %d - opponent moves first. Place your ships.", m.SeriesGame)
+	}
+}
+
+// ========== Shot Clock Methods ==========
+
+// handleTurnTimeout applies the server's action when a player runs out of time
+func (m *Model) handleTurnTimeout(t *bnet.TurnTimeoutPayload) {
+	mine := t.Role == m.role()
+	m.TurnDeadline = time.Time{}
+
+	switch t.Action {
+	case bnet.TimeoutForfeit:
+		if mine {
+			m.Message = "You ran out of time and forfeit the game."
+		} else {
+			m.Message = "Opponent ran out of time and forfeits the game."
+		}
+	case bnet.TimeoutRandom:
+		if mine {
+			// The server fired for us; the result arrives as usual
+			m.LastAttackRow = t.Row
+			m.LastAttackCol = t.Col
+			m.PlayerTurn = false
+			m.Message = fmt.Sprintf("Out of time! A random shot was fired at %c%d.", 'A'+t.Col, t.Row+1)
+		} else {
+			m.Message = "Opponent ran out of time. A random shot was fired for them."
+		}
+	default: // bnet.TimeoutSkip
+		m.PlayerTurn = !mine
+		if mine {
+			m.Message = "Out of time! Your turn was skipped."
+		} else {
+			m.Message = "Opponent ran out of time. Your turn."
+		}
+	}
+}
+
+// startClockTick starts redrawing the countdown every second, unless it already is
+func (m *Model) startClockTick() tea.Cmd {
+	if m.ClockTicking {
+		return nil
+	}
+	m.ClockTicking = true
+	return tickClock()
+}
+
+// clockActive reports whether a countdown is still on screen
+func (m Model) clockActive() bool {
+	switch m.State {
+	case StateMPPlacement, StateMPWaitingForOpponent:
+		return time.Now().Before(m.PlacementDeadline)
+	case StateMPBattle:
+		return time.Now().Before(m.TurnDeadline)
+	}
+	return false
+}
+
+func tickClock() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return clockTickMsg{}
+	})
+}
+
+func tickLANDiscovery() tea.Cmd {
+	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg {
+		return lanDiscoveryTickMsg{}
+	})
+}
+
+// ========== Chat Methods ==========
+
+// chatAvailable reports whether the current screen has a chat pane
+func (m Model) chatAvailable() bool {
+	if m.Connection == nil || m.Spectating {
+		return false
+	}
+	switch m.State {
+	case StateMPHostWaiting, StateMPPlacement, StateMPWaitingForOpponent, StateMPBattle:
+		return true
+	}
+	return false
+}
+
+// updateChat handles input while the chat input is focused
+func (m Model) updateChat(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyTab:
+		m.ChatFocused = false
+	case tea.KeyEnter:
+		text := strings.TrimSpace(m.ChatInput)
+		if text != "" && m.Connection != nil {
+			m.Connection.Send(bnet.MsgChat, bnet.ChatPayload{Text: text})
+		}
+		m.ChatInput = ""
+		m.ChatScroll = 0
+	case tea.KeyBackspace:
+		if runes := []rune(m.ChatInput); len(runes) > 0 {
+			m.ChatInput = string(runes[:len(runes)-1])
+		}
+	case tea.KeyUp, tea.KeyPgUp:
+		if m.ChatScroll < len(m.ChatHistory)-1 {
+			m.ChatScroll++
+		}
+	case tea.KeyDown, tea.KeyPgDown:
+		if m.ChatScroll > 0 {
+			m.ChatScroll--
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		if len([]rune(m.ChatInput))+len(msg.Runes) <= maxChatInput {
+			m.ChatInput += string(msg.Runes)
+		}
+	}
+	return m, nil
+}
+
+// appendChat adds a line to the scrollback, keeping the view in place if
+// the player has scrolled back
+func (m *Model) appendChat(line ChatLine) {
+	m.ChatHistory = append(m.ChatHistory, line)
+	if len(m.ChatHistory) > maxChatHistory {
+		m.ChatHistory = m.ChatHistory[len(m.ChatHistory)-maxChatHistory:]
+	}
+	if m.ChatScroll > 0 && m.ChatScroll < len(m.ChatHistory)-1 {
+		m.ChatScroll++
+	}
+}
+
+// role returns this player's role in the room
+func (m Model) role() string {
+	if m.IsHost {
+		return bnet.RoleHost
+	}
+	return bnet.RoleGuest
+}
@@ -0,0 +1,120 @@
+package ui
+
+import (
+	"errors"
+	"testing"
+
+	"battle-ship/game"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newTestModel returns a fresh model whose identity is kept out of the
+// user's config directory.
+func newTestModel(t *testing.T) Model {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	return NewModel()
+}
+
+// failingOpponent is an AI that can't place its ships.
+type failingOpponent struct {
+	game.Opponent
+}
+
+func (failingOpponent) PlaceShips(*game.Board) error {
+	return errors.New("no fleet")
+}
+
+func (failingOpponent) Close() error {
+	return nil
+}
+
+// placeLastShip places every ship but the last directly, then the last one
+// with the enter key, as a player would.
+func placeLastShip(t *testing.T, m Model) (Model, tea.Cmd) {
+	t.Helper()
+	m.State = StatePlacement
+	for i, ship := range m.ShipsToPlace[:len(m.ShipsToPlace)-1] {
+		if !m.PlayerBoard.PlaceShip(ship, i+1, 0, true) {
+			t.Fatalf("failed to place %s", ship.Name)
+		}
+	}
+	m.CurrentShipIndex = len(m.ShipsToPlace) - 1
+
+	next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	return next.(Model), cmd
+}
+
+func TestPlacementWaitsForAI(t *testing.T) {
+	m, cmd := placeLastShip(t, newTestModel(t))
+	if m.State != StatePlacement {
+		t.Fatalf("state = %v before the AI placed its ships, want placement", m.State)
+	}
+	if cmd == nil {
+		t.Fatal("no command to place the AI's ships")
+	}
+
+	// Keys are ignored while the AI places its ships
+	next, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if next.(Model).State != StatePlacement {
+		t.Fatal("a key press left placement before the AI placed its ships")
+	}
+
+	next, _ = m.Update(cmd())
+	m = next.(Model)
+	if m.State != StateBattle {
+		t.Fatalf("state = %v after the AI placed its ships, want battle", m.State)
+	}
+	if len(m.AIBoard.Ships) != len(game.ShipDefinitions()) {
+		t.Errorf("AI placed %d ships, want %d", len(m.AIBoard.Ships), len(game.ShipDefinitions()))
+	}
+}
+
+func TestPlacementFailureForfeits(t *testing.T) {
+	m := newTestModel(t)
+	m.Opponent = failingOpponent{}
+	m, cmd := placeLastShip(t, m)
+
+	next, _ := m.Update(cmd())
+	m = next.(Model)
+	if m.State != StateGameOver || !m.PlayerWon || m.ForfeitReason != "no fleet" {
+		t.Errorf("state %v, won %v, reason %q; want the AI to forfeit", m.State, m.PlayerWon, m.ForfeitReason)
+	}
+}
+
+func TestStalePlacementIgnored(t *testing.T) {
+	m, cmd := placeLastShip(t, newTestModel(t))
+	msg := cmd()
+
+	m = m.newGame()
+	next, _ := m.Update(msg)
+	if next.(Model).State != StateMenu {
+		t.Errorf("state = %v after a placement for a game that was left, want menu", next.(Model).State)
+	}
+}
+
+func TestQTypedIntoRoomCode(t *testing.T) {
+	m := newTestModel(t)
+	m.State = StateMPJoinInput
+	for _, key := range "aqz" {
+		next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{key}})
+		if cmd != nil {
+			if _, quit := cmd().(tea.QuitMsg); quit {
+				t.Fatalf("typing %q quit the game", key)
+			}
+		}
+		m = next.(Model)
+	}
+	if m.RoomCode != "AQZ" {
+		t.Errorf("room code = %q, want AQZ", m.RoomCode)
+	}
+
+	m.State = StateMenu
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}}); cmd == nil {
+		t.Error("q did not quit from the menu")
+	} else if _, quit := cmd().(tea.QuitMsg); !quit {
+		t.Error("q did not quit from the menu")
+	}
+}
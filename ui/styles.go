@@ -2,6 +2,13 @@ package ui
 
 import "github.com/charmbracelet/lipgloss"
 
+const (
+	// chatWidth is the width of the chat pane's text, in columns
+	chatWidth = 30
+	// chatLines is how many scrollback lines the chat pane shows
+	chatLines = 12
+)
+
 var (
 	// Colors
 	waterColor    = lipgloss.Color("#1E3A5F")
@@ -119,6 +126,17 @@ var (
 			Foreground(lipgloss.Color("#A0AEC0")).
 			MarginLeft(2)
 
+	// Chat styles
+	chatTextStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#E2E8F0"))
+
+	chatOwnStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#63B3ED"))
+
+	chatSystemStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#718096")).
+			Italic(true)
+
 	// Input field style
 	inputStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#63B3ED")).
@@ -3,8 +3,10 @@ package ui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"battle-ship/game"
+	bnet "battle-ship/net"
 
 	"github.com/charmbracelet/lipgloss"
 )
@@ -13,6 +15,18 @@ import (
 var menuOptions = []string{
 	"Play vs AI",
 	"Multiplayer", // Changed from "Host Game" to just "Multiplayer"
+	"Leaderboard",
+}
+
+// Multiplayer submenu options
+var mpMenuOptions = []string{
+	"Host Game (Create Room)",
+	"Host Private Game (Unguessable Code)",
+	"Join Game (Enter Code)",
+	"Spectate Game (Enter Code)",
+	"Play Online vs Server Bot",
+	"Host Game on LAN",
+	"Join Game on LAN (Enter IP:Port)",
 }
 
 // renderMenuWithSelection renders the main menu with selection
@@ -53,14 +67,9 @@ func (m Model) renderMenuWithSelection() string {
 func (m Model) renderMPMenu() string {
 	title := titleStyle.Render("MULTIPLAYER")
 
-	options := []string{
-		"Host Game (Create Room)",
-		"Join Game (Enter Code)",
-	}
-
 	var menuItems strings.Builder
 	menuItems.WriteString("\n\n")
-	for i, option := range options {
+	for i, option := range mpMenuOptions {
 		if i == m.MenuSelection {
 			menuItems.WriteString(selectedMenuStyle.Render("▸ " + option))
 		} else {
@@ -115,7 +124,7 @@ func (m Model) renderPlacement() string {
 
 		shipInfo = fmt.Sprintf("Placing: %s (length: %d) - %s", ship.Name, ship.Length, orientation)
 	} else {
-		shipInfo = "All ships placed!"
+		shipInfo = "All ships placed! Waiting for the opponent to place its ships..."
 	}
 	sb.WriteString(messageStyle.Render(shipInfo) + "\n\n")
 
@@ -307,7 +316,11 @@ func (m Model) renderGameOver() string {
    ╚═══╝  ╚═╝ ╚═════╝   ╚═╝    ╚═════╝ ╚═╝  ╚═╝   ╚═╝   ╚═╝
 `)
 		sb.WriteString(resultText + "\n")
-		sb.WriteString(successStyle.Render("You sunk all enemy ships!") + "\n")
+		if m.ForfeitReason != "" {
+			sb.WriteString(successStyle.Render("Your opponent forfeited: "+m.ForfeitReason) + "\n")
+		} else {
+			sb.WriteString(successStyle.Render("You sunk all enemy ships!") + "\n")
+		}
 	} else {
 		resultText = errorStyle.Render(`
  ██████╗ ███████╗███████╗███████╗ █████╗ ████████╗
@@ -321,12 +334,90 @@ func (m Model) renderGameOver() string {
 		sb.WriteString(errorStyle.Render("The enemy sunk all your ships!") + "\n")
 	}
 
+	if m.canRematch() {
+		sb.WriteString(m.renderSeriesScore() + "\n")
+
+		var prompt string
+		switch {
+		case m.RematchRequested:
+			prompt = "Waiting for opponent to accept the rematch..."
+		case m.RematchOffered:
+			prompt = "Opponent wants a rematch! Press Y to accept or N to decline."
+		default:
+			prompt = "Rematch? Press Y to ask your opponent."
+		}
+		sb.WriteString(messageStyle.Render(prompt))
+
+		if m.Message != "" {
+			sb.WriteString("\n" + messageStyle.Render(m.Message))
+		}
+
+		help := helpStyle.Render("\nY: Rematch  |  N / Enter: Leave  |  Q: Quit")
+		sb.WriteString(help)
+
+		return containerStyle.Render(sb.String())
+	}
+
 	help := helpStyle.Render("\nPress ENTER to play again  |  Press Q to quit")
 	sb.WriteString(help)
 
 	return containerStyle.Render(sb.String())
 }
 
+// renderCountdown renders the time left until deadline, if one is running
+func (m Model) renderCountdown(deadline time.Time) string {
+	if deadline.IsZero() {
+		return ""
+	}
+	left := time.Until(deadline).Round(time.Second)
+	if left < 0 {
+		left = 0
+	}
+
+	clock := fmt.Sprintf("  ⏱ %d:%02d", int(left.Minutes()), int(left.Seconds())%60)
+	if left <= 10*time.Second {
+		return errorStyle.Bold(true).Render(clock)
+	}
+	return statusStyle.UnsetMarginTop().Render(clock)
+}
+
+// renderSeriesScore renders the running score against the current opponent
+func (m Model) renderSeriesScore() string {
+	return statusStyle.Render(fmt.Sprintf("Game %d  |  Series: You %d - %d Opponent", m.SeriesGame, m.SeriesWins, m.SeriesLosses))
+}
+
+// renderLeaderboard renders the server's rating leaderboard
+func (m Model) renderLeaderboard() string {
+	var sb strings.Builder
+
+	sb.WriteString(titleStyle.Render("LEADERBOARD") + "\n")
+
+	if len(m.Leaderboard) == 0 {
+		if m.Message == "" {
+			sb.WriteString(messageStyle.Render("No rated games yet.") + "\n")
+		}
+	} else {
+		sb.WriteString(headerStyle.Render(fmt.Sprintf(" %-4s %-24s %6s %5s %5s", "#", "PLAYER", "RATING", "W", "L")) + "\n")
+		for _, e := range m.Leaderboard {
+			line := fmt.Sprintf(" %-4d %-24s %6.0f %5d %5d", e.Rank, e.Name, e.Rating, e.Wins, e.Losses)
+			if e.Player == bnet.PublicPlayerID(m.Identity.ID) {
+				sb.WriteString(selectedMenuStyle.UnsetMarginLeft().Render(line) + "\n")
+			} else {
+				sb.WriteString(menuItemStyle.UnsetMarginLeft().Render(line) + "\n")
+			}
+		}
+	}
+
+	if m.Message != "" {
+		sb.WriteString(messageStyle.Render(m.Message))
+	}
+
+	help := helpStyle.Render("\nEnter/Esc: Back  |  Q: Quit")
+	sb.WriteString(help)
+
+	return containerStyle.Render(sb.String())
+}
+
 // ========== Multiplayer Views ==========
 
 // renderMPHostWaiting renders the waiting for connection screen
@@ -335,21 +426,35 @@ func (m Model) renderMPHostWaiting() string {
 
 	waiting := messageStyle.Render(fmt.Sprintf("\n\nWaiting for opponent to join Room: %s", m.RoomCode))
 	hint := helpStyle.Render("\nOther player should select 'Join Game' and enter this code.")
+	if m.LAN {
+		waiting = messageStyle.Render(fmt.Sprintf("\n\nWaiting for opponent to join at: %s", m.RoomCode))
+		hint = helpStyle.Render("\nOther player should select 'Join Game on LAN' and enter this address.")
+	}
 
-	help := helpStyle.Render("\n\nPress ESC to cancel")
+	help := helpStyle.Render("\n\nPress ESC to cancel  |  Tab: Chat")
 
-	return containerStyle.Render(title + waiting + hint + help)
+	return containerStyle.Render(title + waiting + hint + "\n\n" + m.renderChatPane() + help)
 }
 
 // renderMPJoinInput renders the join game input screen
 func (m Model) renderMPJoinInput() string {
 	title := titleStyle.Render("JOIN GAME")
+	if m.Spectating {
+		title = titleStyle.Render("SPECTATE GAME")
+	}
 
 	prompt := messageStyle.Render("\n\nEnter Room Code:")
+	if m.LAN {
+		title = titleStyle.Render("JOIN LAN GAME")
+		prompt = m.renderLANGames() + messageStyle.Render("\n\nOr enter Host Address (IP:Port):")
+	}
 
 	address := inputStyle.Render("\n" + m.RoomCode + "█")
 
 	help := helpStyle.Render("\n\nPress ENTER to connect  |  ESC to cancel")
+	if m.LAN {
+		help = helpStyle.Render("\n\n↑↓: Select game  |  ENTER: Connect  |  ESC: Cancel")
+	}
 
 	errorMsg := ""
 	if m.Message != "" {
@@ -359,6 +464,30 @@ func (m Model) renderMPJoinInput() string {
 	return containerStyle.Render(title + prompt + address + help + errorMsg)
 }
 
+// renderLANGames lists the games announced on the network
+func (m Model) renderLANGames() string {
+	var sb strings.Builder
+	sb.WriteString(messageStyle.Render("\n\nGames on your network:") + "\n")
+	if m.LANFinder == nil {
+		sb.WriteString(helpStyle.Render("  (not looking)"))
+		return sb.String()
+	}
+	if len(m.LANGames) == 0 {
+		sb.WriteString(helpStyle.Render("  Looking for games..."))
+		return sb.String()
+	}
+	for i, g := range m.LANGames {
+		line := fmt.Sprintf("%s  %s  %s", g.Name, g.Address, g.Rules)
+		if i == m.LANSelection && m.RoomCode == "" {
+			sb.WriteString(selectedMenuStyle.Render("▸ " + line))
+		} else {
+			sb.WriteString(menuItemStyle.Render("  " + line))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
 // renderMPPlacement renders multiplayer ship placement
 func (m Model) renderMPPlacement() string {
 	var sb strings.Builder
@@ -386,13 +515,14 @@ func (m Model) renderMPPlacement() string {
 		shipInfo = "All ships placed! Waiting for opponent..."
 	}
 
-	sb.WriteString(messageStyle.Render(shipInfo) + "\n\n")
+	sb.WriteString(messageStyle.Render(shipInfo) + m.renderCountdown(m.PlacementDeadline) + "\n\n")
 
-	// Render the board with placement preview
-	sb.WriteString(m.renderPlacementBoard())
+	// Render the board with placement preview, chat alongside
+	sb.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, m.renderPlacementBoard(), "  ", m.renderChatPane()))
+	sb.WriteString(m.renderSpectatorCount())
 
 	// Instructions
-	help := helpStyle.Render("\n↑↓←→: Move  |  R: Rotate  |  Enter: Place Ship  |  Q: Quit")
+	help := helpStyle.Render("\n↑↓←→: Move  |  R: Rotate  |  Enter: Place Ship  |  Tab: Chat  |  Q: Quit")
 	sb.WriteString(help)
 
 	if m.Message != "" {
@@ -406,12 +536,12 @@ func (m Model) renderMPPlacement() string {
 func (m Model) renderMPWaiting() string {
 	title := titleStyle.Render("SHIPS PLACED!")
 
-	waiting := messageStyle.Render("\n\nWaiting for opponent to place their ships...")
+	waiting := messageStyle.Render("\n\nWaiting for opponent to place their ships...") + m.renderCountdown(m.PlacementDeadline)
 
-	// Show player's board
-	board := "\n\n" + m.renderPlayerBoardBattle()
+	// Show player's board, chat alongside
+	board := "\n\n" + lipgloss.JoinHorizontal(lipgloss.Top, m.renderPlayerBoardBattle(), "  ", m.renderChatPane()) + m.renderSpectatorCount()
 
-	help := helpStyle.Render("\n\nPlease wait...")
+	help := helpStyle.Render("\n\nPlease wait...  |  Tab: Chat")
 
 	if m.Message != "" {
 		help += "\n" + messageStyle.Render(m.Message)
@@ -433,6 +563,9 @@ func (m Model) renderMPBattle() string {
 
 	title := titleStyle.Render("MULTIPLAYER BATTLE!" + modeLabel)
 	sb.WriteString(title + "\n")
+	if m.SeriesGame > 1 {
+		sb.WriteString(m.renderSeriesScore() + "\n")
+	}
 
 	// Turn indicator
 	var turnText string
@@ -441,14 +574,15 @@ func (m Model) renderMPBattle() string {
 	} else {
 		turnText = "OPPONENT'S TURN..."
 	}
-	sb.WriteString(messageStyle.Render(turnText) + "\n\n")
+	sb.WriteString(messageStyle.Render(turnText) + m.renderCountdown(m.TurnDeadline) + "\n\n")
 
 	// Render both boards side by side
 	playerBoard := m.renderPlayerBoardBattle()
 	opponentBoard := m.renderEnemyBoard(m.OpponentBoard)
 
-	boards := lipgloss.JoinHorizontal(lipgloss.Top, playerBoard, "    ", opponentBoard)
+	boards := lipgloss.JoinHorizontal(lipgloss.Top, playerBoard, "    ", opponentBoard, "  ", m.renderChatPane())
 	sb.WriteString(boards)
+	sb.WriteString(m.renderSpectatorCount())
 
 	// Message
 	if m.Message != "" {
@@ -456,12 +590,180 @@ func (m Model) renderMPBattle() string {
 	}
 
 	// Instructions
-	help := helpStyle.Render("\n↑↓←→: Move cursor  |  Enter: Fire  |  Q: Quit")
+	help := helpStyle.Render("\n↑↓←→: Move cursor  |  Enter: Fire  |  Tab: Chat  |  Q: Quit")
+	sb.WriteString(help)
+
+	return containerStyle.Render(sb.String())
+}
+
+// renderChatPane renders the chat scrollback and input box
+func (m Model) renderChatPane() string {
+	var lines []string
+	for _, line := range m.ChatHistory {
+		var text string
+		switch {
+		case line.System:
+			text = "* " + line.Text
+		default:
+			text = line.From + ": " + line.Text
+		}
+
+		style := chatTextStyle
+		switch {
+		case line.System:
+			style = chatSystemStyle
+		case line.Own:
+			style = chatOwnStyle
+		}
+		for _, wrapped := range wrapText(text, chatWidth) {
+			lines = append(lines, style.Render(wrapped))
+		}
+	}
+
+	// Show a window of the scrollback, ChatScroll lines up from the bottom
+	end := len(lines) - m.ChatScroll
+	if end < 0 {
+		end = 0
+	}
+	start := end - chatLines
+	if start < 0 {
+		start = 0
+	}
+	visible := lines[start:end]
+	for len(visible) < chatLines {
+		visible = append([]string{""}, visible...)
+	}
+
+	var sb strings.Builder
+	title := "CHAT"
+	if m.ChatScroll > 0 {
+		title = fmt.Sprintf("CHAT (↑%d)", m.ChatScroll)
+	}
+	sb.WriteString(boardTitleStyle.Render(title) + "\n")
+	sb.WriteString(strings.Join(visible, "\n") + "\n")
+
+	if !m.Spectating {
+		input := m.ChatInput
+		if runes := []rune(input); len(runes) > chatWidth-5 {
+			input = string(runes[len(runes)-(chatWidth-5):])
+		}
+		if m.ChatFocused {
+			sb.WriteString(inputStyle.Width(chatWidth).Render("> " + input + "█"))
+		} else {
+			sb.WriteString(chatSystemStyle.Render("Tab to chat"))
+		}
+	}
+
+	return boardStyle.Width(chatWidth + 4).Render(sb.String())
+}
+
+// wrapText splits text into lines of at most width runes, breaking on spaces where possible
+func wrapText(text string, width int) []string {
+	var lines []string
+	var current []rune
+	for _, word := range strings.Fields(text) {
+		w := []rune(word)
+		for len(w) > width {
+			if len(current) > 0 {
+				lines = append(lines, string(current))
+				current = nil
+			}
+			lines = append(lines, string(w[:width]))
+			w = w[width:]
+		}
+		switch {
+		case len(current) == 0:
+			current = w
+		case len(current)+1+len(w) <= width:
+			current = append(append(current, ' '), w...)
+		default:
+			lines = append(lines, string(current))
+			current = w
+		}
+	}
+	if len(current) > 0 {
+		lines = append(lines, string(current))
+	}
+	return lines
+}
+
+// renderSpectatorCount renders how many spectators are watching, if any
+func (m Model) renderSpectatorCount() string {
+	if m.SpectatorCount == 0 {
+		return ""
+	}
+	return "\n" + statusStyle.Render(fmt.Sprintf("Spectators watching: %d", m.SpectatorCount))
+}
+
+// ========== Spectator Views ==========
+
+// renderSpectating renders both players' waters for a spectator
+func (m Model) renderSpectating() string {
+	var sb strings.Builder
+
+	title := titleStyle.Render("SPECTATING ROOM " + m.RoomCode)
+	sb.WriteString(title + "\n")
+
+	status := fmt.Sprintf("%s vs %s", m.spectatorName(bnet.RoleHost), m.spectatorName(bnet.RoleGuest))
+	if m.GuestName == "" {
+		status = fmt.Sprintf("%s vs ...", m.spectatorName(bnet.RoleHost))
+	}
+	sb.WriteString(messageStyle.Render(status) + "\n\n")
+
+	// The host's board shows the guest's shots and vice versa
+	hostBoard := m.renderSpectatorBoard(strings.ToUpper(m.spectatorName(bnet.RoleHost))+"'S FLEET", m.HostBoard)
+	guestBoard := m.renderSpectatorBoard(strings.ToUpper(m.spectatorName(bnet.RoleGuest))+"'S FLEET", m.GuestBoard)
+
+	boards := lipgloss.JoinHorizontal(lipgloss.Top, hostBoard, "    ", guestBoard, "  ", m.renderChatPane())
+	sb.WriteString(boards)
+	sb.WriteString("\n" + statusStyle.Render(fmt.Sprintf("Spectators watching: %d", m.SpectatorCount)))
+
+	// Message
+	if m.Message != "" {
+		sb.WriteString("\n" + messageStyle.Render(m.Message))
+	}
+
+	// Instructions
+	help := helpStyle.Render("\nEsc: Stop watching  |  Q: Quit")
 	sb.WriteString(help)
 
 	return containerStyle.Render(sb.String())
 }
 
+// renderSpectatorBoard renders a board with shots and any revealed ships
+func (m Model) renderSpectatorBoard(title string, board *game.Board) string {
+	var sb strings.Builder
+
+	sb.WriteString(boardTitleStyle.Render(title) + "\n")
+
+	// Column headers
+	sb.WriteString("    ")
+	for c := 0; c < game.BoardSize; c++ {
+		sb.WriteString(headerStyle.Render(fmt.Sprintf(" %c ", 'A'+c)))
+	}
+	sb.WriteString("\n")
+
+	// Rows
+	for r := 0; r < game.BoardSize; r++ {
+		sb.WriteString(headerStyle.Render(fmt.Sprintf(" %2d ", r+1)))
+		for c := 0; c < game.BoardSize; c++ {
+			switch board.Cells[r][c] {
+			case game.Hit:
+				sb.WriteString(hitCell.Render("X"))
+			case game.Miss:
+				sb.WriteString(missCell.Render("•"))
+			case game.ShipCell:
+				sb.WriteString(shipCell.Render("█"))
+			default:
+				sb.WriteString(waterCell.Render("~"))
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	return boardStyle.Render(sb.String())
+}
+
 // renderMPOpponentBoard renders the opponent's board in multiplayer
 // Reused renderEnemyBoard by making it accept a Board param instead of defaulting to AIBoard, or just adding this wrapper.
 // I updated renderEnemyBoard earlier to take a board param.